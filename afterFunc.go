@@ -0,0 +1,97 @@
+package state
+
+import (
+	"context"
+	"sync"
+)
+
+type afterFuncState struct {
+	*group
+
+	stop     func() bool // guarded by the lock, as ctx may be done already
+	finished chan struct{}
+
+	sync.Mutex
+}
+
+// WithAfterFunc returns new State with merged children that is shut down
+// when ctx is done.
+//
+// The shutdown is registered with context.AfterFunc, so no goroutine is
+// parked waiting for ctx. If the state is shut down before ctx is done,
+// the registration is stopped.
+//
+// Shutting down on ctx does not wait for completion - use State's
+// Shutdown to wait until children are shut down.
+func WithAfterFunc(ctx context.Context, children ...State) State {
+	return withAfterFunc(ctx, children...)
+}
+
+func withAfterFunc(ctx context.Context, children ...State) *afterFuncState {
+	s := &afterFuncState{
+		group:    merge(children...),
+		finished: make(chan struct{}),
+	}
+
+	// if ctx is already done, close is run before stop is assigned and
+	// waits for the lock
+	s.Lock()
+	s.stop = context.AfterFunc(ctx, s.close)
+	s.Unlock()
+
+	return s
+}
+
+func (s *afterFuncState) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, s)
+}
+
+func (s *afterFuncState) close() {
+	s.Lock()
+	stop := s.stop
+	s.Unlock()
+
+	stop()
+
+	go s.group.close()
+	<-s.group.finishSig()
+
+	s.Lock()
+	defer s.Unlock()
+
+	select {
+	case <-s.finished:
+		// Already closed
+	default:
+		close(s.finished)
+	}
+}
+
+func (s *afterFuncState) finishSig() <-chan struct{} {
+	return s.finished
+}
+
+func (s *afterFuncState) DependsOn(children ...State) State {
+	return withDependency(s, children...)
+}
+
+func (s *afterFuncState) self() State {
+	return s
+}
+
+func (s *afterFuncState) kind() string {
+	return "afterFunc"
+}
+
+func (s *afterFuncState) cause() error {
+	if err := s.group.cause(); err != nil {
+		return err
+	}
+
+	select {
+	case <-s.finished:
+		return nil
+	default:
+		return newTimeoutError(ErrTimeout)
+	}
+}
@@ -3,12 +3,15 @@ package state
 import (
 	"context"
 	"fmt"
+	"sync"
 )
 
 type annotationState struct {
 	*group
 
 	annotation string
+
+	sync.RWMutex
 }
 
 // WithAnnotation returns new state with merged children and assigned annotation to it.
@@ -27,10 +30,10 @@ func withAnnotation(message string, children ...State) *annotationState {
 // with state's annotation.
 // Returns nil if no errors found.
 func (a *annotationState) Err() error {
-	for _, m := range a.states {
-		if err := m.Err(); err != nil {
-			return fmt.Errorf("%s: %w", a.annotation, err)
-		}
+	countVisit()
+
+	if err := firstErr(a.states); err != nil {
+		return fmt.Errorf("%s: %w", a.label(), err)
 	}
 
 	return nil
@@ -39,21 +42,53 @@ func (a *annotationState) Err() error {
 // Shutdown shuts down state's children and returns annotated shutdown error.
 // Returns nil no errors occurred.
 func (a *annotationState) Shutdown(ctx context.Context) error {
-	if err := a.group.Shutdown(ctx); err != nil {
-		return fmt.Errorf("%s: %w", a.annotation, err)
-	}
-
-	return nil
+	return shutdown(ctx, a)
 }
 
 func (a *annotationState) DependsOn(children ...State) State {
 	return withDependency(a, children...)
 }
 
+func (a *annotationState) self() State {
+	return a
+}
+
+func (a *annotationState) kind() string {
+	return "annotation"
+}
+
+func (a *annotationState) label() string {
+	a.RLock()
+	defer a.RUnlock()
+
+	return a.annotation
+}
+
+// SetAnnotation replaces annotation of st at runtime and reports whether st
+// is an annotation state. Subsequent Err and Shutdown calls use the new
+// annotation.
+func SetAnnotation(st State, message string) bool {
+	a, ok := st.self().(*annotationState)
+	if !ok {
+		return false
+	}
+
+	a.Lock()
+	a.annotation = message
+	a.Unlock()
+
+	return true
+}
+
 func (a *annotationState) cause() error {
-	if err := a.group.cause(); err != nil {
-		return fmt.Errorf("%s: %w", a.annotation, err)
+	err := a.group.cause()
+	if err == nil {
+		return nil
 	}
 
-	return nil
+	if te, ok := err.(*TimeoutError); ok {
+		return te.annotated(a.label())
+	}
+
+	return fmt.Errorf("%s: %w", a.label(), err)
 }
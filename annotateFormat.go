@@ -0,0 +1,103 @@
+package state
+
+import (
+	"context"
+	"fmt"
+)
+
+type formatAnnotationState struct {
+	*group
+
+	format string
+}
+
+// WithAnnotationFormat returns new state with merged children, which
+// annotates errors of its children by format, for example
+// "db: %w (primary)", giving full control over the annotation's placement.
+//
+// The format must contain exactly one verb - %w for the child error;
+// literal percent signs are written as %%. WithAnnotationFormat panics
+// otherwise.
+//
+// Unlike WithAnnotation, the annotation is applied only to errors returned
+// by Err and Shutdown: it is not a part of paths reported by the package's
+// functions, such as AllErrors or TimeoutError's Path.
+func WithAnnotationFormat(format string, children ...State) State {
+	return withAnnotationFormat(format, children...)
+}
+
+func withAnnotationFormat(format string, children ...State) *formatAnnotationState {
+	if !validAnnotationFormat(format) {
+		panic("annotation format must contain exactly one verb %w")
+	}
+
+	return &formatAnnotationState{
+		group:  merge(children...),
+		format: format,
+	}
+}
+
+// validAnnotationFormat reports whether format contains exactly one verb,
+// which is %w.
+func validAnnotationFormat(format string) bool {
+	var wraps int
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+
+		i++
+
+		switch {
+		case i == len(format):
+			return false
+		case format[i] == '%':
+		case format[i] == 'w':
+			wraps++
+		default:
+			return false
+		}
+	}
+
+	return wraps == 1
+}
+
+// Err returns the first encountered error in State's children annotated
+// by state's format.
+// Returns nil if no errors found.
+func (f *formatAnnotationState) Err() error {
+	countVisit()
+
+	if err := firstErr(f.states); err != nil {
+		return fmt.Errorf(f.format, err)
+	}
+
+	return nil
+}
+
+// Shutdown shuts down state's children and returns annotated shutdown error.
+// Returns nil no errors occurred.
+func (f *formatAnnotationState) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, f)
+}
+
+func (f *formatAnnotationState) DependsOn(children ...State) State {
+	return withDependency(f, children...)
+}
+
+func (f *formatAnnotationState) self() State {
+	return f
+}
+
+func (f *formatAnnotationState) kind() string {
+	return "annotationFormat"
+}
+
+func (f *formatAnnotationState) cause() error {
+	if err := f.group.cause(); err != nil {
+		return fmt.Errorf(f.format, err)
+	}
+
+	return nil
+}
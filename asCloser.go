@@ -0,0 +1,38 @@
+package state
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+type stateCloser struct {
+	st      State
+	timeout time.Duration
+}
+
+// AsCloser returns io.Closer that gracefully shuts down st.
+//
+// Each Close call runs st.Shutdown with a new context that expires after
+// timeout and returns its result, so Close returns ErrTimeout if the
+// shutdown didn't complete in time. If timeout is zero or negative, Close
+// blocks until the shutdown is complete.
+//
+// As with Shutdown, successive Close calls are safe.
+func AsCloser(st State, timeout time.Duration) io.Closer {
+	return stateCloser{st: st, timeout: timeout}
+}
+
+// Close shuts down the state.
+func (c stateCloser) Close() error {
+	ctx := context.Background()
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	return c.st.Shutdown(ctx)
+}
@@ -0,0 +1,64 @@
+package state
+
+import (
+	"errors"
+	"log/slog"
+)
+
+// AttrError is an error carrying structured attributes, assigned
+// by ErrorWith.
+//
+// It implements slog.LogValuer, so logging it with slog reports the
+// attributes along with the message. Errors returned by State's Err wrap
+// it with annotations - use ErrorAttrs or errors.As to extract
+// the attributes.
+type AttrError struct {
+	// Err is the underlying error.
+	Err error
+
+	// Attrs are the attributes attached to the error.
+	Attrs []slog.Attr
+}
+
+func (e *AttrError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *AttrError) Unwrap() error {
+	return e.Err
+}
+
+// LogValue returns a group of the error message and the attributes.
+func (e *AttrError) LogValue() slog.Value {
+	attrs := make([]slog.Attr, 0, len(e.Attrs)+1)
+	attrs = append(attrs, slog.String("msg", e.Err.Error()))
+	attrs = append(attrs, e.Attrs...)
+
+	return slog.GroupValue(attrs...)
+}
+
+// ErrorWith assigns err with structured attrs to the state of tail as
+// AttrError, so the attributes can be extracted from State's Err with
+// ErrorAttrs. It works with any ErrTail, as the error is assigned with
+// tail's Error. If err is nil - does nothing.
+func ErrorWith(tail ErrTail, err error, attrs ...slog.Attr) {
+	if err == nil {
+		return
+	}
+
+	tail.Error(&AttrError{Err: err, Attrs: attrs})
+}
+
+// ErrorAttrs returns attributes of all AttrErrors in err's chain, from
+// the outermost to the innermost one. Returns nil if there are none.
+func ErrorAttrs(err error) (attrs []slog.Attr) {
+	var ae *AttrError
+
+	for errors.As(err, &ae) {
+		attrs = append(attrs, ae.Attrs...)
+		err = ae.Err
+	}
+
+	return attrs
+}
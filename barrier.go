@@ -0,0 +1,95 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+type barrierState struct {
+	*group
+
+	barrier  <-chan struct{}
+	finished chan struct{}
+
+	sync.Mutex
+}
+
+// errBarrier is returned by barrier state's cause when the barrier
+// is not released.
+var errBarrier = fmt.Errorf("shutdown barrier is not released: %w", ErrTimeout)
+
+// WithShutdownBarrier returns new State with merged children that starts
+// shutting down its children only after barrier is closed.
+//
+// It lets a shutdown wait for an external condition, for example a released
+// leader election. The barrier does not extend the shutdown timeout: if ctx
+// expires before the barrier is closed, Shutdown returns ErrTimeout and the
+// state stops waiting for the barrier until it is shut down again.
+func WithShutdownBarrier(barrier <-chan struct{}, children ...State) State {
+	return withShutdownBarrier(barrier, children...)
+}
+
+func withShutdownBarrier(barrier <-chan struct{}, children ...State) *barrierState {
+	if barrier == nil {
+		panic("nil shutdown barrier")
+	}
+
+	return &barrierState{
+		group:    merge(children...),
+		barrier:  barrier,
+		finished: make(chan struct{}),
+	}
+}
+
+func (b *barrierState) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, b)
+}
+
+func (b *barrierState) close() {
+	// the barrier is not waited for past the shutdown deadline, so the
+	// closing does not leak if it is never released
+	if !waitDeadline(b.barrier, b.deadline()) {
+		return
+	}
+
+	go b.group.close()
+	<-b.group.finishSig()
+
+	b.Lock()
+	defer b.Unlock()
+
+	select {
+	case <-b.finished:
+		// Already closed
+	default:
+		close(b.finished)
+	}
+}
+
+func (b *barrierState) finishSig() <-chan struct{} {
+	return b.finished
+}
+
+func (b *barrierState) DependsOn(children ...State) State {
+	return withDependency(b, children...)
+}
+
+func (b *barrierState) self() State {
+	return b
+}
+
+func (b *barrierState) kind() string {
+	return "barrier"
+}
+
+func (b *barrierState) cause() error {
+	select {
+	case <-b.finished:
+		return nil
+	case <-b.barrier:
+		return b.group.cause()
+	default:
+		return newTimeoutError(errBarrier)
+	}
+}
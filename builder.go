@@ -0,0 +1,68 @@
+package state
+
+// Builder constructs a tree of states fluently. Its methods are applied
+// in order, each wrapping the tree built so far:
+//
+//	st := new(state.Builder).
+//		Add(db).
+//		Annotate("storage").
+//		Add(server).
+//		DependOn(worker).
+//		Value(configKey, cfg).
+//		Build()
+//
+// is the same as:
+//
+//	st := state.WithValue(configKey, cfg,
+//		state.MergeFlat(state.WithAnnotation("storage", db), server).DependsOn(worker),
+//	)
+//
+// The zero value is an empty builder ready to use. Builder is not safe
+// for concurrent use.
+type Builder struct {
+	st State
+}
+
+// Add merges st into the tree built so far.
+func (b *Builder) Add(st State) *Builder {
+	if b.st == nil {
+		b.st = st
+	} else {
+		b.st = MergeFlat(b.st, st)
+	}
+
+	return b
+}
+
+// DependOn makes the tree built so far depend on children: during
+// shutdown children are shut down first.
+func (b *Builder) DependOn(children ...State) *Builder {
+	b.st = b.state().DependsOn(children...)
+	return b
+}
+
+// Annotate annotates the tree built so far with message.
+func (b *Builder) Annotate(message string) *Builder {
+	b.st = WithAnnotation(message, b.state())
+	return b
+}
+
+// Value assigns value to key in the tree built so far.
+func (b *Builder) Value(key, value interface{}) *Builder {
+	b.st = WithValue(key, value, b.state())
+	return b
+}
+
+// Build returns the built tree. If nothing was added, Build returns
+// an empty state.
+func (b *Builder) Build() State {
+	return b.state()
+}
+
+func (b *Builder) state() State {
+	if b.st == nil {
+		return Empty()
+	}
+
+	return b.st
+}
@@ -0,0 +1,121 @@
+package state
+
+import (
+	"sync"
+)
+
+// CategoryTail detaches after categorized errors state initialization.
+// The tail is supposed to stay in a background job associated with
+// created state and used to assign errors to the state by category.
+type CategoryTail interface {
+	// Error assigns err to associated state under category.
+	// Nil errors are ignored.
+	Error(category string, err error)
+}
+
+type categoryState struct {
+	*group
+
+	errs  map[string][]error
+	first error
+
+	sync.RWMutex
+}
+
+// WithCategorizedErrors returns new state with merged children that can
+// store errors in separate categories, for example "network" and
+// "validation".
+//
+// The returned CategoryTail is used to assign errors to the state.
+// State's Err returns the first assigned error across all categories,
+// ErrorsByCategory returns all of them.
+func WithCategorizedErrors(children ...State) (State, CategoryTail) {
+	c := withCategorizedErrors(children...)
+	return c, c
+}
+
+func withCategorizedErrors(children ...State) *categoryState {
+	return &categoryState{
+		group: merge(children...),
+		errs:  make(map[string][]error),
+	}
+}
+
+// Error assigns err to the state under category.
+func (c *categoryState) Error(category string, err error) {
+	if err == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.first == nil {
+		c.first = err
+	}
+
+	c.errs[category] = append(c.errs[category], err)
+}
+
+// Err returns the first error assigned to the state.
+func (c *categoryState) Err() error {
+	countVisit()
+
+	return c.ownErr()
+}
+
+func (c *categoryState) ownErr() error {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.first
+}
+
+// categories returns a copy of errors assigned to the state.
+func (c *categoryState) categories() map[string][]error {
+	c.RLock()
+	defer c.RUnlock()
+
+	m := make(map[string][]error, len(c.errs))
+
+	for category, errs := range c.errs {
+		m[category] = append([]error(nil), errs...)
+	}
+
+	return m
+}
+
+func (c *categoryState) DependsOn(children ...State) State {
+	return withDependency(c, children...)
+}
+
+func (c *categoryState) self() State {
+	return c
+}
+
+func (c *categoryState) kind() string {
+	return "error"
+}
+
+// ErrorsByCategory returns errors of all categorized errors states in st
+// grouped by category and annotated the same way as Err does.
+//
+// Errors in each category are ordered from top to bottom and from left
+// to right in the tree, and in order of assignment within a state.
+func ErrorsByCategory(st State) map[string][]error {
+	m := make(map[string][]error)
+
+	walk(st, func(st State, path []string) bool {
+		if c, ok := st.(*categoryState); ok {
+			for category, errs := range c.categories() {
+				for _, err := range errs {
+					m[category] = append(m[category], annotate(path, err))
+				}
+			}
+		}
+
+		return true
+	})
+
+	return m
+}
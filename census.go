@@ -0,0 +1,27 @@
+package state
+
+// Census returns the number of distinct states in st by their kind:
+// "shutdown", "wait", "error", "value", "annotation", "dependency",
+// "readiness", "group", "empty" and kinds of other states provided by
+// the package, such as "barrier" or "name".
+//
+// It is useful for verifying the tree's wiring in tests and monitoring.
+func Census(st State) map[string]int {
+	var (
+		counts = make(map[string]int)
+		seen   = make(map[State]struct{})
+	)
+
+	walk(st, func(st State, _ []string) bool {
+		if _, ok := seen[st]; ok {
+			return false
+		}
+
+		seen[st] = struct{}{}
+		counts[st.kind()]++
+
+		return true
+	})
+
+	return counts
+}
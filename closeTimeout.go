@@ -0,0 +1,135 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCloseTimeout is the error reported by Err of states created by
+// WithCloseTimeout when their jobs do not finish in time.
+var ErrCloseTimeout = errors.New("state close timeout exceeded")
+
+type closeTimeoutState struct {
+	*shutdownState
+
+	d time.Duration
+
+	err  error
+	errC chan struct{} // closed when the timeout is exceeded
+
+	closing  bool
+	finished chan struct{}
+
+	sync.Mutex
+}
+
+// WithCloseTimeout returns a new shutdownable State that depends on
+// children the same way WithShutdown does, except that if the job does not
+// call ShutdownTail's Done within d after the End signal, the state is
+// considered shut down anyway, so a misbehaving job can not stall the
+// shutdown of the whole tree.
+//
+// The exceeded timeout is reported by the state's Err as an error wrapping
+// ErrCloseTimeout.
+func WithCloseTimeout(d time.Duration, children ...State) (State, ShutdownTail) {
+	s := withCloseTimeout(d, children...)
+	return s, s
+}
+
+func withCloseTimeout(d time.Duration, children ...State) *closeTimeoutState {
+	return &closeTimeoutState{
+		shutdownState: withShutdown(children...),
+		d:             d,
+		errC:          make(chan struct{}),
+		finished:      make(chan struct{}),
+	}
+}
+
+func (c *closeTimeoutState) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, c)
+}
+
+func (c *closeTimeoutState) close() {
+	c.Lock()
+	closing := c.closing
+	c.closing = true
+	c.Unlock()
+
+	if closing {
+		<-c.finished
+		return
+	}
+
+	c.shutdownState.close()
+
+	timer := time.NewTimer(c.d)
+	defer timer.Stop()
+
+	select {
+	case <-c.shutdownState.finishSig():
+	case <-timer.C:
+		c.Lock()
+		c.err = fmt.Errorf("%w: job didn't finish within %v", ErrCloseTimeout, c.d)
+		close(c.errC)
+		c.Unlock()
+	}
+
+	close(c.finished)
+}
+
+// Err returns the close timeout error if the timeout is exceeded, or
+// the first encountered error in state's children otherwise.
+func (c *closeTimeoutState) Err() error {
+	if err := c.ownErr(); err != nil {
+		countVisit()
+
+		return err
+	}
+
+	// the state is counted as visited by its group
+	return c.shutdownState.Err()
+}
+
+func (c *closeTimeoutState) ownErr() error {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.err
+}
+
+// errSig returns a channel that's closed when the timeout is exceeded.
+func (c *closeTimeoutState) errSig() <-chan struct{} {
+	return c.errC
+}
+
+func (c *closeTimeoutState) finishSig() <-chan struct{} {
+	return c.finished
+}
+
+func (c *closeTimeoutState) DependsOn(children ...State) State {
+	return withDependency(c, children...)
+}
+
+func (c *closeTimeoutState) self() State {
+	return c
+}
+
+func (c *closeTimeoutState) kind() string {
+	return "closeTimeout"
+}
+
+func (c *closeTimeoutState) cause() error {
+	if err := c.group.cause(); err != nil {
+		return err
+	}
+
+	select {
+	case <-c.finished:
+		return nil
+	default:
+		return newTimeoutError(ErrTimeout)
+	}
+}
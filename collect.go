@@ -0,0 +1,43 @@
+package state
+
+import "errors"
+
+type collectState struct {
+	*group
+}
+
+// MergeCollectErrors returns new State with merged children the same
+// way Merge does, except that its Err returns errors of all children
+// joined with errors.Join instead of only the first one.
+//
+// It is useful for validation passes, for example on startup, where all
+// failures should be reported at once.
+func MergeCollectErrors(states ...State) State {
+	return &collectState{group: merge(states...)}
+}
+
+// Err returns errors of all state's children joined with errors.Join.
+// Returns nil if no errors found.
+func (c *collectState) Err() error {
+	countVisit()
+
+	errs := make([]error, 0, len(c.states))
+
+	for _, st := range c.states {
+		errs = append(errs, st.Err())
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *collectState) DependsOn(children ...State) State {
+	return withDependency(c, children...)
+}
+
+func (c *collectState) self() State {
+	return c
+}
+
+func (c *collectState) kind() string {
+	return "group"
+}
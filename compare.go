@@ -0,0 +1,75 @@
+package state
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Equal reports whether a and b have the same structure: states of the
+// same kinds in the same positions, with the same annotations and names.
+// Runtime status, such as readiness, errors, shutdown progress or values,
+// is ignored. It lets tests assert the topology produced by wiring code.
+func Equal(a, b State) bool {
+	return Diff(a, b) == ""
+}
+
+// Diff returns a description of structural differences between a and b,
+// one per line, or an empty string if they are Equal. Each difference is
+// prefixed with the position of the state: indexes of children from the
+// root separated by "/", for example "/0/1".
+func Diff(a, b State) string {
+	var sb strings.Builder
+
+	diff(&sb, "", a, b)
+
+	return sb.String()
+}
+
+func diff(sb *strings.Builder, pos string, a, b State) {
+	a, b = a.self(), b.self()
+
+	at := pos
+	if at == "" {
+		at = "/"
+	}
+
+	if ak, bk := a.kind(), b.kind(); ak != bk {
+		fmt.Fprintf(sb, "%s: kind %s != %s\n", at, ak, bk)
+		return
+	}
+
+	if al, bl := structLabel(a), structLabel(b); al != bl {
+		fmt.Fprintf(sb, "%s: label %q != %q\n", at, al, bl)
+	}
+
+	ac, bc := a.childStates(), b.childStates()
+
+	for i := 0; i < len(ac) || i < len(bc); i++ {
+		childPos := pos + "/" + strconv.Itoa(i)
+
+		switch {
+		case i >= len(bc):
+			fmt.Fprintf(sb, "%s: %s missing in b\n", childPos, ac[i].self().kind())
+		case i >= len(ac):
+			fmt.Fprintf(sb, "%s: %s missing in a\n", childPos, bc[i].self().kind())
+		default:
+			diff(sb, childPos, ac[i], bc[i])
+		}
+	}
+}
+
+// structLabel returns the annotation or the name of st, which is a part
+// of the tree's structure.
+func structLabel(st State) string {
+	switch s := st.(type) {
+	case annotator:
+		return s.label()
+	case *nameState:
+		return s.name
+	case *formatAnnotationState:
+		return s.format
+	}
+
+	return ""
+}
@@ -0,0 +1,28 @@
+//go:build statedebug
+
+package state
+
+import "sync/atomic"
+
+// visits counts states visited by Err and Value traversals.
+var visits atomic.Int64
+
+// countVisit records a visit of a state by a traversal.
+func countVisit() {
+	visits.Add(1)
+}
+
+// Visits returns the number of states visited by Err and Value
+// traversals since the last ResetVisits call.
+//
+// It is available only in builds with the statedebug tag and is meant for
+// measuring traversal cost of deep trees, for example to confirm that
+// flattening reduces it.
+func Visits() int64 {
+	return visits.Load()
+}
+
+// ResetVisits resets the counter of visited states to zero.
+func ResetVisits() {
+	visits.Store(0)
+}
@@ -0,0 +1,50 @@
+//go:build statedebug
+
+package state
+
+import "testing"
+
+func TestVisits(t *testing.T) {
+	var (
+		st1 = withValue(key("key"), "value")
+		st2 = merge(withAnnotation("a", merge(st1)))
+	)
+
+	ResetVisits()
+
+	_ = st2.Err()
+
+	if v := Visits(); v != 4 {
+		t.Errorf("wrong number of visits by Err, want 4, have %d", v)
+	}
+
+	ResetVisits()
+
+	_ = st2.Value(key("key"))
+
+	if v := Visits(); v != 4 {
+		t.Errorf("wrong number of visits by Value, want 4, have %d", v)
+	}
+
+	var (
+		st3 = withError(nil)
+		st4 = withWait().DependsOn(withWait().DependsOn(st3))
+	)
+
+	ResetVisits()
+
+	_ = st4.Err()
+
+	// two dependencies, their parents and the error state
+	if v := Visits(); v != 5 {
+		t.Errorf("wrong number of visits by Err through dependencies, want 5, have %d", v)
+	}
+
+	ResetVisits()
+
+	_ = st4.Value(key("key"))
+
+	if v := Visits(); v != 5 {
+		t.Errorf("wrong number of visits by Value through dependencies, want 5, have %d", v)
+	}
+}
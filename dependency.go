@@ -3,15 +3,32 @@ package state
 import (
 	"context"
 	"sync"
+	"time"
 )
 
 type dependState struct {
+	// children and parent are never modified after construction, so they
+	// are read without the lock
 	children *group
 	parent   State
 
 	finished chan struct{}
 	ready    chan struct{}
 
+	// ordered dependency closes children one by one in order
+	ordered bool
+
+	// fraction of the shutdown budget allotted to children
+	weight float64
+
+	shutdownReason   string
+	shutdownDeadline time.Time
+
+	// cache memoizes Value lookups, nil if not cached
+	cache *valueCache
+
+	stable stableErr
+
 	sync.RWMutex
 }
 
@@ -25,11 +42,61 @@ func withDependency(parent State, children ...State) *dependState {
 	}
 }
 
+// DependsOnOrdered creates a new state from parent and children, which
+// during shutdown closes children strictly one by one in the given order,
+// each waiting for the previous one to be shut down, and then shuts down
+// parent.
+//
+// Unlike DependsOn, which shuts down children concurrently, it allows
+// multi-tier shutdowns without nesting many dependencies.
+func DependsOnOrdered(parent State, children ...State) State {
+	d := withDependency(parent, children...)
+	d.ordered = true
+
+	return d
+}
+
+// DependsOnCached creates a new state from parent and children the same
+// way DependsOn does, which memoizes its Value lookups: each key is
+// resolved by walking the tree once, and subsequent lookups of the key
+// take constant time. It speeds up hot-path value reads in deep trees.
+// Lookups of keys that are not found are not memoized.
+//
+// The tree below must be fully composed before the state is created.
+// Keys of values created with WithMutableValue are never cached - their
+// lookups walk the tree, so they stay current.
+func DependsOnCached(parent State, children ...State) State {
+	d := withDependency(parent, children...)
+	d.cache = newValueCache(d)
+
+	return d
+}
+
+// WithDataDependency creates a new state from reader and provider, where
+// reader uses data provided by provider, for example reads a value stored
+// by it. During shutdown reader is shut down first, and provider is shut
+// down only after reader is shut down, so the data outlives its readers.
+//
+// It is the same as provider.DependsOn(reader), but expresses the intent
+// in terms of data.
+func WithDataDependency(reader, provider State) State {
+	return withDependency(provider, reader)
+}
+
 func (d *dependState) Shutdown(ctx context.Context) error {
 	return shutdown(ctx, d)
 }
 
 func (d *dependState) close() {
+	if d.ordered {
+		for _, child := range d.children.states {
+			child.close()
+			<-child.finishSig()
+		}
+	}
+
+	// children are already finished in ordered mode - only the group
+	// itself is marked as finished
 	d.children.close()
 	<-d.children.finishSig()
 
@@ -58,37 +125,46 @@ func (d *dependState) Ready() <-chan struct{} {
 	d.Lock()
 	defer d.Unlock()
 
-	if d.ready != nil {
+	if d.ready == nil {
 		// To avoid memory leaks - ready channel is created only once
-		return d.ready
-	}
+		d.ready = make(chan struct{})
 
-	d.ready = make(chan struct{})
+		go func() {
+			<-d.children.Ready()
+			<-d.parent.Ready()
 
-	go func() {
-		<-d.children.Ready()
-		<-d.parent.Ready()
-		close(d.ready)
-	}()
+			d.Lock()
+			closeReady(d.ready)
+			d.Unlock()
+		}()
+	}
+
+	// fast path - readiness is resolved synchronously if possible
+	if allReady(d.childStates()) {
+		closeReady(d.ready)
+	}
 
 	return d.ready
 }
 
 func (d *dependState) Err() (err error) {
-	if err = d.parent.Err(); err != nil {
-		return err
-	}
+	countVisit()
 
-	for _, states := range d.children.states {
-		if err = states.Err(); err != nil {
-			return err
-		}
+	return firstErr(d.childStates())
+}
+
+func (d *dependState) Value(key interface{}) (value interface{}) {
+	countVisit()
+
+	if d.cache != nil {
+		return d.cache.value(key, d.lookup)
 	}
 
-	return
+	return d.lookup(key)
 }
 
-func (d *dependState) Value(key interface{}) (value interface{}) {
+// lookup resolves key in parent and then in children.
+func (d *dependState) lookup(key interface{}) (value interface{}) {
 	if value = d.parent.Value(key); value != nil {
 		return value
 	}
@@ -110,10 +186,62 @@ func (d *dependState) dependsOn(children ...State) *dependState {
 	return withDependency(d, children...)
 }
 
+func (d *dependState) self() State {
+	return d
+}
+
+func (d *dependState) kind() string {
+	return "dependency"
+}
+
+func (d *dependState) stableErr() *stableErr {
+	return &d.stable
+}
+
+func (d *dependState) childStates() []State {
+	return append([]State{d.parent}, d.children.states...)
+}
+
 func (d *dependState) finishSig() <-chan struct{} {
 	return d.finished
 }
 
+func (d *dependState) setReason(reason string) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.shutdownReason == "" {
+		d.shutdownReason = reason
+	}
+}
+
+func (d *dependState) reason() string {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.shutdownReason
+}
+
+func (d *dependState) setDeadline(deadline time.Time) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.shutdownDeadline.IsZero() {
+		return false
+	}
+
+	d.shutdownDeadline = deadline
+
+	return true
+}
+
+func (d *dependState) deadline() time.Time {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.shutdownDeadline
+}
+
 func (d *dependState) cause() error {
 	err := d.children.cause()
 	if err != nil {
@@ -127,3 +255,63 @@ func (d *dependState) cause() error {
 
 	return nil
 }
+
+// valueCache memoizes resolved value lookups.
+type valueCache struct {
+	values  map[interface{}]interface{}
+	mutable map[interface{}]struct{}
+
+	sync.RWMutex
+}
+
+// newValueCache returns a cache of values in st. Keys of mutable values
+// in st are never cached.
+func newValueCache(st State) *valueCache {
+	c := &valueCache{
+		values:  make(map[interface{}]interface{}),
+		mutable: make(map[interface{}]struct{}),
+	}
+
+	walk(st, func(st State, _ []string) bool {
+		if v, ok := st.(interface {
+			valuer
+			mutable()
+		}); ok {
+			key, _ := v.keyValue()
+			c.mutable[key] = struct{}{}
+		}
+
+		return true
+	})
+
+	return c
+}
+
+// value returns the value cached for key, resolving it with lookup
+// on a miss. Only found values are cached, so keys missing at the time
+// of the lookup are resolved again later, for example once children
+// restarted by Supervise provide them.
+func (c *valueCache) value(key interface{}, lookup func(key interface{}) interface{}) interface{} {
+	if _, ok := c.mutable[key]; ok {
+		return lookup(key)
+	}
+
+	c.RLock()
+	value, ok := c.values[key]
+	c.RUnlock()
+
+	if ok {
+		return value
+	}
+
+	value = lookup(key)
+	if value == nil {
+		return nil
+	}
+
+	c.Lock()
+	c.values[key] = value
+	c.Unlock()
+
+	return value
+}
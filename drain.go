@@ -0,0 +1,102 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+type drainState struct {
+	*waitState
+
+	drained chan struct{}
+
+	sync.Mutex
+}
+
+// DrainTimeoutError is the error returned by Shutdown when shutdown's
+// timeout expires while a drain state still has tasks in flight.
+type DrainTimeoutError struct {
+	// InFlight is the number of tasks that were still in flight.
+	InFlight int
+}
+
+func (e *DrainTimeoutError) Error() string {
+	return fmt.Sprintf("drain timeout: %d tasks still in flight", e.InFlight)
+}
+
+// Unwrap returns ErrTimeout, so errors.Is(err, ErrTimeout) holds for
+// drain timeouts.
+func (e *DrainTimeoutError) Unwrap() error {
+	return ErrTimeout
+}
+
+// WithDrain returns new waitable State with merged children, which takes
+// part in graceful shutdown: after its children are shut down, it waits
+// until its WaitGroup counter is zero.
+//
+// The returned WaitTail is used to increment and decrement State's
+// WaitGroup counter. If shutdown's timeout expires before the counter is
+// zero, Shutdown returns DrainTimeoutError reporting how many tasks were
+// still in flight.
+func WithDrain(children ...State) (State, WaitTail) {
+	d := withDrain(children...)
+	return d, d
+}
+
+func withDrain(children ...State) *drainState {
+	return &drainState{
+		waitState: withWait(children...),
+		drained:   make(chan struct{}),
+	}
+}
+
+func (d *drainState) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, d)
+}
+
+func (d *drainState) close() {
+	go d.group.close()
+	<-d.group.finishSig()
+
+	d.WaitGroup.Wait()
+
+	d.Lock()
+	defer d.Unlock()
+
+	select {
+	case <-d.drained:
+		// Already closed
+	default:
+		close(d.drained)
+	}
+}
+
+func (d *drainState) finishSig() <-chan struct{} {
+	return d.drained
+}
+
+func (d *drainState) DependsOn(children ...State) State {
+	return withDependency(d, children...)
+}
+
+func (d *drainState) self() State {
+	return d
+}
+
+func (d *drainState) kind() string {
+	return "wait"
+}
+
+func (d *drainState) cause() error {
+	if err := d.group.cause(); err != nil {
+		return err
+	}
+
+	select {
+	case <-d.drained:
+		return nil
+	default:
+		return newTimeoutError(&DrainTimeoutError{InFlight: d.inFlight()})
+	}
+}
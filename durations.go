@@ -0,0 +1,27 @@
+package state
+
+import "time"
+
+// ShutdownDurations returns shutdown durations of shutdown states in st,
+// which are the times between the End signal and the Done call, keyed by
+// states' paths of annotations joined with ": ". Durations of states with
+// the same path are ordered the same way states are shut down. States that
+// are not shut down yet are skipped.
+//
+// It lets slow shutdowns be observed, for example as a histogram.
+func ShutdownDurations(st State) map[string][]time.Duration {
+	durations := make(map[string][]time.Duration)
+
+	for _, l := range leaves(st) {
+		s, ok := l.State.(interface{ duration() (time.Duration, bool) })
+		if !ok {
+			continue
+		}
+
+		if d, ok := s.duration(); ok {
+			durations[l.path] = append(durations[l.path], d)
+		}
+	}
+
+	return durations
+}
@@ -15,3 +15,6 @@ func (e emptyState) DependsOn(children ...State) State { return withDependency(e
 func (e emptyState) close()                            {}
 func (e emptyState) finishSig() <-chan struct{}        { return closedchan }
 func (e emptyState) cause() error                      { return nil }
+func (e emptyState) self() State                       { return e }
+func (e emptyState) kind() string                      { return "empty" }
+func (e emptyState) childStates() []State              { return nil }
@@ -1,12 +1,18 @@
 package state
 
 import (
+	"errors"
 	"sync"
 )
 
 type errState struct {
 	*group
-	err error
+	err      error
+	priority int
+	errC     chan struct{} // closed when error is assigned
+
+	// callbacks registered by OnError
+	callbacks []func(err error)
 
 	sync.RWMutex
 }
@@ -17,14 +23,119 @@ func WithError(err error, children ...State) State {
 }
 
 func withError(err error, children ...State) *errState {
-	return &errState{
+	e := &errState{
 		group: merge(children...),
-		err:   err,
+		errC:  make(chan struct{}),
+	}
+
+	e.error(err)
+
+	return e
+}
+
+// error assigns err to the state if it has no error yet.
+func (e *errState) error(err error) {
+	if err == nil {
+		return
+	}
+
+	e.Lock()
+
+	if e.err != nil {
+		e.Unlock()
+		return
+	}
+
+	e.err = e.prioritized(err)
+	close(e.errC)
+
+	err, callbacks := e.err, e.callbacks
+	e.Unlock()
+
+	// callbacks are called without the lock, so they can use the state
+	for _, fn := range callbacks {
+		fn(err)
+	}
+}
+
+// errSig returns a channel that's closed when error is assigned
+// to the state.
+func (e *errState) errSig() <-chan struct{} {
+	return e.errC
+}
+
+// WithErrorPriority returns new State with merged children and assigned
+// err with priority to it.
+//
+// When several errors are found in the tree, Err returns the one with
+// the highest priority, regardless of its position in the tree. Errors
+// with equal priority are returned in the usual order - the topmost and
+// the leftmost first. Errors assigned by WithError have priority 0.
+func WithErrorPriority(err error, priority int, children ...State) State {
+	e := withError(nil, children...)
+	e.priority = priority
+	e.error(err)
+
+	return e
+}
+
+// prioritizedError carries priority of the error assigned to the state.
+type prioritizedError struct {
+	err      error
+	priority int
+}
+
+func (e *prioritizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *prioritizedError) Unwrap() error {
+	return e.err
+}
+
+// prioritized returns err carrying state's priority.
+func (e *errState) prioritized(err error) error {
+	if err == nil || e.priority == 0 {
+		return err
 	}
+
+	return &prioritizedError{err: err, priority: e.priority}
+}
+
+// errPriority returns priority of err.
+func errPriority(err error) int {
+	var pe *prioritizedError
+	if errors.As(err, &pe) {
+		return pe.priority
+	}
+
+	return 0
+}
+
+// firstErr returns the error with the highest priority among states'
+// errors. If several errors have the same priority, the first one is
+// returned.
+func firstErr(states []State) (err error) {
+	max := 0
+
+	for _, st := range states {
+		e := st.Err()
+		if e == nil {
+			continue
+		}
+
+		if p := errPriority(e); err == nil || p > max {
+			err, max = e, p
+		}
+	}
+
+	return err
 }
 
 // Err returns error assigned to errState
 func (e *errState) Err() (err error) {
+	countVisit()
+
 	e.RLock()
 	defer e.RUnlock()
 
@@ -34,3 +145,126 @@ func (e *errState) Err() (err error) {
 func (e *errState) DependsOn(children ...State) State {
 	return withDependency(e, children...)
 }
+
+func (e *errState) self() State {
+	return e
+}
+
+func (e *errState) kind() string {
+	return "error"
+}
+
+func (e *errState) ownErr() error {
+	e.RLock()
+	defer e.RUnlock()
+
+	return e.err
+}
+
+// AllErrors returns errors of all states in st annotated the same way
+// as Err does, ordered from top to bottom and from left to right.
+//
+// The same error reachable through several branches of the tree,
+// for example a shared sentinel error, is reported only once - errors are
+// considered the same if errors.Is reports so. Of such errors the one with
+// the longest annotation chain is reported, at the position of the first
+// one. Distinct errors with equal messages are reported separately.
+// Returns nil if no errors found.
+func AllErrors(st State) (errs []error) {
+	var (
+		seen   []error
+		depths []int
+	)
+
+	walk(st, func(st State, path []string) bool {
+		h, ok := st.(errHolder)
+		if !ok {
+			return true
+		}
+
+		err := h.ownErr()
+		if err == nil {
+			return true
+		}
+
+		for i, s := range seen {
+			if errors.Is(err, s) || errors.Is(s, err) {
+				if len(path) > depths[i] {
+					depths[i] = len(path)
+					errs[i] = annotate(path, err)
+				}
+
+				return true
+			}
+		}
+
+		seen = append(seen, err)
+		depths = append(depths, len(path))
+		errs = append(errs, annotate(path, err))
+
+		return true
+	})
+
+	return errs
+}
+
+// ErrSource returns the error Err of st returns along with the state that
+// holds it, found the same way Err finds the error. The source is the
+// innermost state holding the error, not an annotation wrapping it,
+// which lets callers take node-specific remediation, for example restart
+// just the failed subsystem.
+// Returns nil state and nil error if no errors found.
+func ErrSource(st State) (source State, err error) {
+	max := 0
+
+	walk(st, func(st State, path []string) bool {
+		h, ok := st.(errHolder)
+		if !ok {
+			return true
+		}
+
+		e := h.ownErr()
+		if e == nil {
+			// holders without their own error may still report errors
+			// of their children, for example close timeout states
+			return st.Err() != nil
+		}
+
+		if p := errPriority(e); err == nil || p > max {
+			source, err, max = st, annotate(path, e), p
+		}
+
+		// errors of children are not reported by error holders
+		return false
+	})
+
+	return source, err
+}
+
+// ErrDeepest returns the error with the longest annotation chain found
+// in st, which is the error carrying the most context. If several errors
+// have chains of the same length, the topmost and the leftmost is returned.
+//
+// Unlike Err, which returns the first error found, ErrDeepest checks all
+// errors in the tree.
+// Returns nil if no errors found.
+func ErrDeepest(st State) (err error) {
+	max := -1
+
+	for _, e := range collectErrors(st) {
+		if depth := chainLength(e); depth > max {
+			err, max = e, depth
+		}
+	}
+
+	return err
+}
+
+// chainLength returns the number of errors wrapped by err.
+func chainLength(err error) (n int) {
+	for err = errors.Unwrap(err); err != nil; err = errors.Unwrap(err) {
+		n++
+	}
+
+	return n
+}
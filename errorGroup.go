@@ -33,17 +33,55 @@ func withErrorGroup(children ...State) *errGroupState {
 	return &errGroupState{errState: withError(nil, children...)}
 }
 
+// WithErrorGroupPriority returns new state with merged children that can
+// store an error, the same way WithErrorGroup does, but the assigned error
+// is reported with priority (see WithErrorPriority).
+func WithErrorGroupPriority(priority int, children ...State) (State, ErrTail) {
+	b := withErrorGroup(children...)
+	b.priority = priority
+
+	return b, b
+}
+
+// sharedErrTailKey is the key for the tail stored by MergeWithErrorGroup.
+type sharedErrTailKey struct{}
+
+// MergeWithErrorGroup returns new State with merged states and a single
+// error group shared by them, and the group's ErrTail. Subsystems report
+// fatal errors into the shared tail, which they can retrieve from the tree
+// with SharedErrTail, and the returned State's Err surfaces them.
+//
+// If some of states already has a shared error group, it is reused:
+// the merged states and its tail are returned.
+func MergeWithErrorGroup(states ...State) (State, ErrTail) {
+	for _, st := range states {
+		if st == nil {
+			continue
+		}
+
+		if tail, ok := SharedErrTail(st); ok {
+			return Merge(states...), tail
+		}
+	}
+
+	e := withErrorGroup()
+
+	return withValue(sharedErrTailKey{}, ErrTail(e), append(states[:len(states):len(states)], e)...), e
+}
+
+// SharedErrTail returns the tail of the error group shared by
+// MergeWithErrorGroup in st. If there are multiple shared error groups
+// in the tree, only the topmost and the leftmost will be returned.
+func SharedErrTail(st State) (tail ErrTail, ok bool) {
+	tail, ok = st.Value(sharedErrTailKey{}).(ErrTail)
+	return
+}
+
 // Error assigns err to the state.
 //
 // If the state already has an error - does nothing.
 func (e *errGroupState) Error(err error) {
-	if err != nil {
-		e.Lock()
-		if e.err == nil {
-			e.err = err
-		}
-		e.Unlock()
-	}
+	e.error(err)
 }
 
 // Errorf formats according to a format specifier and assigns
@@ -56,6 +94,92 @@ func (e *errGroupState) Errorf(format string, a ...interface{}) {
 	e.Error(fmt.Errorf(format, a...))
 }
 
+// errObserver is implemented by tails that call callbacks when errors
+// are assigned to their states.
+type errObserver interface {
+	onError(fn func(err error))
+}
+
+// OnError registers fn to be called synchronously by the goroutine
+// assigning an error with tail, with the error as State's Err would return
+// it, and reports whether tail supports callbacks. For tails returned by
+// WithErrorGroup fn is called once, for tails returned by
+// WithErrorGroupMulti - on each error. If an error is already assigned,
+// fn is called with it right away.
+//
+// Tails implemented outside of the package are not observed.
+//
+// OnError panics if fn is nil.
+func OnError(tail ErrTail, fn func(err error)) bool {
+	if fn == nil {
+		panic("nil error callback")
+	}
+
+	o, ok := tail.(errObserver)
+	if ok {
+		o.onError(fn)
+	}
+
+	return ok
+}
+
+// onError registers fn to be called once, when an error is assigned to
+// the state. If the state already has an error, fn is called with it
+// right away.
+func (e *errGroupState) onError(fn func(err error)) {
+	e.Lock()
+	err := e.err
+	if err == nil {
+		e.callbacks = append(e.callbacks, fn)
+	}
+	e.Unlock()
+
+	if err != nil {
+		fn(err)
+	}
+}
+
 func (e *errGroupState) DependsOn(children ...State) State {
 	return withDependency(e, children...)
 }
+
+func (e *errGroupState) self() State {
+	return e
+}
+
+func (e *errGroupState) kind() string {
+	return "error"
+}
+
+// snapshot returns a copy of errors assigned to the state.
+func (e *errGroupState) snapshot() []error {
+	e.RLock()
+	defer e.RUnlock()
+
+	if e.err == nil {
+		return nil
+	}
+
+	return []error{e.err}
+}
+
+// ErrSnapshot returns a copy of errors currently assigned to error groups
+// in st, annotated the same way as Err does. The errors are copied under
+// error groups' locks, so the returned slice is safe to iterate while
+// background jobs keep assigning new errors.
+//
+// The errors are ordered from top to bottom and from left to right.
+// Returns nil if no errors found.
+func ErrSnapshot(st State) (errs []error) {
+	walk(st, func(st State, path []string) bool {
+		if s, ok := st.(interface{ snapshot() []error }); ok {
+			for _, err := range s.snapshot() {
+				errs = append(errs, annotate(path, err))
+			}
+		}
+
+		return true
+	})
+
+	return errs
+}
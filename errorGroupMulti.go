@@ -0,0 +1,152 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+type multiErrState struct {
+	*group
+
+	max     int
+	errs    []error
+	dropped int
+	errC    chan struct{} // closed when the first error is assigned
+
+	// callbacks registered by onError
+	callbacks []func(err error)
+
+	sync.RWMutex
+}
+
+// WithErrorGroupMulti returns new state with merged children that stores
+// up to max errors, and its ErrTail. Unlike WithErrorGroup, every error
+// assigned with the tail is kept, until max errors are stored - further
+// errors are only counted as dropped, which bounds memory during
+// pathological error storms.
+//
+// The state's Err returns kept errors joined with errors.Join, followed
+// by a note about how many errors were dropped, if any.
+//
+// WithErrorGroupMulti panics if max is not positive.
+func WithErrorGroupMulti(max int, children ...State) (State, ErrTail) {
+	m := withErrorGroupMulti(max, children...)
+	return m, m
+}
+
+func withErrorGroupMulti(max int, children ...State) *multiErrState {
+	if max <= 0 {
+		panic("non-positive error group capacity")
+	}
+
+	return &multiErrState{
+		group: merge(children...),
+		max:   max,
+		errC:  make(chan struct{}),
+	}
+}
+
+// Error stores err in the state, or counts it as dropped if the state
+// already stores max errors. Nil err is ignored.
+func (m *multiErrState) Error(err error) {
+	if err == nil {
+		return
+	}
+
+	m.Lock()
+
+	if len(m.errs) == m.max {
+		m.dropped++
+	} else {
+		m.errs = append(m.errs, err)
+
+		if len(m.errs) == 1 {
+			close(m.errC)
+		}
+	}
+
+	callbacks := m.callbacks
+	m.Unlock()
+
+	// callbacks are called without the lock, so they can use the state
+	for _, fn := range callbacks {
+		fn(err)
+	}
+}
+
+// Errorf formats according to a format specifier and stores the string
+// in the state as a value that satisfies error, the same way Error does.
+//
+// Uses fmt.Errorf thus supports error wrapping with %w verb.
+func (m *multiErrState) Errorf(format string, a ...interface{}) {
+	m.Error(fmt.Errorf(format, a...))
+}
+
+// onError registers fn to be called on each error assigned to the state,
+// including dropped ones. If the state already stores errors, fn is called
+// with each of them right away.
+func (m *multiErrState) onError(fn func(err error)) {
+	m.Lock()
+	errs := m.errs[:len(m.errs):len(m.errs)]
+	m.callbacks = append(m.callbacks, fn)
+	m.Unlock()
+
+	for _, err := range errs {
+		fn(err)
+	}
+}
+
+// Err returns stored errors joined with errors.Join.
+func (m *multiErrState) Err() error {
+	countVisit()
+
+	return m.ownErr()
+}
+
+func (m *multiErrState) ownErr() error {
+	m.RLock()
+	defer m.RUnlock()
+
+	if len(m.errs) == 0 {
+		return nil
+	}
+
+	errs := m.errs[:len(m.errs):len(m.errs)]
+
+	if m.dropped > 0 {
+		errs = append(errs, fmt.Errorf("%d more errors dropped", m.dropped))
+	}
+
+	return errors.Join(errs...)
+}
+
+// errSig returns a channel that's closed when the first error is assigned
+// to the state.
+func (m *multiErrState) errSig() <-chan struct{} {
+	return m.errC
+}
+
+// snapshot returns a copy of errors stored in the state.
+func (m *multiErrState) snapshot() []error {
+	m.RLock()
+	defer m.RUnlock()
+
+	if len(m.errs) == 0 {
+		return nil
+	}
+
+	return append([]error(nil), m.errs...)
+}
+
+func (m *multiErrState) DependsOn(children ...State) State {
+	return withDependency(m, children...)
+}
+
+func (m *multiErrState) self() State {
+	return m
+}
+
+func (m *multiErrState) kind() string {
+	return "error"
+}
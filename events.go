@@ -0,0 +1,160 @@
+package state
+
+import (
+	"strings"
+	"sync"
+)
+
+// EventKind is a kind of a state's lifecycle transition.
+type EventKind int
+
+const (
+	// EventCreated is sent for every watched state upon subscription.
+	EventCreated EventKind = iota
+
+	// EventReady means the readiness state's tail called Ok.
+	EventReady
+
+	// EventClosing means the shutdown state's tail received the End signal.
+	EventClosing
+
+	// EventClosed means the shutdown state's tail called Done.
+	EventClosed
+
+	// EventErrored means an error was assigned to the state.
+	EventErrored
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventCreated:
+		return "created"
+	case EventReady:
+		return "ready"
+	case EventClosing:
+		return "closing"
+	case EventClosed:
+		return "closed"
+	case EventErrored:
+		return "errored"
+	default:
+		return "unknown"
+	}
+}
+
+// Event reports a lifecycle transition of a state in the tree.
+type Event struct {
+	// Path holds annotations of the state joined with ": ".
+	Path string
+
+	Kind EventKind
+
+	// Err is the error assigned to the state for EventErrored events.
+	Err error
+}
+
+// errSignaler is implemented by states that signal when error
+// is assigned to them.
+type errSignaler interface {
+	errSig() <-chan struct{}
+}
+
+// Events returns a channel that multiplexes lifecycle transitions of
+// shutdown, readiness, error and worker states in st into a single
+// subscription point for monitoring.
+//
+// Upon subscription every such state sends EventCreated, then states send
+// events as they become ready, closing, closed or get an error. Closing
+// and closed events are sent in the causal order, as by ShutdownStream.
+//
+// The channel is closed when st is shut down. Events are buffered, so
+// a slow reader does not slow down the tree.
+func Events(st State) <-chan Event {
+	var (
+		ll      = leaves(st)
+		watched []leaf
+		seen    = make(map[State]struct{})
+	)
+
+	walk(st, func(st State, path []string) bool {
+		if _, ok := seen[st]; ok {
+			return true
+		}
+
+		switch st.(type) {
+		case ShutdownTail, *readinessState, *readinessContextState, errSignaler:
+			seen[st] = struct{}{}
+			watched = append(watched, leaf{State: st, path: strings.Join(path, ": ")})
+		}
+
+		return true
+	})
+
+	var (
+		events = make(chan Event, 2*len(watched)+2*len(ll))
+		stop   = st.finishSig()
+		wg     sync.WaitGroup
+		watch  = newPhaseWatcher(ll, func(l leaf, p ShutdownPhase) {
+			kind := EventClosing
+			if p == ShutdownClosed {
+				kind = EventClosed
+			}
+
+			events <- Event{Path: l.path, Kind: kind}
+		})
+	)
+
+	for _, l := range watched {
+		events <- Event{Path: l.path, Kind: EventCreated}
+
+		if r, ok := asReadiness(l.State); ok {
+			wg.Add(1)
+
+			go func(l leaf) {
+				defer wg.Done()
+
+				if waitSig(r.ready, stop) && r.readyErr() == nil {
+					events <- Event{Path: l.path, Kind: EventReady}
+				}
+			}(l)
+		}
+
+		if e, ok := l.State.(errSignaler); ok {
+			wg.Add(1)
+
+			go func(l leaf) {
+				defer wg.Done()
+
+				if waitSig(e.errSig(), stop) {
+					events <- Event{Path: l.path, Kind: EventErrored, Err: l.State.(errHolder).ownErr()}
+				}
+			}(l)
+		}
+	}
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		watch.watch(stop)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+
+	return events
+}
+
+// asReadiness returns the readiness state of st if it is one.
+func asReadiness(st State) (*readinessState, bool) {
+	switch r := st.(type) {
+	case *readinessState:
+		return r, true
+	case *readinessContextState:
+		return r.readinessState, true
+	default:
+		return nil, false
+	}
+}
@@ -93,12 +93,7 @@ type key int
 var fatalKey key
 
 func getServerFatalCh(st state.State) chan error {
-	value := st.Value(fatalKey)
-	if value != nil {
-		return value.(chan error)
-	}
-
-	return nil
+	return state.GetValueOr[chan error](st, fatalKey, nil)
 }
 
 func (s *Server) Start() state.State {
@@ -161,12 +156,7 @@ func main() {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		err := appState.Shutdown(ctx)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		if err := appState.Err(); err != nil {
+		if err := state.ShutdownAndErr(ctx, appState); err != nil {
 			log.Fatal(err)
 		}
 	}
@@ -54,12 +54,7 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	err := appSt.Shutdown(ctx)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	if err := appSt.Err(); err != nil {
+	if err := state.ShutdownAndErr(ctx, appSt); err != nil {
 		log.Fatal(err)
 	}
 }
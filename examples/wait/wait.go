@@ -18,27 +18,26 @@ func StartJob1() state.State {
 
 	for i := 0; i < 5; i++ {
 		tail.Add(1)
-		go func() {
+		go func(i int) {
 			time.Sleep(1 * time.Second)
-			fmt.Println("done job 1")
+			fmt.Println("done job 1, task", i)
 			tail.Done()
-		}()
+		}(i)
 	}
 
 	return st
 }
 
 func StartJob2() state.State {
-	st, tail := state.WithWait()
+	fns := make([]func(), 0, 5)
 
 	for i := 0; i < 5; i++ {
-		tail.Add(1)
-		go func() {
+		i := i
+		fns = append(fns, func() {
 			time.Sleep(2 * time.Second)
-			fmt.Println("done job 2")
-			tail.Done()
-		}()
+			fmt.Println("done job 2, task", i)
+		})
 	}
 
-	return st
+	return state.WithWaitFunc(fns)
 }
@@ -0,0 +1,15 @@
+package state
+
+import "expvar"
+
+// PublishExpvar publishes st's Snapshot as an expvar variable with name,
+// which is served as JSON at /debug/vars along with other variables.
+// The snapshot is taken anew on each read, so the status stays current.
+//
+// PublishExpvar panics if the name is already registered, the same way
+// expvar.Publish does.
+func PublishExpvar(name string, st State) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return Snapshot(st)
+	}))
+}
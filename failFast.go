@@ -0,0 +1,79 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ShutdownFailFast gracefully shuts down st the same way State's Shutdown
+// does, but returns as soon as any shutdown state in st reports a failed
+// cleanup with ShutdownTail's DoneErr. The returned error is the first
+// reported one, annotated with the state's annotations.
+//
+// Unlike Shutdown, which proceeds through all children on a best-effort
+// basis, ShutdownFailFast short-circuits the remaining closes: states that
+// did not receive the End signal by the time of the failure never receive
+// it, and states that already received it keep shutting down in background.
+func ShutdownFailFast(ctx context.Context, st State) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	failed := new(atomic.Bool)
+
+	walk(st, func(st State, _ []string) bool {
+		if f, ok := st.(interface{ setFailFast(*atomic.Bool) }); ok {
+			f.setFailFast(failed)
+		}
+
+		return true
+	})
+
+	var (
+		ll   = leaves(st)
+		stop = make(chan struct{})
+		once sync.Once
+		fail error
+		wg   sync.WaitGroup
+	)
+
+	for _, l := range ll {
+		s, ok := l.State.(interface{ doneErr() error })
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(l leaf) {
+			defer wg.Done()
+
+			if !waitSig(l.finishSig(), stop) {
+				return
+			}
+
+			if err := s.doneErr(); err != nil {
+				once.Do(func() {
+					if l.path != "" {
+						err = fmt.Errorf("%s: %w", l.path, err)
+					}
+
+					fail = err
+					cancel()
+				})
+			}
+		}(l)
+	}
+
+	err := st.Shutdown(ctx)
+
+	close(stop)
+	wg.Wait()
+
+	if fail != nil {
+		return fail
+	}
+
+	return err
+}
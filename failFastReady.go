@@ -0,0 +1,122 @@
+package state
+
+import (
+	"sync"
+)
+
+type failFastReadyState struct {
+	*group
+
+	readyOut chan struct{}
+
+	sync.Mutex
+}
+
+// MergeFailFastReady returns new State with merged children, which is
+// ready once each of its children is either ready or failed, so Ready
+// does not block forever on a subsystem that already failed instead of
+// becoming ready.
+//
+// A child fails when an error is assigned to any state in it, for example
+// with ErrTail or by a task of WithWorker, or when its Err returns an error
+// upon the Ready call. Check the state's Err after Ready is closed to tell
+// whether all children are actually ready.
+func MergeFailFastReady(states ...State) State {
+	return mergeFailFastReady(states...)
+}
+
+func mergeFailFastReady(states ...State) *failFastReadyState {
+	return &failFastReadyState{
+		group: merge(states...),
+	}
+}
+
+// Ready returns a channel that's closed when each of state's children
+// is ready or failed.
+func (f *failFastReadyState) Ready() <-chan struct{} {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.readyOut != nil {
+		// To avoid memory leaks - readyOut channel is created only once
+		f.resolveReady()
+		return f.readyOut
+	}
+
+	f.readyOut = make(chan struct{})
+
+	resolved := make(chan struct{}, len(f.states))
+
+	for _, s := range f.states {
+		go resolveReady(s, resolved)
+	}
+
+	go func() {
+		for range f.states {
+			<-resolved
+		}
+
+		f.Lock()
+		closeReady(f.readyOut)
+		f.Unlock()
+	}()
+
+	// fast path - readiness is resolved synchronously if possible
+	f.resolveReady()
+
+	return f.readyOut
+}
+
+// resolveReady closes readyOut if each of state's children is ready or
+// failed without blocking. It must be called under the lock.
+func (f *failFastReadyState) resolveReady() {
+	for _, s := range f.states {
+		if !isClosed(s.Ready()) && s.Err() == nil {
+			return
+		}
+	}
+
+	closeReady(f.readyOut)
+}
+
+// resolveReady waits until st is ready or failed and sends to resolved.
+func resolveReady(st State, resolved chan<- struct{}) {
+	defer func() { resolved <- struct{}{} }()
+
+	if st.Err() != nil {
+		return
+	}
+
+	var (
+		sigs   = errSignals(st)
+		failed = make(chan struct{}, len(sigs))
+		stop   = make(chan struct{})
+	)
+
+	defer close(stop)
+
+	for _, sig := range sigs {
+		go func(sig <-chan struct{}) {
+			if waitSig(sig, stop) {
+				failed <- struct{}{}
+			}
+		}(sig)
+	}
+
+	select {
+	case <-st.Ready():
+	case <-failed:
+	}
+}
+
+func (f *failFastReadyState) DependsOn(children ...State) State {
+	return withDependency(f, children...)
+}
+
+func (f *failFastReadyState) self() State {
+	return f
+}
+
+func (f *failFastReadyState) kind() string {
+	return "failFastReady"
+}
@@ -0,0 +1,134 @@
+package state
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileWatchInterval is the interval files are polled at by WithFileWatch.
+const fileWatchInterval = time.Second
+
+type fileWatchState struct {
+	*group
+
+	stop     chan struct{} // closed to stop watching
+	finished chan struct{}
+
+	sync.Mutex
+}
+
+// WithFileWatch returns new State with merged children that is shut down
+// when the file at path is modified, created or removed, which enables
+// hot-reload-on-change workflows in local development.
+//
+// The file is polled for changes of its modification time and size every
+// second. Shutting down on change does not wait for completion - use
+// State's Shutdown to wait until children are shut down. When the state is
+// shut down, the file is not watched anymore.
+func WithFileWatch(path string, children ...State) State {
+	return withFileWatch(path, fileWatchInterval, children...)
+}
+
+func withFileWatch(path string, interval time.Duration, children ...State) *fileWatchState {
+	s := &fileWatchState{
+		group:    merge(children...),
+		stop:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+
+	last, lastErr := os.Stat(path)
+
+	go s.watch(path, interval, last, lastErr)
+
+	return s
+}
+
+// watch polls the file at path and closes the state when it differs from
+// the last stat.
+func (s *fileWatchState) watch(path string, interval time.Duration, last os.FileInfo, lastErr error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+
+		if fileChanged(last, lastErr, info, err) {
+			s.close()
+			return
+		}
+	}
+}
+
+// fileChanged reports whether the file described by stats differs.
+func fileChanged(last os.FileInfo, lastErr error, info os.FileInfo, err error) bool {
+	switch {
+	case (lastErr == nil) != (err == nil):
+		return true // created or removed
+	case err != nil:
+		return false
+	default:
+		return !info.ModTime().Equal(last.ModTime()) || info.Size() != last.Size()
+	}
+}
+
+func (s *fileWatchState) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, s)
+}
+
+func (s *fileWatchState) close() {
+	s.Lock()
+	if !isClosed(s.stop) {
+		close(s.stop)
+	}
+	s.Unlock()
+
+	go s.group.close()
+	<-s.group.finishSig()
+
+	s.Lock()
+	defer s.Unlock()
+
+	select {
+	case <-s.finished:
+		// Already closed
+	default:
+		close(s.finished)
+	}
+}
+
+func (s *fileWatchState) finishSig() <-chan struct{} {
+	return s.finished
+}
+
+func (s *fileWatchState) DependsOn(children ...State) State {
+	return withDependency(s, children...)
+}
+
+func (s *fileWatchState) self() State {
+	return s
+}
+
+func (s *fileWatchState) kind() string {
+	return "fileWatch"
+}
+
+func (s *fileWatchState) cause() error {
+	if err := s.group.cause(); err != nil {
+		return err
+	}
+
+	select {
+	case <-s.finished:
+		return nil
+	default:
+		return newTimeoutError(ErrTimeout)
+	}
+}
@@ -0,0 +1,79 @@
+package state
+
+import (
+	"sync"
+)
+
+// ErrGroup is the interface of golang.org/x/sync/errgroup.Group used by
+// FromErrGroup. It is declared here to avoid depending on the module.
+type ErrGroup interface {
+	Wait() error
+}
+
+type errGroupWaitState struct {
+	*group
+
+	eg   ErrGroup
+	once sync.Once
+	err  error
+
+	sync.RWMutex
+}
+
+// FromErrGroup returns new State, which lets code built on
+// golang.org/x/sync/errgroup take part in a tree of states.
+//
+// State's Wait blocks until g's Wait returns, and State's Err returns g's
+// error afterwards. Err is meaningful only after Wait returned - before
+// that it returns nil.
+func FromErrGroup(g ErrGroup) State {
+	return fromErrGroup(g)
+}
+
+func fromErrGroup(g ErrGroup) *errGroupWaitState {
+	if g == nil {
+		panic("nil error group")
+	}
+
+	return &errGroupWaitState{
+		group: merge(),
+		eg:    g,
+	}
+}
+
+// Wait blocks until error group's Wait returns.
+func (e *errGroupWaitState) Wait() {
+	e.once.Do(func() {
+		err := e.eg.Wait()
+
+		e.Lock()
+		e.err = err
+		e.Unlock()
+	})
+}
+
+// Err returns error group's error once Wait returned.
+func (e *errGroupWaitState) Err() error {
+	countVisit()
+
+	return e.ownErr()
+}
+
+func (e *errGroupWaitState) ownErr() error {
+	e.RLock()
+	defer e.RUnlock()
+
+	return e.err
+}
+
+func (e *errGroupWaitState) DependsOn(children ...State) State {
+	return withDependency(e, children...)
+}
+
+func (e *errGroupWaitState) self() State {
+	return e
+}
+
+func (e *errGroupWaitState) kind() string {
+	return "wait"
+}
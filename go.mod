@@ -1,3 +1,3 @@
 module github.com/lefelys/state
 
-go 1.13
+go 1.21
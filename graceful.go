@@ -0,0 +1,61 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// GracefulError is the error returned by ShutdownGraceful when the
+// shutdown did not complete in time.
+type GracefulError struct {
+	// Forced reports whether the force phase was reached.
+	Forced bool
+
+	// Err is the error returned by the last phase's Shutdown call.
+	Err error
+}
+
+func (e *GracefulError) Error() string {
+	if e.Forced {
+		return "force phase: " + e.Err.Error()
+	}
+
+	return "grace phase: " + e.Err.Error()
+}
+
+// Unwrap returns the error of the last phase.
+func (e *GracefulError) Unwrap() error {
+	return e.Err
+}
+
+// ShutdownGraceful gracefully shuts down st in two phases: first it waits
+// for the shutdown to complete within grace, which is the soft target,
+// and if the shutdown is not complete by then, it waits an additional
+// force duration, which is the hard cap, before giving up.
+//
+// If the shutdown is not complete after both phases, or ctx is done
+// earlier, ShutdownGraceful returns GracefulError reporting which phase
+// was reached; it wraps ErrTimeout.
+func ShutdownGraceful(ctx context.Context, st State, grace, force time.Duration) error {
+	graceCtx, cancel := context.WithTimeout(ctx, grace)
+	defer cancel()
+
+	err := st.Shutdown(graceCtx)
+	if err == nil {
+		return nil
+	}
+
+	if ctx.Err() != nil || !errors.Is(err, ErrTimeout) {
+		return &GracefulError{Err: err}
+	}
+
+	forceCtx, cancel := context.WithTimeout(ctx, force)
+	defer cancel()
+
+	if err = st.Shutdown(forceCtx); err != nil {
+		return &GracefulError{Forced: true, Err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrDependencyCycle is returned by BuildGraph when dependencies form
+	// a cycle.
+	ErrDependencyCycle = errors.New("dependency cycle")
+
+	// ErrUnknownNode is returned by BuildGraph when dependencies refer
+	// to a node that is not defined.
+	ErrUnknownNode = errors.New("unknown node")
+)
+
+// BuildGraph constructs a tree of states from named nodes and their
+// dependencies: deps maps a node's name to names of nodes it depends on,
+// which are shut down before it, the same way DependsOn does. It lets
+// applications define their shutdown graph in configuration instead of
+// nesting DependsOn calls by hand.
+//
+// The returned State merges nodes no other node depends on, in order
+// of their names. A node several nodes depend on is shared between them.
+//
+// BuildGraph returns an error wrapping ErrUnknownNode if deps refer to
+// a node missing in nodes, or nodes has a nil state, and an error wrapping
+// ErrDependencyCycle if dependencies form a cycle.
+func BuildGraph(nodes map[string]State, deps map[string][]string) (State, error) {
+	names := make([]string, 0, len(nodes))
+
+	for name, st := range nodes {
+		if st == nil {
+			return nil, fmt.Errorf("%w: %q has nil state", ErrUnknownNode, name)
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for name, dd := range deps {
+		if _, ok := nodes[name]; !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownNode, name)
+		}
+
+		for _, d := range dd {
+			if _, ok := nodes[d]; !ok {
+				return nil, fmt.Errorf("%w: %q depends on %q", ErrUnknownNode, name, d)
+			}
+		}
+	}
+
+	if cycle := findCycle(names, deps); cycle != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDependencyCycle, strings.Join(cycle, " -> "))
+	}
+
+	var (
+		built    = make(map[string]State, len(nodes))
+		required = make(map[string]bool)
+		build    func(name string) State
+	)
+
+	build = func(name string) State {
+		if st, ok := built[name]; ok {
+			return st
+		}
+
+		st := nodes[name]
+
+		if dd := deps[name]; len(dd) > 0 {
+			children := make([]State, 0, len(dd))
+
+			for _, d := range dd {
+				required[d] = true
+				children = append(children, build(d))
+			}
+
+			st = st.DependsOn(children...)
+		}
+
+		built[name] = st
+
+		return st
+	}
+
+	for _, name := range names {
+		build(name)
+	}
+
+	var roots []State
+
+	for _, name := range names {
+		if !required[name] {
+			roots = append(roots, built[name])
+		}
+	}
+
+	return Merge(roots...), nil
+}
+
+// findCycle returns names of nodes forming a dependency cycle, starting
+// and ending with the same node, or nil if there are no cycles.
+func findCycle(names []string, deps map[string][]string) []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	var (
+		marks = make(map[string]int, len(names))
+		stack []string
+		visit func(name string) []string
+	)
+
+	visit = func(name string) []string {
+		switch marks[name] {
+		case visited:
+			return nil
+		case visiting:
+			for i, n := range stack {
+				if n == name {
+					return append(stack[i:len(stack):len(stack)], name)
+				}
+			}
+		}
+
+		marks[name] = visiting
+		stack = append(stack, name)
+
+		for _, d := range deps[name] {
+			if cycle := visit(d); cycle != nil {
+				return cycle
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		marks[name] = visited
+
+		return nil
+	}
+
+	for _, name := range names {
+		if cycle := visit(name); cycle != nil {
+			return cycle
+		}
+	}
+
+	return nil
+}
@@ -3,15 +3,20 @@ package state
 import (
 	"context"
 	"sync"
+	"time"
 )
 
 type group struct {
-	states  []State
-	toClose map[int]struct{}
+	states []State
 
 	done, finished chan struct{}
 	ready          chan struct{}
 
+	shutdownReason   string
+	shutdownDeadline time.Time
+
+	stable stableErr
+
 	sync.RWMutex
 }
 
@@ -20,6 +25,33 @@ func Merge(states ...State) State {
 	return merge(states...)
 }
 
+// MergeFlat returns new State with merged children the same way Merge
+// does, except that children of states returned by Merge or MergeFlat
+// are merged directly instead of nesting the states, which keeps the tree
+// shallow when states are merged incrementally.
+//
+// States of other kinds are never flattened, as they carry extra behavior.
+func MergeFlat(states ...State) State {
+	flat := make([]State, 0, len(states))
+
+	for _, s := range states {
+		if g, ok := s.(*group); ok {
+			flat = append(flat, g.states...)
+		} else {
+			flat = append(flat, s)
+		}
+	}
+
+	return merge(flat...)
+}
+
+// ShutdownMerged merges states the same way Merge does and gracefully shuts
+// them down in one call. It is a convenience for one-shot teardowns, for
+// example in tests, that do not need to keep the merged state around.
+func ShutdownMerged(ctx context.Context, states ...State) error {
+	return merge(states...).Shutdown(ctx)
+}
+
 func merge(states ...State) *group {
 	if len(states) == 0 {
 		return &group{
@@ -28,45 +60,21 @@ func merge(states ...State) *group {
 		}
 	}
 
-	var (
-		ss       = make([]State, 0, len(states))
-		done     = make(chan struct{})
-		finished = make(chan struct{})
-		toClose  = make(map[int]struct{})
-	)
+	ss := make([]State, 0, len(states))
 
-	for i, s := range states {
-		if s == nil {
-			continue
-		}
-
-		ss = append(ss, s)
-
-		select {
-		case <-s.finishSig():
-			// already closed
-		default:
-			toClose[i] = struct{}{}
-
-			addToCloseStream(done, s)
+	for _, s := range states {
+		if s != nil {
+			ss = append(ss, s)
 		}
 	}
 
 	return &group{
 		states:   ss,
-		toClose:  toClose,
-		done:     done,
-		finished: finished,
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
 	}
 }
 
-func addToCloseStream(done <-chan struct{}, c State) {
-	go func() {
-		<-done
-		c.close()
-	}()
-}
-
 func (g *group) Shutdown(ctx context.Context) error {
 	return shutdown(ctx, g)
 }
@@ -85,22 +93,46 @@ func (g *group) Ready() <-chan struct{} {
 	g.Lock()
 	defer g.Unlock()
 
-	if g.ready != nil {
+	if g.ready == nil {
 		// To avoid memory leaks - ready channel is created only once
-		return g.ready
+		g.ready = make(chan struct{})
+
+		go func() {
+			for _, m := range g.states {
+				<-m.Ready()
+			}
+
+			g.Lock()
+			closeReady(g.ready)
+			g.Unlock()
+		}()
 	}
 
-	g.ready = make(chan struct{})
+	// fast path - readiness is resolved synchronously if possible
+	if allReady(g.states) {
+		closeReady(g.ready)
+	}
 
-	go func() {
-		for _, m := range g.states {
-			<-m.Ready()
+	return g.ready
+}
+
+// allReady reports whether all states are ready without blocking.
+func allReady(states []State) bool {
+	for _, s := range states {
+		if !isClosed(s.Ready()) {
+			return false
 		}
+	}
 
-		close(g.ready)
-	}()
+	return true
+}
 
-	return g.ready
+// closeReady closes ready channel if it is not closed yet.
+// It must be called under the lock guarding c.
+func closeReady(c chan struct{}) {
+	if !isClosed(c) {
+		close(c)
+	}
 }
 
 func (g *group) close() {
@@ -114,27 +146,33 @@ func (g *group) close() {
 	}
 	g.Unlock()
 
-	for i := range g.toClose {
-		<-g.states[i].finishSig()
-		g.Lock()
-		delete(g.toClose, i)
-		g.Unlock()
+	closeHook(g, ShutdownClosing)
+
+	// children are closed concurrently, goroutines are spawned only
+	// for children that are not closed yet
+	for _, s := range g.states {
+		if !isClosed(s.finishSig()) {
+			go s.close()
+		}
+	}
+
+	for _, s := range g.states {
+		<-s.finishSig()
 	}
 
 	close(g.finished)
+	closeHook(g, ShutdownClosed)
 }
 
 func (g *group) Err() error {
-	for _, states := range g.states {
-		if err := states.Err(); err != nil {
-			return err
-		}
-	}
+	countVisit()
 
-	return nil
+	return firstErr(g.states)
 }
 
 func (g *group) Value(key interface{}) (value interface{}) {
+	countVisit()
+
 	for _, states := range g.states {
 		if value = states.Value(key); value != nil {
 			return value
@@ -148,6 +186,58 @@ func (g *group) DependsOn(children ...State) State {
 	return withDependency(g, children...)
 }
 
+func (g *group) self() State {
+	return g
+}
+
+func (g *group) kind() string {
+	return "group"
+}
+
+func (g *group) stableErr() *stableErr {
+	return &g.stable
+}
+
+func (g *group) childStates() []State {
+	return g.states
+}
+
+func (g *group) setReason(reason string) {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.shutdownReason == "" {
+		g.shutdownReason = reason
+	}
+}
+
+func (g *group) reason() string {
+	g.RLock()
+	defer g.RUnlock()
+
+	return g.shutdownReason
+}
+
+func (g *group) setDeadline(deadline time.Time) bool {
+	g.Lock()
+	defer g.Unlock()
+
+	if !g.shutdownDeadline.IsZero() {
+		return false
+	}
+
+	g.shutdownDeadline = deadline
+
+	return true
+}
+
+func (g *group) deadline() time.Time {
+	g.RLock()
+	defer g.RUnlock()
+
+	return g.shutdownDeadline
+}
+
 func (g *group) cause() error {
 	g.RLock()
 	defer g.RUnlock()
@@ -0,0 +1,128 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+type guardState struct {
+	*group
+
+	check    func(ctx context.Context) error
+	once     sync.Once
+	checkErr error
+	finished chan struct{}
+
+	sync.Mutex
+}
+
+// WithShutdownGuard returns new State with merged children that runs check
+// before shutting down. If check returns an error, the state refuses
+// to shut down: neither it nor its children are closed, and the error is
+// returned by Shutdown wrapped in TimeoutError once ctx expires.
+//
+// The check is run once, upon the first shutdown. The context passed to it
+// carries the deadline of the shutdown, if there is one, so the check
+// can delay the shutdown until it is safe, for example until an active
+// transaction is committed, and refuse it with a reason if the deadline
+// expires first.
+func WithShutdownGuard(check func(ctx context.Context) error, children ...State) State {
+	return withShutdownGuard(check, children...)
+}
+
+func withShutdownGuard(check func(ctx context.Context) error, children ...State) *guardState {
+	if check == nil {
+		panic("nil shutdown guard")
+	}
+
+	return &guardState{
+		group:    merge(children...),
+		check:    check,
+		finished: make(chan struct{}),
+	}
+}
+
+func (g *guardState) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, g)
+}
+
+func (g *guardState) close() {
+	g.once.Do(g.guard)
+
+	if g.guardErr() != nil {
+		return
+	}
+
+	go g.group.close()
+	<-g.group.finishSig()
+
+	g.Lock()
+	defer g.Unlock()
+
+	select {
+	case <-g.finished:
+		// Already closed
+	default:
+		close(g.finished)
+	}
+}
+
+// guard runs the check and records its error.
+func (g *guardState) guard() {
+	ctx := context.Background()
+
+	if deadline := g.deadline(); !deadline.IsZero() {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	err := g.check(ctx)
+
+	g.Lock()
+	defer g.Unlock()
+
+	g.checkErr = err
+}
+
+func (g *guardState) guardErr() error {
+	g.Lock()
+	defer g.Unlock()
+
+	return g.checkErr
+}
+
+func (g *guardState) finishSig() <-chan struct{} {
+	return g.finished
+}
+
+func (g *guardState) DependsOn(children ...State) State {
+	return withDependency(g, children...)
+}
+
+func (g *guardState) self() State {
+	return g
+}
+
+func (g *guardState) kind() string {
+	return "guard"
+}
+
+func (g *guardState) cause() error {
+	g.Lock()
+	defer g.Unlock()
+
+	select {
+	case <-g.finished:
+		return nil
+	default:
+	}
+
+	if g.checkErr != nil {
+		return newTimeoutError(fmt.Errorf("shutdown refused by guard: %w", g.checkErr))
+	}
+
+	return g.group.cause()
+}
@@ -0,0 +1,33 @@
+package state
+
+import "context"
+
+// Handle stops a single shutdownable state independently of the tree
+// it is merged into.
+type Handle interface {
+	// Stop gracefully shuts down the handle's state the same way its
+	// Shutdown method does, without shutting down the rest of the tree.
+	// Children of the state are shut down first, as they are its
+	// dependencies.
+	//
+	// A stopped state is considered finished by later shutdowns of the
+	// tree.
+	Stop(ctx context.Context) error
+}
+
+type shutdownHandle struct {
+	s *shutdownState
+}
+
+// WithShutdownHandle returns a new shutdownable State that depends on
+// children the same way WithShutdown does, along with a Handle that stops
+// the state alone, for example to cycle one subsystem while the rest of
+// the application keeps running.
+func WithShutdownHandle(children ...State) (State, ShutdownTail, Handle) {
+	s := withShutdown(children...)
+	return s, s, shutdownHandle{s}
+}
+
+func (h shutdownHandle) Stop(ctx context.Context) error {
+	return h.s.Shutdown(ctx)
+}
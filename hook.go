@@ -0,0 +1,41 @@
+package state
+
+import "sync"
+
+// closeHooks holds hooks called synchronously when states reach shutdown
+// phases. It lets tests await specific states reaching specific phases
+// without sleeping.
+var closeHooks struct {
+	m map[State]func(ShutdownPhase)
+
+	sync.RWMutex
+}
+
+// setCloseHook sets hook called when st reaches a shutdown phase.
+// Nil hook removes the previously set one.
+func setCloseHook(st State, hook func(ShutdownPhase)) {
+	closeHooks.Lock()
+	defer closeHooks.Unlock()
+
+	if hook == nil {
+		delete(closeHooks.m, st)
+		return
+	}
+
+	if closeHooks.m == nil {
+		closeHooks.m = make(map[State]func(ShutdownPhase))
+	}
+
+	closeHooks.m[st] = hook
+}
+
+// closeHook calls the hook set for st, if any.
+func closeHook(st State, phase ShutdownPhase) {
+	closeHooks.RLock()
+	hook := closeHooks.m[st]
+	closeHooks.RUnlock()
+
+	if hook != nil {
+		hook(phase)
+	}
+}
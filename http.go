@@ -0,0 +1,148 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+)
+
+type httpServerState struct {
+	*drainState
+
+	server *shutdownState
+
+	active map[net.Conn]struct{} // connections serving requests
+	connMu sync.Mutex
+}
+
+// HTTPServer returns new State with merged children that runs srv.
+//
+// The server is started with ListenAndServe in a new goroutine. Its fatal
+// error, that is any error other than http.ErrServerClosed, is assigned
+// to the State. Upon shutdown, after children are shut down, srv's Shutdown
+// is called with a context that carries the deadline of the shutdown,
+// if there is one, and its error is assigned to the State as well.
+//
+// Requests in flight are tracked with srv's ConnState hook, wrapping
+// the existing one, and the shutdown waits until they are drained.
+// If shutdown's timeout expires first, Shutdown returns DrainTimeoutError
+// reporting how many requests were still in flight. Use InFlight to get
+// the number of requests in flight at any time.
+func HTTPServer(srv *http.Server, children ...State) State {
+	if srv == nil {
+		panic("nil http server")
+	}
+
+	var (
+		shutdownSt = withShutdown(children...)
+		errSt      = withErrorGroup()
+		h          = &httpServerState{
+			drainState: withDrain(shutdownSt),
+			server:     shutdownSt,
+			active:     make(map[net.Conn]struct{}),
+		}
+	)
+
+	connState := srv.ConnState
+	srv.ConnState = func(conn net.Conn, state http.ConnState) {
+		h.track(conn, state)
+
+		if connState != nil {
+			connState(conn, state)
+		}
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			errSt.Errorf("http server: %w", err)
+		}
+	}()
+
+	go func() {
+		signal := <-shutdownSt.EndReason()
+
+		ctx := context.Background()
+
+		if !signal.Deadline.IsZero() {
+			var cancel context.CancelFunc
+
+			ctx, cancel = context.WithDeadline(ctx, signal.Deadline)
+			defer cancel()
+		}
+
+		if err := srv.Shutdown(ctx); err != nil {
+			errSt.Errorf("http server shutdown: %w", err)
+		}
+
+		shutdownSt.Done()
+	}()
+
+	return merge(h, errSt)
+}
+
+// track counts requests in flight by connection state transitions.
+func (h *httpServerState) track(conn net.Conn, state http.ConnState) {
+	h.connMu.Lock()
+	defer h.connMu.Unlock()
+
+	_, active := h.active[conn]
+
+	switch {
+	case state == http.StateActive && !active:
+		h.active[conn] = struct{}{}
+		h.Add(1)
+	case state != http.StateActive && active:
+		delete(h.active, conn)
+		h.Done()
+	}
+}
+
+func (h *httpServerState) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, h)
+}
+
+// cause reports requests in flight as the cause of the timeout, since
+// srv's Shutdown waits for them to be drained.
+func (h *httpServerState) cause() error {
+	if err := h.server.group.cause(); err != nil {
+		return err
+	}
+
+	if n := h.inFlight(); n > 0 && !isClosed(h.finishSig()) {
+		return newTimeoutError(&DrainTimeoutError{InFlight: n})
+	}
+
+	return h.drainState.cause()
+}
+
+func (h *httpServerState) DependsOn(children ...State) State {
+	return withDependency(h, children...)
+}
+
+func (h *httpServerState) self() State {
+	return h
+}
+
+// InFlight returns the number of requests in flight in all HTTP servers
+// in st, run by HTTPServer.
+func InFlight(st State) (n int) {
+	seen := make(map[State]struct{})
+
+	walk(st, func(st State, _ []string) bool {
+		h, ok := st.(*httpServerState)
+		if !ok {
+			return true
+		}
+
+		if _, ok := seen[st]; !ok {
+			seen[st] = struct{}{}
+			n += h.inFlight()
+		}
+
+		return true
+	})
+
+	return n
+}
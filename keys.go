@@ -0,0 +1,71 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrKeyRegistered is the error returned by RegisterKey when the key
+// is already registered.
+var ErrKeyRegistered = errors.New("state value key is already registered")
+
+// keys is the registry of value keys.
+var keys = struct {
+	registered map[interface{}]struct{}
+	strict     bool
+
+	sync.RWMutex
+}{
+	registered: map[interface{}]struct{}{
+		requestContextKey{}: {},
+		sharedErrTailKey{}:  {},
+	},
+}
+
+// RegisterKey registers key as a state value key. It returns an error
+// wrapping ErrKeyRegistered if a key of the same dynamic type and value
+// is already registered, which catches collisions of keys defined by
+// different packages.
+//
+// Packages should register their keys in init functions.
+// RegisterKey panics if key can not be used as a state value key.
+func RegisterKey(key interface{}) error {
+	checkKey(key)
+
+	keys.Lock()
+	defer keys.Unlock()
+
+	if _, ok := keys.registered[key]; ok {
+		return fmt.Errorf("%w: %T(%v)", ErrKeyRegistered, key, key)
+	}
+
+	keys.registered[key] = struct{}{}
+
+	return nil
+}
+
+// SetStrictKeys enables or disables the strict keys mode. In the strict
+// mode, WithValue and WithMutableValue panic if the key is not registered
+// with RegisterKey.
+func SetStrictKeys(strict bool) {
+	keys.Lock()
+	defer keys.Unlock()
+
+	keys.strict = strict
+}
+
+// checkRegistered panics if the strict keys mode is enabled and key
+// is not registered.
+func checkRegistered(key interface{}) {
+	keys.RLock()
+	defer keys.RUnlock()
+
+	if !keys.strict {
+		return
+	}
+
+	if _, ok := keys.registered[key]; !ok {
+		panic(fmt.Sprintf("unregistered state value key %T(%v)", key, key))
+	}
+}
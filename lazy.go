@@ -0,0 +1,60 @@
+package state
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type lazyState struct {
+	provider func() []State
+
+	g    *group
+	once sync.Once
+
+	stable stableErr
+}
+
+// MergeLazy returns new State, which children are provided by provider
+// the first time the state is used: shut down, checked for errors, values
+// or readiness, waited on or traversed by the package's functions. It lets
+// wiring of optional subsystems, such as plugins discovered at runtime,
+// be deferred until they are needed.
+//
+// The provider is called at most once. After that the state behaves the
+// same way as a state returned by Merge with provided children.
+func MergeLazy(provider func() []State) State {
+	if provider == nil {
+		panic("nil lazy merge provider")
+	}
+
+	return &lazyState{provider: provider}
+}
+
+// group returns merged children, calling the provider on the first call.
+func (l *lazyState) group() *group {
+	l.once.Do(func() {
+		l.g = merge(l.provider()...)
+		l.provider = nil
+	})
+
+	return l.g
+}
+
+func (l *lazyState) Err() error                          { return l.group().Err() }
+func (l *lazyState) Wait()                               { l.group().Wait() }
+func (l *lazyState) Shutdown(ctx context.Context) error  { return shutdown(ctx, l) }
+func (l *lazyState) Ready() <-chan struct{}              { return l.group().Ready() }
+func (l *lazyState) Value(key interface{}) interface{}   { return l.group().Value(key) }
+func (l *lazyState) DependsOn(children ...State) State   { return withDependency(l, children...) }
+func (l *lazyState) close()                              { l.group().close() }
+func (l *lazyState) finishSig() <-chan struct{}          { return l.group().finishSig() }
+func (l *lazyState) cause() error                        { return l.group().cause() }
+func (l *lazyState) self() State                         { return l }
+func (l *lazyState) childStates() []State                { return l.group().childStates() }
+func (l *lazyState) kind() string                        { return "group" }
+func (l *lazyState) setReason(reason string)             { l.group().setReason(reason) }
+func (l *lazyState) reason() string                      { return l.group().reason() }
+func (l *lazyState) setDeadline(deadline time.Time) bool { return l.group().setDeadline(deadline) }
+func (l *lazyState) deadline() time.Time                 { return l.group().deadline() }
+func (l *lazyState) stableErr() *stableErr               { return &l.stable }
@@ -0,0 +1,173 @@
+package state
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// leaf is a shutdown state serviced by a background job through
+// ShutdownTail.
+type leaf struct {
+	State
+
+	path string
+}
+
+// leaves returns all distinct shutdown states in st in the order they are
+// shut down: children before their parents and dependencies before
+// dependent states.
+func leaves(st State) (ll []leaf) {
+	seen := make(map[State]struct{})
+
+	closeOrder(st, nil, func(st State, path []string) {
+		if _, ok := st.(ShutdownTail); !ok {
+			return
+		}
+
+		if _, ok := seen[st]; !ok {
+			seen[st] = struct{}{}
+			ll = append(ll, leaf{State: st, path: strings.Join(path, ": ")})
+		}
+	})
+
+	return ll
+}
+
+// closeOrder traverses the tree of states in post-order, visiting children
+// of dependency states before their parents.
+func closeOrder(st State, path []string, visit func(st State, path []string)) {
+	st = st.self()
+
+	if a, ok := st.(annotator); ok {
+		path = append(path[:len(path):len(path)], a.label())
+	}
+
+	if d, ok := st.(*dependState); ok {
+		for _, child := range d.children.states {
+			closeOrder(child, path, visit)
+		}
+
+		closeOrder(d.parent, path, visit)
+	} else {
+		for _, child := range st.childStates() {
+			closeOrder(child, path, visit)
+		}
+	}
+
+	visit(st, path)
+}
+
+// phaseWatcher reports shutdown phases reached by leaves in causal order:
+// a state is never reported closing before the states it depends on are
+// reported closed.
+type phaseWatcher struct {
+	leaves []leaf
+	sent   []int // number of phases reported for each leaf
+	emit   func(l leaf, p ShutdownPhase)
+
+	sync.Mutex
+}
+
+func newPhaseWatcher(ll []leaf, emit func(l leaf, p ShutdownPhase)) *phaseWatcher {
+	return &phaseWatcher{
+		leaves: ll,
+		sent:   make([]int, len(ll)),
+		emit:   emit,
+	}
+}
+
+// watch reports phases of leaves until stop is closed.
+func (w *phaseWatcher) watch(stop <-chan struct{}) {
+	var wg sync.WaitGroup
+
+	for _, l := range w.leaves {
+		wg.Add(1)
+
+		go func(l leaf) {
+			defer wg.Done()
+
+			if waitSig(l.State.(ShutdownTail).End(), stop) {
+				w.flush()
+			}
+
+			if waitSig(l.finishSig(), stop) {
+				w.flush()
+			}
+		}(l)
+	}
+
+	wg.Wait()
+	w.flush()
+}
+
+// flush reports all phases reached by leaves so far.
+func (w *phaseWatcher) flush() {
+	w.Lock()
+	defer w.Unlock()
+
+	// Phases are observed from dependent states to their dependencies:
+	// if a dependent state is observed closing, its dependencies are
+	// already closed at the time they are observed.
+	reached := make([]int, len(w.leaves))
+
+	for i := len(w.leaves) - 1; i >= 0; i-- {
+		l := w.leaves[i]
+
+		switch {
+		case isClosed(l.finishSig()):
+			reached[i] = 2
+		case isClosed(l.State.(ShutdownTail).End()):
+			reached[i] = 1
+		}
+	}
+
+	for i, l := range w.leaves {
+		for p := w.sent[i]; p < reached[i]; p++ {
+			w.emit(l, ShutdownPhase(p))
+		}
+
+		if reached[i] > w.sent[i] {
+			w.sent[i] = reached[i]
+		}
+	}
+}
+
+// waitSig blocks until c or stop is closed and reports whether c is closed.
+func waitSig(c, stop <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	case <-stop:
+		return isClosed(c)
+	}
+}
+
+// waitDeadline blocks until c is closed or deadline expires, if it is set,
+// and reports whether c is closed.
+func waitDeadline(c <-chan struct{}, deadline time.Time) bool {
+	if deadline.IsZero() {
+		<-c
+		return true
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-c:
+		return true
+	case <-timer.C:
+		return isClosed(c)
+	}
+}
+
+// isClosed reports whether c is closed without blocking.
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,170 @@
+package state
+
+import "sync"
+
+// LiveReadinessTail detaches after live readiness state initialization.
+// The tail is supposed to stay in a background job associated with
+// created State as it carries readiness signals.
+type LiveReadinessTail interface {
+	// Ready sends a signal that background job is ready.
+	// Subsequent calls do nothing until NotReady is called.
+	Ready()
+
+	// NotReady sends a signal that background job is not ready anymore,
+	// for example when its connection is lost.
+	// Subsequent calls do nothing until Ready is called.
+	NotReady()
+}
+
+type liveReadinessState struct {
+	*group
+
+	ready    chan struct{} // closed when the tail is ready
+	readyOut chan struct{}
+	lost     chan struct{} // closed when readiness is lost
+	err      error
+
+	sync.Mutex
+}
+
+type liveReadinessTail struct {
+	s *liveReadinessState
+}
+
+// WithLiveReadiness returns new State with merged children, which
+// readiness can be lost and regained, as opposed to WithReadiness.
+//
+// The returned LiveReadinessTail is used to toggle readiness. State's Ready
+// reflects the latest readiness at the time of the call: it returns
+// a channel that's closed when the state is ready, right away if it is
+// ready already. The channel is closed once the state becomes ready, even
+// if it is not ready again by the time it is received from, so rapid
+// toggling may be observed as a single transition. Check Ready again to get
+// the latest readiness.
+//
+// Parents' Ready channels are one-shot as usual: they are closed the first
+// time the state is ready.
+func WithLiveReadiness(children ...State) (State, LiveReadinessTail) {
+	s := withLiveReadiness(children...)
+	return s, liveReadinessTail{s: s}
+}
+
+func withLiveReadiness(children ...State) *liveReadinessState {
+	return &liveReadinessState{
+		group: merge(children...),
+		ready: make(chan struct{}),
+	}
+}
+
+func (t liveReadinessTail) Ready() {
+	t.s.Lock()
+	defer t.s.Unlock()
+
+	select {
+	case <-t.s.ready:
+		// Already ready
+	default:
+		close(t.s.ready)
+	}
+}
+
+func (t liveReadinessTail) NotReady() {
+	t.s.Lock()
+	defer t.s.Unlock()
+
+	if t.s.err != nil {
+		return // Aborted
+	}
+
+	select {
+	case <-t.s.ready:
+		t.s.ready = make(chan struct{})
+		t.s.readyOut = nil
+
+		if t.s.lost != nil {
+			close(t.s.lost)
+			t.s.lost = nil
+		}
+	default:
+		// Already not ready
+	}
+}
+
+func (s *liveReadinessState) Ready() <-chan struct{} {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.readyOut == nil {
+		// To avoid memory leaks - readyOut channel is created only once
+		// until readiness is lost
+		s.readyOut = make(chan struct{})
+
+		go func(ready, readyOut chan struct{}) {
+			<-s.group.Ready()
+			<-ready
+
+			s.Lock()
+			closeReady(readyOut)
+			s.Unlock()
+		}(s.ready, s.readyOut)
+	}
+
+	// fast path - readiness is resolved synchronously if possible
+	if isClosed(s.ready) && isClosed(s.group.Ready()) {
+		closeReady(s.readyOut)
+	}
+
+	return s.readyOut
+}
+
+// lostSig returns a channel that's closed when the state's readiness
+// is lost, right away if the state is not ready.
+func (s *liveReadinessState) lostSig() <-chan struct{} {
+	s.Lock()
+	defer s.Unlock()
+
+	if !isClosed(s.ready) {
+		return closedchan
+	}
+
+	if s.lost == nil {
+		s.lost = make(chan struct{})
+	}
+
+	return s.lost
+}
+
+func (s *liveReadinessState) DependsOn(children ...State) State {
+	return withDependency(s, children...)
+}
+
+func (s *liveReadinessState) self() State {
+	return s
+}
+
+func (s *liveReadinessState) kind() string {
+	return "readiness"
+}
+
+// abortReady resolves the state's readiness as failed with
+// ErrReadinessAborted if it is not ready yet. Aborted readiness is not
+// lost anymore.
+func (s *liveReadinessState) abortReady() {
+	s.Lock()
+	defer s.Unlock()
+
+	select {
+	case <-s.ready:
+		// Already ready
+	default:
+		s.err = ErrReadinessAborted
+		close(s.ready)
+	}
+}
+
+func (s *liveReadinessState) readyErr() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.err
+}
@@ -0,0 +1,96 @@
+package state
+
+import (
+	"sync"
+)
+
+type mutableValueState struct {
+	*group
+
+	key   interface{}
+	value interface{}
+
+	changed chan struct{} // closed and replaced when value is set
+
+	sync.RWMutex
+}
+
+// WithMutableValue returns new State with merged children and value
+// assigned to key, which can be replaced at runtime without rebuilding
+// the tree, for example on configuration reload.
+//
+// The returned setter atomically replaces the value. Value calls return
+// the latest value set, and ValueWait calls waiting for key are woken up.
+//
+// Keys follow the same rules as in WithValue.
+func WithMutableValue(key, initial interface{}, children ...State) (State, func(value interface{})) {
+	s := withMutableValue(key, initial, children...)
+	return s, s.set
+}
+
+func withMutableValue(key, initial interface{}, children ...State) *mutableValueState {
+	checkKey(key)
+	checkRegistered(key)
+
+	return &mutableValueState{
+		group:   merge(children...),
+		key:     key,
+		value:   initial,
+		changed: make(chan struct{}),
+	}
+}
+
+func (m *mutableValueState) set(value interface{}) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.value = value
+
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// valueSig returns a channel that's closed when the value is set next time.
+func (m *mutableValueState) valueSig() <-chan struct{} {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.changed
+}
+
+// Value returns the latest value assotiated with key from mutableValueState
+// or from its children, or nil if it is not found.
+func (m *mutableValueState) Value(key interface{}) (value interface{}) {
+	countVisit()
+
+	if m.key == key {
+		m.RLock()
+		defer m.RUnlock()
+
+		return m.value
+	}
+
+	return m.group.Value(key)
+}
+
+func (m *mutableValueState) DependsOn(children ...State) State {
+	return withDependency(m, children...)
+}
+
+func (m *mutableValueState) self() State {
+	return m
+}
+
+func (m *mutableValueState) kind() string {
+	return "value"
+}
+
+func (m *mutableValueState) keyValue() (key, value interface{}) {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.key, m.value
+}
+
+// mutable marks values that can change after the state is created.
+func (m *mutableValueState) mutable() {}
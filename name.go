@@ -0,0 +1,121 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+type nameState struct {
+	*group
+
+	name string
+}
+
+// ErrNameNotFound is the error returned by operations on named subtrees
+// when no state with the name is found.
+var ErrNameNotFound = errors.New("state name not found")
+
+// WithName returns new State with merged children and name assigned to it.
+//
+// Names identify subtrees for operations like WaitReadyNamed. Unlike
+// annotations, names do not annotate errors.
+func WithName(name string, children ...State) State {
+	return withName(name, children...)
+}
+
+func withName(name string, children ...State) *nameState {
+	return &nameState{
+		group: merge(children...),
+		name:  name,
+	}
+}
+
+// MergeNamed returns new State with merged branches, each named with its
+// key the same way WithName does, in the order of names. Err, Value, Wait
+// and Ready aggregate across all branches as with Merge, while a single
+// branch can be shut down with ShutdownBranch.
+func MergeNamed(branches map[string]State) State {
+	names := make([]string, 0, len(branches))
+
+	for name := range branches {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	states := make([]State, 0, len(names))
+
+	for _, name := range names {
+		states = append(states, withName(name, branches[name]))
+	}
+
+	return merge(states...)
+}
+
+func (n *nameState) DependsOn(children ...State) State {
+	return withDependency(n, children...)
+}
+
+func (n *nameState) self() State {
+	return n
+}
+
+func (n *nameState) kind() string {
+	return "name"
+}
+
+// findNamed returns the topmost and the leftmost state in st with name,
+// or an error wrapping ErrNameNotFound.
+func findNamed(st State, name string) (found State, err error) {
+	walk(st, func(st State, _ []string) bool {
+		if n, ok := st.(*nameState); ok && found == nil && n.name == name {
+			found = n
+		}
+
+		return found == nil
+	})
+
+	if found == nil {
+		return nil, fmt.Errorf("%w: %q", ErrNameNotFound, name)
+	}
+
+	return found, nil
+}
+
+// WaitReadyNamed blocks until the subtree of st named name with WithName
+// is ready, ignoring readiness of the rest of the tree. If there are
+// multiple states with the same name, the topmost and the leftmost is used.
+//
+// WaitReadyNamed returns ctx's error if ctx expires first, or an error
+// wrapping ErrNameNotFound if there is no state with the name.
+func WaitReadyNamed(ctx context.Context, st State, name string) error {
+	named, err := findNamed(st, name)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-named.Ready():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ShutdownBranch gracefully shuts down the subtree of st named name with
+// MergeNamed or WithName, leaving the rest of the tree running. A later
+// shutdown of st considers the branch finished. If there are multiple
+// states with the same name, the topmost and the leftmost is used.
+//
+// ShutdownBranch returns an error wrapping ErrNameNotFound if there is no
+// state with the name, or the branch's shutdown error otherwise.
+func ShutdownBranch(ctx context.Context, st State, name string) error {
+	named, err := findNamed(st, name)
+	if err != nil {
+		return err
+	}
+
+	return named.Shutdown(ctx)
+}
@@ -0,0 +1,7 @@
+//go:build !statedebug
+
+package state
+
+// countVisit records a visit of a state by a traversal. It does nothing
+// in builds without the statedebug tag.
+func countVisit() {}
@@ -0,0 +1,60 @@
+package state
+
+// ShutdownOrder statically computes the order st is shut down in, without
+// shutting anything down: it returns batches of annotation paths of
+// shutdown states, joined with ": ", where states of a batch are shut down
+// concurrently and only after all states of previous batches are shut
+// down, in the worst case. Paths within a batch are ordered the same way
+// states are closed.
+//
+// It lets tests assert the expected shutdown order, for example that
+// handlers are shut down before services and services before a database,
+// without relying on timing.
+func ShutdownOrder(st State) (batches [][]string) {
+	var (
+		p     = newClosePlan(st)
+		deps  = make([][]int, len(p.nodes))
+		start = make([]int, len(p.nodes))
+		done  = make([]bool, len(p.nodes))
+		end   func(i int) int
+	)
+
+	for from, dependents := range p.dependents {
+		for _, to := range dependents {
+			deps[to] = append(deps[to], from)
+		}
+	}
+
+	// end returns the number of batches shut down before the i-th state
+	// is shut down, including its own one.
+	end = func(i int) int {
+		if !done[i] {
+			for _, d := range deps[i] {
+				if e := end(d); e > start[i] {
+					start[i] = e
+				}
+			}
+
+			done[i] = true
+		}
+
+		if _, ok := p.nodes[i].(ShutdownTail); ok {
+			return start[i] + 1
+		}
+
+		return start[i]
+	}
+
+	for _, l := range leaves(st) {
+		i := p.index[l.State]
+		end(i)
+
+		for len(batches) <= start[i] {
+			batches = append(batches, nil)
+		}
+
+		batches[start[i]] = append(batches[start[i]], l.path)
+	}
+
+	return batches
+}
@@ -0,0 +1,146 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+type pauseState struct {
+	*group
+
+	unpaused chan struct{} // closed when the state is not paused
+	closing  bool
+	finished chan struct{}
+
+	// abandoned is set when closing stopped waiting for the pause past
+	// the shutdown deadline
+	abandoned bool
+
+	sync.Mutex
+}
+
+// errPaused is returned by pause state's cause when the state is paused.
+var errPaused = fmt.Errorf("shutdown is paused: %w", ErrTimeout)
+
+// WithPause returns new State with child, which shutdown can be paused
+// temporarily, and a function to toggle the pause.
+//
+// While the state is paused, it does not start shutting down child,
+// so a subtree can be held up during a critical section, for example
+// a config reload, while the rest of the tree shuts down around it.
+// The shutdown proceeds once the state is unpaused. Pausing has no
+// effect once the state started shutting down its child.
+//
+// Pausing does not extend the shutdown timeout: if ctx expires while the
+// state is paused, Shutdown returns ErrTimeout, and the shutdown of child
+// proceeds in background once the state is unpaused.
+func WithPause(child State) (State, func(paused bool)) {
+	p := withPause(child)
+	return p, p.pause
+}
+
+func withPause(child State) *pauseState {
+	return &pauseState{
+		group:    merge(child),
+		unpaused: closedchan,
+		finished: make(chan struct{}),
+	}
+}
+
+func (p *pauseState) pause(paused bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.closing {
+		return
+	}
+
+	switch {
+	case paused && isClosed(p.unpaused):
+		p.unpaused = make(chan struct{})
+	case !paused && !isClosed(p.unpaused):
+		close(p.unpaused)
+
+		if p.abandoned {
+			p.abandoned = false
+			go p.close()
+		}
+	}
+}
+
+func (p *pauseState) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, p)
+}
+
+func (p *pauseState) close() {
+	for {
+		p.Lock()
+		unpaused := p.unpaused
+		closing := isClosed(unpaused)
+		p.closing = p.closing || closing
+		p.Unlock()
+
+		if closing {
+			break
+		}
+
+		// the pause is not waited for past the shutdown deadline, so the
+		// closing does not leak if the state is never unpaused - it is
+		// resumed by unpausing instead
+		if !waitDeadline(unpaused, p.deadline()) {
+			p.Lock()
+			abandoned := !isClosed(p.unpaused)
+			p.abandoned = p.abandoned || abandoned
+			p.Unlock()
+
+			if abandoned {
+				return
+			}
+		}
+	}
+
+	go p.group.close()
+	<-p.group.finishSig()
+
+	p.Lock()
+	defer p.Unlock()
+
+	select {
+	case <-p.finished:
+		// Already closed
+	default:
+		close(p.finished)
+	}
+}
+
+func (p *pauseState) finishSig() <-chan struct{} {
+	return p.finished
+}
+
+func (p *pauseState) DependsOn(children ...State) State {
+	return withDependency(p, children...)
+}
+
+func (p *pauseState) self() State {
+	return p
+}
+
+func (p *pauseState) kind() string {
+	return "pause"
+}
+
+func (p *pauseState) cause() error {
+	p.Lock()
+	paused := !p.closing && !isClosed(p.unpaused)
+	p.Unlock()
+
+	switch {
+	case isClosed(p.finished):
+		return nil
+	case paused:
+		return newTimeoutError(errPaused)
+	default:
+		return p.group.cause()
+	}
+}
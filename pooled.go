@@ -0,0 +1,199 @@
+package state
+
+import (
+	"context"
+	"sync"
+)
+
+// ShutdownPooled gracefully shuts down st the same way State's Shutdown
+// does, but processes close operations of states through a pool of workers
+// goroutines instead of spawning a goroutine per state, which bounds
+// resource usage of large shutdowns.
+//
+// The shutdown order is the same: a state is closed only after its
+// children and the states it depends on are shut down. A worker waits
+// until the state it closed is shut down, so at most workers shutdown
+// states are shutting down concurrently.
+//
+// ShutdownPooled panics if workers is not positive.
+func ShutdownPooled(ctx context.Context, st State, workers int) error {
+	if workers <= 0 {
+		panic("non-positive shutdown pool size")
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		recordDeadline(st, deadline)
+	}
+
+	defer startWatchdog(st)()
+
+	p := newClosePlan(st)
+
+	var (
+		ready     = make(chan int, len(p.nodes))
+		done      = make(chan struct{})
+		remaining = len(p.nodes)
+		mu        sync.Mutex
+	)
+
+	for i, n := range p.pending {
+		if n == 0 {
+			ready <- i
+		}
+	}
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			for {
+				var i int
+
+				select {
+				case i = <-ready:
+				case <-done:
+					return
+				case <-ctx.Done():
+					return
+				}
+
+				node := p.nodes[i]
+
+				if !isClosed(node.finishSig()) {
+					node.close()
+				}
+
+				select {
+				case <-node.finishSig():
+				case <-ctx.Done():
+					return
+				}
+
+				mu.Lock()
+
+				for _, j := range p.dependents[i] {
+					if p.pending[j]--; p.pending[j] == 0 {
+						ready <- j
+					}
+				}
+
+				if remaining--; remaining == 0 {
+					close(done)
+				}
+
+				mu.Unlock()
+			}
+		}()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return st.cause()
+	}
+}
+
+// closePlan is a graph of states in st, where edges lead from states
+// to states that can be closed only after them.
+type closePlan struct {
+	nodes      []State
+	index      map[State]int
+	children   [][]int // direct children of the state
+	pending    []int   // number of states that must be closed first
+	dependents [][]int // states waiting for the state to be closed
+}
+
+func newClosePlan(st State) *closePlan {
+	p := &closePlan{index: make(map[State]int)}
+	p.add(st)
+
+	return p
+}
+
+// add adds st and its children to the plan and returns st's index.
+func (p *closePlan) add(st State) int {
+	st = st.self()
+
+	if i, ok := p.index[st]; ok {
+		return i
+	}
+
+	var children []int
+
+	if d, ok := st.(*dependState); ok {
+		for k, child := range d.children.states {
+			c := p.add(child)
+
+			if d.ordered && k > 0 {
+				p.before(children[k-1], c)
+			}
+
+			children = append(children, c)
+		}
+
+		parent := p.add(d.parent)
+
+		for _, c := range children {
+			p.before(c, parent)
+		}
+
+		children = append(children, parent)
+	} else {
+		for _, child := range st.childStates() {
+			children = append(children, p.add(child))
+		}
+	}
+
+	i := len(p.nodes)
+
+	p.index[st] = i
+	p.nodes = append(p.nodes, st)
+	p.children = append(p.children, children)
+	p.pending = append(p.pending, 0)
+	p.dependents = append(p.dependents, nil)
+
+	for _, j := range children {
+		p.edge(j, i)
+	}
+
+	return i
+}
+
+// before makes all states in the subtree of to, except states shared with
+// the subtree of from, to be closed only after from.
+func (p *closePlan) before(from, to int) {
+	shared := p.subtree(from)
+
+	for j := range p.subtree(to) {
+		if _, ok := shared[j]; !ok {
+			p.edge(from, j)
+		}
+	}
+}
+
+// subtree returns indices of the i-th state and all its descendants.
+func (p *closePlan) subtree(i int) map[int]struct{} {
+	seen := make(map[int]struct{})
+
+	var visit func(i int)
+	visit = func(i int) {
+		if _, ok := seen[i]; ok {
+			return
+		}
+
+		seen[i] = struct{}{}
+
+		for _, c := range p.children[i] {
+			visit(c)
+		}
+	}
+
+	visit(i)
+
+	return seen
+}
+
+// edge makes state to be closed only after state from.
+func (p *closePlan) edge(from, to int) {
+	p.dependents[from] = append(p.dependents[from], to)
+	p.pending[to]++
+}
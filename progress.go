@@ -0,0 +1,130 @@
+package state
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// logger is the package-wide logger.
+var logger struct {
+	l *slog.Logger
+
+	sync.RWMutex
+}
+
+// SetLogger sets the package-wide logger used for reporting shutdown
+// progress. Nil l resets it to slog's default logger.
+func SetLogger(l *slog.Logger) {
+	logger.Lock()
+	defer logger.Unlock()
+
+	logger.l = l
+}
+
+func getLogger() *slog.Logger {
+	logger.RLock()
+	defer logger.RUnlock()
+
+	if logger.l == nil {
+		return slog.Default()
+	}
+
+	return logger.l
+}
+
+type progressState struct {
+	*group
+
+	interval time.Duration
+}
+
+// WithShutdownProgress returns new State with merged children, which
+// Shutdown call logs progress every interval until the shutdown is
+// complete: the time remaining until the shutdown's deadline, if there
+// is one, and the number of shutdown states still pending, as reported
+// by Pending. The progress is logged with the logger set by SetLogger.
+//
+// It reassures operators that a long shutdown is progressing rather
+// than hung.
+func WithShutdownProgress(interval time.Duration, children ...State) State {
+	return withShutdownProgress(interval, children...)
+}
+
+func withShutdownProgress(interval time.Duration, children ...State) *progressState {
+	if interval <= 0 {
+		panic("non-positive shutdown progress interval")
+	}
+
+	return &progressState{
+		group:    merge(children...),
+		interval: interval,
+	}
+}
+
+func (p *progressState) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, p)
+}
+
+func (p *progressState) DependsOn(children ...State) State {
+	return withDependency(p, children...)
+}
+
+func (p *progressState) self() State {
+	return p
+}
+
+func (p *progressState) kind() string {
+	return "progress"
+}
+
+// startProgress starts logging shutdown progress of st if it was created
+// by WithShutdownProgress and returns a function stopping it.
+func startProgress(ctx context.Context, st State) (stop func()) {
+	p, ok := st.self().(*progressState)
+	if !ok {
+		return func() {}
+	}
+
+	var (
+		ticker      = time.NewTicker(p.interval)
+		done        = make(chan struct{})
+		deadline, _ = ctx.Deadline()
+	)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			attrs := []interface{}{slog.Int("pending", Pending(p))}
+
+			if !deadline.IsZero() {
+				remaining := time.Until(deadline).Round(time.Second)
+				attrs = append(attrs, slog.Duration("remaining", remaining))
+			}
+
+			getLogger().Info("shutting down", attrs...)
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// Pending returns the number of shutdown states in st that are not
+// shut down yet.
+func Pending(st State) (n int) {
+	for _, l := range leaves(st) {
+		if !isClosed(l.finishSig()) {
+			n++
+		}
+	}
+
+	return n
+}
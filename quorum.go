@@ -0,0 +1,109 @@
+package state
+
+import (
+	"sync"
+)
+
+type quorumState struct {
+	*group
+
+	quorum   int
+	readyOut chan struct{}
+
+	sync.Mutex
+}
+
+// MergeQuorumReady returns new State with merged children, which is ready
+// once k of its children are ready.
+//
+// MergeQuorumReady panics if k is negative or greater than the number
+// of non-nil states.
+func MergeQuorumReady(k int, states ...State) State {
+	return mergeQuorumReady(k, states...)
+}
+
+func mergeQuorumReady(k int, states ...State) *quorumState {
+	g := merge(states...)
+
+	if k < 0 {
+		panic("negative readiness quorum")
+	}
+
+	if k > len(g.states) {
+		panic("readiness quorum exceeds number of states")
+	}
+
+	return &quorumState{
+		group:  g,
+		quorum: k,
+	}
+}
+
+// Ready returns a channel that's closed when k of state's children
+// are ready.
+func (q *quorumState) Ready() <-chan struct{} {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.readyOut != nil {
+		// To avoid memory leaks - readyOut channel is created only once
+		q.resolveQuorum()
+		return q.readyOut
+	}
+
+	q.readyOut = make(chan struct{})
+
+	// buffered, so goroutines of children that are ready after the quorum
+	// is reached do not block
+	fired := make(chan struct{}, len(q.states))
+
+	for _, s := range q.states {
+		go func(s State) {
+			<-s.Ready()
+			fired <- struct{}{}
+		}(s)
+	}
+
+	go func() {
+		for i := 0; i < q.quorum; i++ {
+			<-fired
+		}
+
+		q.Lock()
+		closeReady(q.readyOut)
+		q.Unlock()
+	}()
+
+	// fast path - readiness is resolved synchronously if possible
+	q.resolveQuorum()
+
+	return q.readyOut
+}
+
+// resolveQuorum closes readyOut if k of state's children are ready
+// without blocking. It must be called under the lock.
+func (q *quorumState) resolveQuorum() {
+	ready := 0
+
+	for _, s := range q.states {
+		if isClosed(s.Ready()) {
+			ready++
+		}
+	}
+
+	if ready >= q.quorum {
+		closeReady(q.readyOut)
+	}
+}
+
+func (q *quorumState) DependsOn(children ...State) State {
+	return withDependency(q, children...)
+}
+
+func (q *quorumState) self() State {
+	return q
+}
+
+func (q *quorumState) kind() string {
+	return "quorum"
+}
@@ -1,6 +1,7 @@
 package state
 
 import (
+	"errors"
 	"sync"
 )
 
@@ -9,6 +10,7 @@ type readinessState struct {
 
 	ready    chan struct{}
 	readyOut chan struct{}
+	err      error
 
 	sync.Mutex
 }
@@ -54,18 +56,24 @@ func (r *readinessState) Ready() <-chan struct{} {
 	r.Lock()
 	defer r.Unlock()
 
-	if r.readyOut != nil {
+	if r.readyOut == nil {
 		// To avoid memory leaks - readyOut channel is created only once
-		return r.readyOut
-	}
+		r.readyOut = make(chan struct{})
 
-	r.readyOut = make(chan struct{})
+		go func() {
+			<-r.group.Ready()
+			<-r.ready
 
-	go func() {
-		<-r.group.Ready()
-		<-r.ready
-		close(r.readyOut)
-	}()
+			r.Lock()
+			closeReady(r.readyOut)
+			r.Unlock()
+		}()
+	}
+
+	// fast path - readiness is resolved synchronously if possible
+	if isClosed(r.ready) && isClosed(r.group.Ready()) {
+		closeReady(r.readyOut)
+	}
 
 	return r.readyOut
 }
@@ -73,3 +81,128 @@ func (r *readinessState) Ready() <-chan struct{} {
 func (r *readinessState) DependsOn(children ...State) State {
 	return withDependency(r, children...)
 }
+
+func (r *readinessState) self() State {
+	return r
+}
+
+func (r *readinessState) kind() string {
+	return "readiness"
+}
+
+// abortReady resolves the state's readiness as failed with
+// ErrReadinessAborted if it is not ready yet.
+func (r *readinessState) abortReady() {
+	r.Lock()
+	defer r.Unlock()
+
+	select {
+	case <-r.ready:
+		// Already ready
+	default:
+		r.err = ErrReadinessAborted
+		close(r.ready)
+	}
+}
+
+func (r *readinessState) readyErr() error {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.err
+}
+
+// ErrReadinessAborted is the error returned by ReadyErr for readiness
+// states aborted by AbortReadiness.
+var ErrReadinessAborted = errors.New("readiness aborted")
+
+// AbortReadiness resolves all readiness states in st that are not ready
+// yet as failed, unblocking everyone waiting on Ready channels in the tree.
+// Use it to avoid leaking goroutines blocked on Ready when the application
+// gives up on starting.
+//
+// The failure is reported by ReadyErr.
+func AbortReadiness(st State) {
+	walk(st, func(st State, _ []string) bool {
+		if r, ok := st.(interface{ abortReady() }); ok {
+			r.abortReady()
+		}
+
+		return true
+	})
+}
+
+// ReadyErr returns the first readiness failure found in st annotated
+// the same way as Err does, or nil if no readiness states in the tree
+// failed.
+//
+// Readiness failure resolves State's Ready channel the same way
+// successful readiness does, so ReadyErr should be checked after
+// the channel is closed.
+func ReadyErr(st State) (err error) {
+	walk(st, func(st State, path []string) bool {
+		if err != nil {
+			return false
+		}
+
+		if r, ok := st.(interface{ readyErr() error }); ok {
+			if e := r.readyErr(); e != nil {
+				err = annotate(path, e)
+			}
+		}
+
+		return err == nil
+	})
+
+	return err
+}
+
+// ReadinessErrors returns readiness failures of all states in st annotated
+// the same way as Err does, ordered from top to bottom and from left
+// to right. It lets a startup report tell which subsystems failed to become
+// ready rather than only the first one.
+// Returns nil if no readiness states in the tree failed.
+func ReadinessErrors(st State) (errs []error) {
+	seen := make(map[State]struct{})
+
+	walk(st, func(st State, path []string) bool {
+		r, ok := st.(interface{ readyErr() error })
+		if !ok {
+			return true
+		}
+
+		if _, ok := seen[st]; ok {
+			return true
+		}
+
+		seen[st] = struct{}{}
+
+		if err := r.readyErr(); err != nil {
+			errs = append(errs, annotate(path, err))
+		}
+
+		return true
+	})
+
+	return errs
+}
+
+// ReadyChan returns a channel that receives readiness outcome of st once
+// all states in the tree are ready or failed: nil on success or the error
+// ReadyErr reports on failure. The channel is closed after the outcome is
+// sent.
+//
+// ReadyChan lets a single select handle both outcomes. As with Ready,
+// if some readiness state in the tree never resolves, the channel blocks
+// forever.
+func ReadyChan(st State) <-chan error {
+	c := make(chan error, 1)
+
+	go func() {
+		<-st.Ready()
+		c <- ReadyErr(st)
+		close(c)
+	}()
+
+	return c
+}
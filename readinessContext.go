@@ -0,0 +1,74 @@
+package state
+
+import "context"
+
+type readinessContextState struct {
+	*readinessState
+
+	stop     func() bool
+	finished chan struct{}
+}
+
+// WithReadinessContext returns new State with merged children that becomes
+// ready when ctx is done, as if ReadinessTail's Ok was called then.
+//
+// It suits jobs that already derive a "bootstrap complete" context.
+// The readiness is registered with context.AfterFunc, so no goroutine is
+// parked waiting for ctx. If the state is shut down before ctx is done,
+// the registration is stopped.
+func WithReadinessContext(ctx context.Context, children ...State) State {
+	return withReadinessContext(ctx, children...)
+}
+
+func withReadinessContext(ctx context.Context, children ...State) *readinessContextState {
+	r := &readinessContextState{
+		readinessState: withReadiness(children...),
+		finished:       make(chan struct{}),
+	}
+
+	r.stop = context.AfterFunc(ctx, r.Ok)
+
+	return r
+}
+
+func (r *readinessContextState) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, r)
+}
+
+func (r *readinessContextState) close() {
+	r.stop()
+
+	go r.readinessState.close()
+	<-r.readinessState.finishSig()
+
+	r.Lock()
+	defer r.Unlock()
+
+	select {
+	case <-r.finished:
+		// Already closed
+	default:
+		close(r.finished)
+	}
+}
+
+func (r *readinessContextState) finishSig() <-chan struct{} {
+	return r.finished
+}
+
+func (r *readinessContextState) cause() error {
+	select {
+	case <-r.finished:
+		return nil
+	default:
+		return r.readinessState.cause()
+	}
+}
+
+func (r *readinessContextState) DependsOn(children ...State) State {
+	return withDependency(r, children...)
+}
+
+func (r *readinessContextState) self() State {
+	return r
+}
@@ -0,0 +1,59 @@
+package state
+
+import (
+	"context"
+	"time"
+)
+
+// reasoner is implemented by states that store the reason and
+// the deadline of their shutdown.
+type reasoner interface {
+	setReason(reason string)
+	reason() string
+
+	// setDeadline records the deadline of the shutdown and reports
+	// whether it was not recorded before.
+	setDeadline(deadline time.Time) bool
+	deadline() time.Time
+}
+
+// recordDeadline records deadline in all states of st, skipping subtrees
+// that already have it recorded.
+func recordDeadline(st State, deadline time.Time) {
+	walk(st, func(st State, _ []string) bool {
+		if r, ok := st.(reasoner); ok {
+			return r.setDeadline(deadline)
+		}
+
+		return true
+	})
+}
+
+// ShutdownWithReason records reason in all states of st and then shuts st
+// down the same way State's Shutdown does.
+//
+// The reason describes what triggered the shutdown, for example a signal,
+// a fatal error or a deadline, and can be retrieved with ShutdownReason,
+// for example by background jobs upon End. Only the first recorded reason
+// is kept.
+func ShutdownWithReason(ctx context.Context, st State, reason string) error {
+	walk(st, func(st State, _ []string) bool {
+		if r, ok := st.(reasoner); ok {
+			r.setReason(reason)
+		}
+
+		return true
+	})
+
+	return st.Shutdown(ctx)
+}
+
+// ShutdownReason returns the reason recorded in st by ShutdownWithReason.
+// Returns empty string if no reason was recorded.
+func ShutdownReason(st State) string {
+	if r, ok := st.self().(reasoner); ok {
+		return r.reason()
+	}
+
+	return ""
+}
@@ -0,0 +1,39 @@
+package state
+
+// Reduce folds states into a single State from left to right: fn is
+// called with the accumulated state and the next one, and its result
+// becomes the accumulated state for the next call.
+//
+// It is useful to compose a list of states built at runtime with custom
+// rules. Nil states are skipped. Returns Empty() if there are no states.
+func Reduce(states []State, fn func(acc, next State) State) State {
+	var acc State
+
+	for _, st := range states {
+		switch {
+		case st == nil:
+			continue
+		case acc == nil:
+			acc = st
+		default:
+			acc = fn(acc, st)
+		}
+	}
+
+	if acc == nil {
+		return Empty()
+	}
+
+	return acc
+}
+
+// ChainDepends returns new State with each state depending on the next
+// one, so states are shut down in reverse order: the last state is shut
+// down first, the first state is shut down last.
+//
+//	ChainDepends(st1, st2, st3) // same as st1.DependsOn(st2).DependsOn(st3)
+func ChainDepends(states ...State) State {
+	return Reduce(states, func(acc, next State) State {
+		return acc.DependsOn(next)
+	})
+}
@@ -0,0 +1,95 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// roots is the registry of root states.
+var roots struct {
+	m    map[uint64]State
+	next uint64
+
+	sync.Mutex
+}
+
+// Register registers st as a root state in the package-wide registry,
+// which lets subsystems initialized by many packages be shut down with
+// ShutdownRegistered without a central wiring function. The returned
+// function deregisters st; successive calls do nothing.
+//
+// The same state may be registered several times, each registration
+// is independent.
+func Register(st State) (deregister func()) {
+	if st == nil {
+		panic("nil registered state")
+	}
+
+	roots.Lock()
+	defer roots.Unlock()
+
+	if roots.m == nil {
+		roots.m = make(map[uint64]State)
+	}
+
+	id := roots.next
+	roots.next++
+	roots.m[id] = st
+
+	return func() {
+		roots.Lock()
+		defer roots.Unlock()
+
+		delete(roots.m, id)
+	}
+}
+
+// ShutdownRegistered gracefully shuts down all states registered with
+// Register concurrently with the shared ctx and returns errors of their
+// Shutdown calls joined with errors.Join. Each state is deregistered once
+// its shutdown completes successfully, so states which failed to shut down
+// stay registered for a retry. Returns nil if all states are shut
+// down successfully.
+//
+// The order of shutdowns across registered states is unspecified - states
+// that depend on each other must be composed with DependsOn instead.
+func ShutdownRegistered(ctx context.Context) error {
+	roots.Lock()
+	registered := make(map[uint64]State, len(roots.m))
+
+	for id, st := range roots.m {
+		registered[id] = st
+	}
+	roots.Unlock()
+
+	var (
+		errs []error
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+	)
+
+	for id, st := range registered {
+		wg.Add(1)
+
+		go func(id uint64, st State) {
+			defer wg.Done()
+
+			if err := st.Shutdown(ctx); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+
+				return
+			}
+
+			roots.Lock()
+			delete(roots.m, id)
+			roots.Unlock()
+		}(id, st)
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,23 @@
+package state
+
+import "context"
+
+// ShutdownReport gracefully shuts down st the same way State's Shutdown
+// does and returns the shutdown error along with paths of annotations of
+// shutdown states that did not finish, ordered the same way states are
+// shut down. Paths are joined with ": "; unannotated states have empty
+// paths.
+func ShutdownReport(ctx context.Context, st State) (unfinished []string, err error) {
+	err = st.Shutdown(ctx)
+	if err == nil {
+		return nil, nil
+	}
+
+	for _, l := range leaves(st) {
+		if !isClosed(l.finishSig()) {
+			unfinished = append(unfinished, l.path)
+		}
+	}
+
+	return unfinished, err
+}
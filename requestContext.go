@@ -0,0 +1,30 @@
+package state
+
+import "context"
+
+// requestContextKey is the key for the context stored by WithRequestContext.
+type requestContextKey struct{}
+
+// WithRequestContext returns new State with merged children and ctx
+// assigned to it.
+//
+// It is a typed WithValue for sharing a base context.Context between
+// long-lived background jobs through the tree, for example a context
+// that is canceled when the application gives up. It is not meant for
+// request-scoped contexts - those should be passed explicitly as
+// function arguments, as usual.
+func WithRequestContext(ctx context.Context, children ...State) State {
+	if ctx == nil {
+		panic("nil request context")
+	}
+
+	return withValue(requestContextKey{}, ctx, children...)
+}
+
+// RequestContext returns the context stored in st by WithRequestContext.
+// If there are multiple contexts in the tree, only the topmost and
+// the leftmost will be returned.
+func RequestContext(st State) (ctx context.Context, ok bool) {
+	ctx, ok = st.Value(requestContextKey{}).(context.Context)
+	return
+}
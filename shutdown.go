@@ -3,6 +3,8 @@ package state
 import (
 	"context"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type shutdownState struct {
@@ -11,6 +13,20 @@ type shutdownState struct {
 	end  chan struct{}
 	done chan struct{}
 
+	// detached state is not serviced by a background job
+	detached bool
+
+	// error reported by DoneErr
+	err error
+
+	endReason chan ShutdownSignal
+
+	// times the End signal was sent and Done was called
+	endAt, doneAt time.Time
+
+	// failed is set by ShutdownFailFast, nil otherwise
+	failed *atomic.Bool
+
 	sync.Mutex
 }
 
@@ -23,33 +39,137 @@ type ShutdownTail interface {
 	// Successive calls to End return the same value.
 	End() <-chan struct{}
 
+	// EndReason returns a channel that receives a ShutdownSignal
+	// describing why the shutdown was requested when End is closed, and
+	// is closed afterwards. Successive calls to EndReason return the same
+	// value.
+	EndReason() <-chan ShutdownSignal
+
 	// Done sends a signal that a shutdown is complete.
 	// Not calling Done will block all parents closing and cause
 	// the State's Shutdown call to return ErrTimeout or block forever.
 	// After the first call, subsequent calls do nothing.
 	Done()
+
+	// DoneErr sends a signal that a shutdown is complete the same way
+	// Done does, reporting that the job's cleanup failed with err.
+	// The error is reported by ShutdownFailFast.
+	DoneErr(err error)
+
+	// Detached marks that no background job will service the tail,
+	// for example when a constructor returns early on error before
+	// starting it. The state is then considered shut down right after
+	// its children are shut down, as if Done was called upon End.
+	Detached()
+}
+
+// ShutdownSignal describes a shutdown request.
+type ShutdownSignal struct {
+	// Reason is the reason recorded by ShutdownWithReason, if any.
+	Reason string
+
+	// Deadline is the deadline of the shutdown's context, if any.
+	Deadline time.Time
 }
 
 func (s *shutdownState) End() (c <-chan struct{}) {
 	return s.end
 }
 
-func (s *shutdownState) Done() {
+func (s *shutdownState) EndReason() <-chan ShutdownSignal {
 	s.Lock()
 	defer s.Unlock()
 
+	if s.endReason != nil {
+		return s.endReason
+	}
+
+	s.endReason = make(chan ShutdownSignal, 1)
+
+	go func(c chan ShutdownSignal) {
+		<-s.end
+		c <- ShutdownSignal{Reason: s.reason(), Deadline: s.deadline()}
+		close(c)
+	}(s.endReason)
+
+	return s.endReason
+}
+
+func (s *shutdownState) Detached() {
+	s.Lock()
+	s.detached = true
+	ended := isClosed(s.end)
+	s.Unlock()
+
+	if ended {
+		s.Done()
+	}
+}
+
+func (s *shutdownState) Done() {
+	s.DoneErr(nil)
+}
+
+func (s *shutdownState) DoneErr(err error) {
+	s.Lock()
+
 	select {
 	case <-s.done:
-		// Already closed
+		s.Unlock()
+		return // Already closed
 	default:
+		s.err = err
+		s.doneAt = time.Now()
+
+		// the flag is set before done is closed, so parents waiting
+		// for the state do not send End to themselves
+		if err != nil && s.failed != nil {
+			s.failed.Store(true)
+		}
+
 		close(s.done)
 	}
+	s.Unlock()
+
+	closeHook(s, ShutdownClosed)
+}
+
+// duration returns the time between the End signal and the Done call,
+// or false if the state is not shut down yet.
+func (s *shutdownState) duration() (time.Duration, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.endAt.IsZero() || s.doneAt.IsZero() {
+		return 0, false
+	}
+
+	return s.doneAt.Sub(s.endAt), true
+}
+
+// setFailFast sets the flag shared by states shut down
+// by ShutdownFailFast.
+func (s *shutdownState) setFailFast(failed *atomic.Bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.failed = failed
+}
+
+// doneErr returns the error reported by DoneErr.
+func (s *shutdownState) doneErr() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.err
 }
 
 // closer is used for graceful shutdown.
 type closer interface {
 	// close sends close signal to the state and blocks until the closing
 	// is complete.
+	// Implementations must not hold locks while waiting for children, so
+	// that Shutdown can be called re-entrantly from tail goroutines.
 	close()
 
 	// finishSig returns a channel that's closed when the closing
@@ -66,6 +186,15 @@ type closer interface {
 // shutdown is a function for shutting down states that implements
 // closer interface
 func shutdown(ctx context.Context, c closer) error {
+	if st, ok := c.(State); ok {
+		if deadline, ok := ctx.Deadline(); ok {
+			recordDeadline(st, deadline)
+		}
+
+		defer startWatchdog(st)()
+		defer startProgress(ctx, st)()
+	}
+
 	go c.close()
 
 	select {
@@ -111,14 +240,28 @@ func (s *shutdownState) close() {
 	<-s.group.finishSig()
 
 	s.Lock()
-	defer s.Unlock()
+
+	if s.failed != nil && s.failed.Load() {
+		s.Unlock()
+		return // ShutdownFailFast short-circuits remaining closes
+	}
 
 	select {
 	case <-s.end:
+		s.Unlock()
 		return // Already closed
 	default:
+		s.endAt = time.Now()
 		close(s.end)
 	}
+	detached := s.detached
+	s.Unlock()
+
+	closeHook(s, ShutdownClosing)
+
+	if detached {
+		s.Done()
+	}
 }
 
 func (s *shutdownState) finishSig() <-chan struct{} {
@@ -129,6 +272,14 @@ func (s *shutdownState) DependsOn(children ...State) State {
 	return withDependency(s, children...)
 }
 
+func (s *shutdownState) self() State {
+	return s
+}
+
+func (s *shutdownState) kind() string {
+	return "shutdown"
+}
+
 func (s *shutdownState) cause() error {
 	if err := s.group.cause(); err != nil {
 		return err
@@ -138,6 +289,6 @@ func (s *shutdownState) cause() error {
 	case <-s.done:
 		return nil
 	default:
-		return ErrTimeout
+		return newTimeoutError(ErrTimeout)
 	}
 }
@@ -0,0 +1,38 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ShutdownAll gracefully shuts down independent states concurrently with
+// the shared ctx and returns errors of their Shutdown calls joined with
+// errors.Join. Returns nil if all states are shut down successfully.
+//
+// Unlike shutting down a state returned by Merge, the states are not
+// merged into a tree - ShutdownAll is a one-shot utility for shutting
+// down several top-level states at once.
+func ShutdownAll(ctx context.Context, states ...State) error {
+	var (
+		errs = make([]error, len(states))
+		wg   sync.WaitGroup
+	)
+
+	for i, st := range states {
+		if st == nil {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(i int, st State) {
+			defer wg.Done()
+			errs[i] = st.Shutdown(ctx)
+		}(i, st)
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,15 @@
+package state
+
+import (
+	"context"
+	"errors"
+)
+
+// ShutdownAndErr gracefully shuts down st the same way State's Shutdown
+// does and returns the shutdown error joined with st's Err using
+// errors.Join, so both the shutdown outcome and errors of background jobs
+// are checked in one call. Returns nil if the shutdown is complete and
+// there are no errors in st.
+func ShutdownAndErr(ctx context.Context, st State) error {
+	return errors.Join(st.Shutdown(ctx), st.Err())
+}
@@ -0,0 +1,20 @@
+package state
+
+import "context"
+
+// ShutdownAsync initiates a graceful shutdown of st the same way State's
+// Shutdown does and returns a channel that receives the result of
+// the shutdown exactly once and is closed afterwards.
+//
+// It lets callers start a shutdown and wait for it later or alongside
+// other channels in a select.
+func ShutdownAsync(ctx context.Context, st State) <-chan error {
+	c := make(chan error, 1)
+
+	go func() {
+		c <- st.Shutdown(ctx)
+		close(c)
+	}()
+
+	return c
+}
@@ -0,0 +1,47 @@
+package state
+
+import (
+	"context"
+)
+
+// ShutdownWithCallback gracefully shuts down st the same way State's
+// Shutdown does, calling onDone for every shutdown state in the tree
+// as soon as its tail's Done is called. The path passed to onDone holds
+// annotations of the state joined with ": ".
+//
+// onDone is called in completion order and never concurrently. States that
+// didn't finish before ctx expired are reported with ErrTimeout after the
+// shutdown is over. All onDone calls are made before ShutdownWithCallback
+// returns.
+func ShutdownWithCallback(ctx context.Context, st State, onDone func(path string, err error)) error {
+	var (
+		ll    = leaves(st)
+		stop  = make(chan struct{})
+		over  = make(chan struct{})
+		done  = make(map[State]struct{}, len(ll))
+		watch = newPhaseWatcher(ll, func(l leaf, p ShutdownPhase) {
+			if p == ShutdownClosed {
+				done[l.State] = struct{}{}
+				onDone(l.path, nil)
+			}
+		})
+	)
+
+	go func() {
+		watch.watch(stop)
+		close(over)
+	}()
+
+	err := st.Shutdown(ctx)
+
+	close(stop)
+	<-over
+
+	for _, l := range ll {
+		if _, ok := done[l.State]; !ok {
+			onDone(l.path, ErrTimeout)
+		}
+	}
+
+	return err
+}
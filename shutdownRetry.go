@@ -0,0 +1,36 @@
+package state
+
+import (
+	"context"
+	"time"
+)
+
+// ShutdownRetry gracefully shuts down st the same way State's Shutdown
+// does, retrying the whole shutdown up to attempts times with a fresh
+// perAttempt timeout each, which helps to ride out transient issues of
+// infrastructure. States shut down by previous attempts are considered
+// finished, so each attempt continues with the remaining states.
+//
+// ShutdownRetry stops on the first successful attempt or when ctx is done,
+// and returns the error of the last attempt if all of them fail.
+//
+// ShutdownRetry panics if attempts is less than 1.
+func ShutdownRetry(ctx context.Context, st State, attempts int, perAttempt time.Duration) error {
+	if attempts < 1 {
+		panic("non-positive shutdown attempts")
+	}
+
+	var err error
+
+	for i := 0; i < attempts; i++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, perAttempt)
+		err = st.Shutdown(attemptCtx)
+		cancel()
+
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,82 @@
+package state
+
+import (
+	"context"
+)
+
+// ShutdownPhase is a phase of a shutdown state's shutdown.
+type ShutdownPhase int
+
+const (
+	// ShutdownClosing means the state's tail received the End signal.
+	ShutdownClosing ShutdownPhase = iota
+
+	// ShutdownClosed means the state's tail called Done.
+	ShutdownClosed
+)
+
+func (p ShutdownPhase) String() string {
+	switch p {
+	case ShutdownClosing:
+		return "closing"
+	case ShutdownClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// ShutdownEvent reports a shutdown state reaching a shutdown phase.
+type ShutdownEvent struct {
+	// Path holds annotations of the state joined with ": ".
+	Path string
+
+	Phase ShutdownPhase
+
+	// Reason is the reason of the shutdown recorded by ShutdownWithReason,
+	// if any.
+	Reason string
+}
+
+// ShutdownStream starts a graceful shutdown of st the same way State's
+// Shutdown does and streams its progress: every shutdown state in the tree
+// sends an event when it starts closing and when it is closed. A state's
+// closing event is never sent before the closed events of states it
+// depends on.
+//
+// The events channel is closed when the shutdown is over, after that the
+// error channel receives the shutdown's result and is closed too. Events are
+// buffered, so a slow reader does not slow down the shutdown.
+func ShutdownStream(ctx context.Context, st State) (<-chan ShutdownEvent, <-chan error) {
+	var (
+		ll     = leaves(st)
+		events = make(chan ShutdownEvent, 2*len(ll))
+		errc   = make(chan error, 1)
+		watch  = newPhaseWatcher(ll, func(l leaf, p ShutdownPhase) {
+			events <- ShutdownEvent{Path: l.path, Phase: p, Reason: ShutdownReason(l)}
+		})
+	)
+
+	go func() {
+		var (
+			stop = make(chan struct{})
+			over = make(chan struct{})
+		)
+
+		go func() {
+			watch.watch(stop)
+			close(over)
+		}()
+
+		err := st.Shutdown(ctx)
+
+		close(stop)
+		<-over
+		close(events)
+
+		errc <- err
+		close(errc)
+	}()
+
+	return events, errc
+}
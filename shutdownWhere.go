@@ -0,0 +1,158 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrDependencyViolation is the error returned by ShutdownWhere when
+// a selected state depends on shutdown states that are not selected and
+// are not shut down yet.
+var ErrDependencyViolation = errors.New("selected state depends on unselected state")
+
+// selection is a state selected for shutdown by ShutdownWhere.
+type selection struct {
+	State
+
+	path []string // annotations of the state's ancestors
+	deps []int    // selected states that must be shut down first
+}
+
+// ShutdownWhere gracefully shuts down only subtrees of st whose roots
+// satisfy pred, for example states with a given name, leaving the rest
+// of the tree running. Subtrees are shut down concurrently, except that
+// dependencies between selected subtrees are respected: a subtree is shut
+// down only after the selected subtrees it depends on are shut down.
+//
+// If a selected subtree depends on shutdown states that are not selected
+// and are not shut down yet, ShutdownWhere returns ErrDependencyViolation
+// annotated with the dependency's annotations without shutting down
+// anything.
+//
+// The returned error joins errors of subtrees' shutdowns with errors.Join,
+// annotated the same way as Shutdown does.
+func ShutdownWhere(ctx context.Context, st State, pred func(State) bool) error {
+	var (
+		selected []*selection
+		index    = make(map[State]int)
+	)
+
+	walk(st, func(st State, path []string) bool {
+		if _, ok := index[st]; ok {
+			return false
+		}
+
+		if !pred(st) {
+			return true
+		}
+
+		if _, ok := st.(annotator); ok {
+			path = path[:len(path)-1]
+		}
+
+		index[st] = len(selected)
+		selected = append(selected, &selection{State: st, path: path})
+
+		return false
+	})
+
+	var err error
+
+	walk(st, func(st State, path []string) bool {
+		if _, ok := index[st]; ok || err != nil {
+			return false
+		}
+
+		d, ok := st.(*dependState)
+		if !ok {
+			return true
+		}
+
+		dependents := selectedIn(d.parent, index)
+		if len(dependents) == 0 {
+			return true
+		}
+
+		for _, child := range d.children.states {
+			if hasLiveUnselected(child, index) {
+				err = annotate(path, ErrDependencyViolation)
+				return false
+			}
+
+			for _, i := range selectedIn(child, index) {
+				for _, j := range dependents {
+					selected[j].deps = append(selected[j].deps, i)
+				}
+			}
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return err
+	}
+
+	var (
+		errs = make([]error, len(selected))
+		wg   sync.WaitGroup
+	)
+
+	for i, s := range selected {
+		wg.Add(1)
+
+		go func(i int, s *selection) {
+			defer wg.Done()
+
+			for _, j := range s.deps {
+				select {
+				case <-selected[j].finishSig():
+				case <-ctx.Done():
+					errs[i] = annotateShutdown(s.path, newTimeoutError(ErrTimeout))
+					return
+				}
+			}
+
+			if err := s.Shutdown(ctx); err != nil {
+				errs[i] = annotateShutdown(s.path, err)
+			}
+		}(i, s)
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// hasLiveUnselected reports whether st has shutdown states that are
+// not selected and are not shut down yet.
+func hasLiveUnselected(st State, index map[State]int) (live bool) {
+	walk(st, func(st State, _ []string) bool {
+		if _, ok := index[st]; ok || live {
+			return false
+		}
+
+		if _, ok := st.(ShutdownTail); ok && !isClosed(st.finishSig()) {
+			live = true
+		}
+
+		return !live
+	})
+
+	return live
+}
+
+// selectedIn returns indexes of selected states found in st.
+func selectedIn(st State, index map[State]int) (ii []int) {
+	walk(st, func(st State, _ []string) bool {
+		if i, ok := index[st]; ok {
+			ii = append(ii, i)
+			return false
+		}
+
+		return true
+	})
+
+	return ii
+}
@@ -0,0 +1,66 @@
+package state
+
+import "encoding/json"
+
+// Node is a snapshot of a state in the tree along with its children.
+type Node struct {
+	// Kind is the kind of the state, as reported by Census.
+	Kind string
+
+	// Annotation is the annotation of annotation states.
+	Annotation string
+
+	// Ready reports whether all readiness states in the state are ready.
+	Ready bool
+
+	// Finished reports whether the state is shut down.
+	Finished bool
+
+	// Err is the state's error as returned by Err.
+	Err error
+
+	// Children are snapshots of the state's children in traversal order.
+	Children []Node
+}
+
+// Snapshot returns a snapshot of st's tree: kinds, annotations, readiness,
+// shutdown and error status of every state, computed without blocking.
+// States shared by multiple parents are included under each of them.
+func Snapshot(st State) Node {
+	st = st.self()
+
+	n := Node{
+		Kind:     st.kind(),
+		Ready:    isClosed(st.Ready()),
+		Finished: isClosed(st.finishSig()),
+		Err:      st.Err(),
+	}
+
+	if a, ok := st.(annotator); ok {
+		n.Annotation = a.label()
+	}
+
+	for _, child := range st.childStates() {
+		n.Children = append(n.Children, Snapshot(child))
+	}
+
+	return n
+}
+
+// MarshalJSON encodes the node with the error's message in place of
+// the error.
+func (n Node) MarshalJSON() ([]byte, error) {
+	var msg string
+	if n.Err != nil {
+		msg = n.Err.Error()
+	}
+
+	return json.Marshal(struct {
+		Kind       string `json:"kind"`
+		Annotation string `json:"annotation,omitempty"`
+		Ready      bool   `json:"ready"`
+		Finished   bool   `json:"finished"`
+		Err        string `json:"err,omitempty"`
+		Children   []Node `json:"children,omitempty"`
+	}{n.Kind, n.Annotation, n.Ready, n.Finished, msg, n.Children})
+}
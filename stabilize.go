@@ -0,0 +1,129 @@
+package state
+
+import (
+	"sync"
+	"time"
+)
+
+type stabilizeState struct {
+	*group
+
+	d        time.Duration
+	readyOut chan struct{}
+
+	sync.Mutex
+}
+
+// WithReadinessStabilize returns new State with merged children, which
+// becomes ready only after children's readiness has held for d
+// continuously. If readiness of a state created with WithLiveReadiness
+// in the tree is lost within the window, the window starts over once
+// readiness is regained.
+//
+// It debounces readiness of flapping children, for example for load
+// balancers that penalize flapping backends. As usual, the state's Ready
+// channel is one-shot: it is closed the first time the readiness has
+// stabilized.
+func WithReadinessStabilize(d time.Duration, children ...State) State {
+	return withReadinessStabilize(d, children...)
+}
+
+func withReadinessStabilize(d time.Duration, children ...State) *stabilizeState {
+	return &stabilizeState{
+		group: merge(children...),
+		d:     d,
+	}
+}
+
+func (s *stabilizeState) Ready() <-chan struct{} {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.readyOut == nil {
+		// To avoid memory leaks - readyOut channel is created only once
+		s.readyOut = make(chan struct{})
+
+		go s.stabilize()
+	}
+
+	return s.readyOut
+}
+
+// stabilize closes readyOut once readiness of children has held for s.d.
+func (s *stabilizeState) stabilize() {
+	var live []*liveReadinessState
+
+	walk(s.group, func(st State, _ []string) bool {
+		if l, ok := st.(*liveReadinessState); ok {
+			live = append(live, l)
+		}
+
+		return true
+	})
+
+	for {
+		for _, child := range s.states {
+			<-child.Ready()
+		}
+
+		for _, l := range live {
+			<-l.Ready()
+		}
+
+		if s.hold(live) {
+			break
+		}
+	}
+
+	s.Lock()
+	closeReady(s.readyOut)
+	s.Unlock()
+}
+
+// hold reports whether readiness of live states was not lost for s.d.
+func (s *stabilizeState) hold(live []*liveReadinessState) bool {
+	var (
+		lost = make(chan struct{})
+		stop = make(chan struct{})
+		once sync.Once
+	)
+
+	defer close(stop)
+
+	for _, l := range live {
+		go func(sig <-chan struct{}) {
+			if waitSig(sig, stop) {
+				once.Do(func() { close(lost) })
+			}
+		}(l.lostSig())
+	}
+
+	timer := time.NewTimer(s.d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-lost:
+		return false
+	}
+
+	for _, l := range live {
+		if isClosed(l.lostSig()) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *stabilizeState) DependsOn(children ...State) State {
+	return withDependency(s, children...)
+}
+
+func (s *stabilizeState) self() State {
+	return s
+}
+
+func (s *stabilizeState) kind() string {
+	return "readiness"
+}
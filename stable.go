@@ -0,0 +1,41 @@
+package state
+
+import "sync"
+
+// stableErr caches the first error observed by ErrStable.
+type stableErr struct {
+	once sync.Once
+	err  error
+}
+
+// stable returns the cached error, caching err first if it is the first
+// non-nil error observed.
+func (s *stableErr) stable(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	s.once.Do(func() { s.err = err })
+
+	return s.err
+}
+
+// stabilizer is implemented by states that cache errors for ErrStable.
+type stabilizer interface {
+	stableErr() *stableErr
+}
+
+// ErrStable returns the first error observed in st by ErrStable, or nil
+// if no error occurred yet.
+//
+// Unlike State's Err, which may return different errors on successive
+// calls, once ErrStable returns an error it always returns that same error
+// for st, which keeps logging deterministic.
+func ErrStable(st State) error {
+	s, ok := st.self().(stabilizer)
+	if !ok {
+		return st.Err()
+	}
+
+	return s.stableErr().stable(st.Err())
+}
@@ -0,0 +1,131 @@
+package state
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type staggerState struct {
+	*group
+
+	delay, jitter time.Duration
+
+	closing  bool
+	finished chan struct{}
+
+	sync.Mutex
+}
+
+// MergeStaggered returns new State with merged children, which are shut
+// down one after another spaced by delay plus a random jitter in range
+// [-jitter, jitter], instead of all at once. It smooths spikes of resource
+// usage during mass shutdowns, for example of thousands of websocket
+// connections.
+//
+// Children are not required to finish before the next one is shut down.
+// Staggering stops once the next delay would exceed the deadline of the
+// shutdown's context, and the remaining children are shut down at once.
+//
+// MergeStaggered panics if delay or jitter is negative.
+func MergeStaggered(delay, jitter time.Duration, states ...State) State {
+	return mergeStaggered(delay, jitter, states...)
+}
+
+func mergeStaggered(delay, jitter time.Duration, states ...State) *staggerState {
+	if delay < 0 || jitter < 0 {
+		panic("negative shutdown stagger")
+	}
+
+	return &staggerState{
+		group:    merge(states...),
+		delay:    delay,
+		jitter:   jitter,
+		finished: make(chan struct{}),
+	}
+}
+
+func (s *staggerState) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, s)
+}
+
+func (s *staggerState) close() {
+	s.Lock()
+	closing := s.closing
+	s.closing = true
+	s.Unlock()
+
+	if closing {
+		<-s.finished
+		return
+	}
+
+	deadline := s.deadline()
+	stagger := true
+
+	for i, child := range s.states {
+		if i > 0 && stagger {
+			d := s.next()
+
+			if !deadline.IsZero() && time.Until(deadline) < d {
+				stagger = false
+			} else {
+				time.Sleep(d)
+			}
+		}
+
+		if !isClosed(child.finishSig()) {
+			go child.close()
+		}
+	}
+
+	for _, child := range s.states {
+		<-child.finishSig()
+	}
+
+	close(s.finished)
+}
+
+// next returns the delay before the next child is shut down.
+func (s *staggerState) next() time.Duration {
+	if s.jitter == 0 {
+		return s.delay
+	}
+
+	d := s.delay - s.jitter + time.Duration(rand.Int63n(int64(2*s.jitter)+1))
+	if d < 0 {
+		return 0
+	}
+
+	return d
+}
+
+func (s *staggerState) finishSig() <-chan struct{} {
+	return s.finished
+}
+
+func (s *staggerState) DependsOn(children ...State) State {
+	return withDependency(s, children...)
+}
+
+func (s *staggerState) self() State {
+	return s
+}
+
+func (s *staggerState) kind() string {
+	return "stagger"
+}
+
+func (s *staggerState) cause() error {
+	if err := s.group.cause(); err != nil {
+		return err
+	}
+
+	select {
+	case <-s.finished:
+		return nil
+	default:
+		return newTimeoutError(ErrTimeout)
+	}
+}
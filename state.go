@@ -66,8 +66,12 @@ type State interface {
 	// by annotation states in a chain. Annotation uses introduced in
 	// go 1.13 errors wrapping.
 	//
+	// If errors have priorities assigned by WithErrorPriority or
+	// WithErrorGroupPriority, the error with the highest priority is returned.
+	//
 	// Successive calls to Err may not return the same value, but it will
-	// never return nil after the first error occurred.
+	// never return nil after the first error occurred. Use ErrStable
+	// when the same error is needed on every call.
 	Err() error
 
 	// Wait blocks until all counters of WaitGroups in this state are zero.
@@ -81,7 +85,7 @@ type State interface {
 	//
 	// If ctx expires before the shutdown is complete, Shutdown tries
 	// to find the first full path of unclosed children to accumulate
-	// annotations and returns ErrTimeout wrapped in them.
+	// annotations and returns TimeoutError carrying them, which wraps ErrTimeout.
 	// There is a chance that the shutdown will complete during that check -
 	// in this case, it is considered as fully completed and returns nil.
 	Shutdown(ctx context.Context) error
@@ -128,6 +132,9 @@ type State interface {
 	// necessary to have it in exported interface for cases of embedding
 	// State into another struct.
 	closer
+
+	// node is a private interface used for traversing the tree of states.
+	node
 }
 
 var (
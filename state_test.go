@@ -3,7 +3,16 @@ package state
 import (
 	"context"
 	"errors"
+	"expvar"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -15,42 +24,146 @@ func TestParallel(t *testing.T) {
 		t.Run("GroupSuccessiveClose", GroupSuccessiveCloseTest)
 		t.Run("GroupError", GroupErrorTest)
 		t.Run("GroupNilChild", GroupNilChildTest)
+		t.Run("GroupFlat", GroupFlatTest)
+		t.Run("GroupBuilder", GroupBuilderTest)
+		t.Run("GroupCensus", GroupCensusTest)
+		t.Run("GroupLazy", GroupLazyTest)
+		t.Run("GroupWalk", GroupWalkTest)
+		t.Run("GroupPath", GroupPathTest)
+		t.Run("GroupSnapshot", GroupSnapshotTest)
+		t.Run("GroupEqual", GroupEqualTest)
+		t.Run("GroupChildren", GroupChildrenTest)
 
 		// Shutdown
 		t.Run("ShutdownWrap", ShutdownWrapTest)
 		t.Run("ShutdownSuccessiveDone", ShutdownSuccessiveDoneTest)
 		t.Run("ShutdownSuccessiveCall", ShutdownSuccessiveCallTest)
+		t.Run("ShutdownReentrant", ShutdownReentrantTest)
+		t.Run("ShutdownDetached", ShutdownDetachedTest)
+		t.Run("ShutdownHandle", ShutdownHandleTest)
+		t.Run("ShutdownTicker", ShutdownTickerTest)
+		t.Run("ShutdownTickerPanic", ShutdownTickerPanicTest)
+		t.Run("ShutdownAll", ShutdownAllTest)
+		t.Run("ShutdownAsync", ShutdownAsyncTest)
+		t.Run("ShutdownRegistered", ShutdownRegisteredTest)
+		t.Run("ShutdownMerged", ShutdownMergedTest)
+		t.Run("ShutdownPooled", ShutdownPooledTest)
+		t.Run("ShutdownReason", ShutdownReasonTest)
+		t.Run("ShutdownEndReason", ShutdownEndReasonTest)
+		t.Run("ShutdownPause", ShutdownPauseTest)
+		t.Run("ShutdownPauseNotUnpaused", ShutdownPauseNotUnpausedTest)
+		t.Run("ShutdownByWeight", ShutdownByWeightTest)
+		t.Run("ShutdownEvents", ShutdownEventsTest)
+		t.Run("ShutdownFailFast", ShutdownFailFastTest)
+		t.Run("ShutdownFailFastParent", ShutdownFailFastParentTest)
+		t.Run("ShutdownGraceful", ShutdownGracefulTest)
+		t.Run("ShutdownRetry", ShutdownRetryTest)
+		t.Run("ShutdownStandaloneTails", ShutdownStandaloneTailsTest)
+		t.Run("ShutdownStaggered", ShutdownStaggeredTest)
+		t.Run("ShutdownReport", ShutdownReportTest)
+		t.Run("ShutdownCloseTimeout", ShutdownCloseTimeoutTest)
+		t.Run("ShutdownAndErr", ShutdownAndErrTest)
+		t.Run("ShutdownWhere", ShutdownWhereTest)
+		t.Run("ShutdownWatchdog", ShutdownWatchdogTest)
+		t.Run("ShutdownProgress", ShutdownProgressTest)
+		t.Run("ShutdownDurations", ShutdownDurationsTest)
 		t.Run("ShutdownTimeout", ShutdownTimeoutTest)
 		t.Run("ShutdownUnclosed", ShutdownUnclosedTest)
+		t.Run("ShutdownBarrier", ShutdownBarrierTest)
+		t.Run("ShutdownBarrierNotReleased", ShutdownBarrierNotReleasedTest)
+		t.Run("ShutdownGuard", ShutdownGuardTest)
+		t.Run("ShutdownAfterFunc", ShutdownAfterFuncTest)
+		t.Run("ShutdownAfterFuncStop", ShutdownAfterFuncStopTest)
+		t.Run("ShutdownAfterFuncDone", ShutdownAfterFuncDoneTest)
+		t.Run("ShutdownFileWatch", ShutdownFileWatchTest)
+		t.Run("ShutdownCallback", ShutdownCallbackTest)
+		t.Run("ShutdownCloser", ShutdownCloserTest)
+		t.Run("ShutdownStream", ShutdownStreamTest)
+		t.Run("ShutdownHTTPServer", ShutdownHTTPServerTest)
+		t.Run("ShutdownHTTPServerInFlight", ShutdownHTTPServerInFlightTest)
 
 		// Wait
 		t.Run("Wait", WaitTest)
+		t.Run("WaitProgress", WaitProgressTest)
+		t.Run("WaitWithTimeout", WaitWithTimeoutTest)
+		t.Run("WaitCtx", WaitCtxTest)
+		t.Run("WaitFunc", WaitFuncTest)
+		t.Run("WaitAny", WaitAnyTest)
+		t.Run("WaitEachCtx", WaitEachCtxTest)
+		t.Run("WaitEachCtxOwn", WaitEachCtxOwnTest)
+		t.Run("WaitErrGroup", WaitErrGroupTest)
+		t.Run("WaitWorker", WaitWorkerTest)
+		t.Run("WaitWorkerPanic", WaitWorkerPanicTest)
+		t.Run("WaitDrain", WaitDrainTest)
+		t.Run("WaitDrainTimeout", WaitDrainTimeoutTest)
 
 		// Readiness
 		t.Run("ReadinessWrap", ReadinessWrapTest)
 		t.Run("ReadinessSuccessiveOk", ReadinessSuccessiveOkTest)
 		t.Run("ReadinessSuccessiveReady", ReadinessSuccessiveReadyTest)
+		t.Run("ReadinessQuorum", ReadinessQuorumTest)
+		t.Run("ReadinessQuorumPanic", ReadinessQuorumPanicTest)
+		t.Run("ReadinessFailFast", ReadinessFailFastTest)
+		t.Run("ReadinessAbort", ReadinessAbortTest)
+		t.Run("ReadinessAbortLive", ReadinessAbortLiveTest)
+		t.Run("ReadinessChan", ReadinessChanTest)
+		t.Run("ReadinessErrors", ReadinessErrorsTest)
+		t.Run("ReadinessContext", ReadinessContextTest)
+		t.Run("ReadinessLive", ReadinessLiveTest)
+		t.Run("ReadinessStabilize", ReadinessStabilizeTest)
+		t.Run("ReadinessStatus", ReadinessStatusTest)
+		t.Run("ReadinessStatusQuorum", ReadinessStatusQuorumTest)
 
 		// Value
 		t.Run("ValueWrap", ValueWrapTest)
 		t.Run("ValueChildren", ValueChildrenTest)
 		t.Run("ValueNilPanic", ValueNilPanicTest)
 		t.Run("ValueComparablePanic", ValueComparablePanicTest)
+		t.Run("ValueMutable", ValueMutableTest)
+		t.Run("ValueIndex", ValueIndexTest)
+		t.Run("ValueIndexNilShadow", ValueIndexNilShadowTest)
+		t.Run("ValueOr", ValueOrTest)
+		t.Run("ValueTyped", ValueTypedTest)
+		t.Run("ValueKeys", ValueKeysTest)
+		t.Run("ValueLocal", ValueLocalTest)
+		t.Run("ValueWait", ValueWaitTest)
+		t.Run("ValueKeyRegistry", ValueKeyRegistryTest)
 
 		// Annotation
 		t.Run("AnnotationError", AnnotationErrorTest)
 		t.Run("AnnotationShutdownTimeout", AnnotationShutdownTimeoutTest)
 		t.Run("AnnotationChildShutdownTimeout", AnnotationChildShutdownTimeoutTest)
+		t.Run("AnnotationTimeoutErrorPath", AnnotationTimeoutErrorPathTest)
 		t.Run("AnnotationNilError", AnnotationNilErrorTest)
 		t.Run("AnnotationNilShutdownError", AnnotationNilShutdownErrorTest)
 		t.Run("AnnotationUnclosed", AnnotationUnclosedTest)
+		t.Run("AnnotationDynamic", AnnotationDynamicTest)
+		t.Run("AnnotationFormat", AnnotationFormatTest)
 
 		// Error
 		t.Run("Error", ErrorTest)
+		t.Run("ErrorDeepest", ErrorDeepestTest)
+		t.Run("ErrorDeepestNil", ErrorDeepestNilTest)
+		t.Run("ErrorDeepestEmbedded", ErrorDeepestEmbeddedTest)
+		t.Run("ErrorSource", ErrorSourceTest)
+		t.Run("ErrorAll", ErrorAllTest)
+		t.Run("ErrorAllAnnotated", ErrorAllAnnotatedTest)
+		t.Run("ErrorPriority", ErrorPriorityTest)
+		t.Run("ErrStable", ErrStableTest)
+		t.Run("ErrorCollect", ErrorCollectTest)
 
 		// Error group
 		t.Run("ErrorGroup", ErrorGroupTest)
 		t.Run("ErrorGroupErrorf", ErrorGroupErrorfTest)
+		t.Run("ErrorGroupAttrs", ErrorGroupAttrsTest)
+		t.Run("ErrorGroupShared", ErrorGroupSharedTest)
+		t.Run("ErrorGroupMulti", ErrorGroupMultiTest)
+		t.Run("ErrorGroupOnError", ErrorGroupOnErrorTest)
+		t.Run("ErrorGroupSnapshot", ErrorGroupSnapshotTest)
+		t.Run("ErrorGroupSupervise", ErrorGroupSuperviseTest)
+
+		// Categorized errors
+		t.Run("Category", CategoryTest)
 
 		// Empty
 		t.Run("Empty", EmptyTest)
@@ -70,6 +183,23 @@ func TestParallel(t *testing.T) {
 		t.Run("DependencyValueParent", DependencyValueParentTest)
 		t.Run("DependencyValueChildren", DependencyValueChildrenTest)
 		t.Run("DependencyAnnotation", DependencyAnnotationTest)
+		t.Run("DependencyChain", DependencyChainTest)
+		t.Run("DependencyReduceEmpty", DependencyReduceEmptyTest)
+		t.Run("DependencyOrdered", DependencyOrderedTest)
+		t.Run("DependencyOrderedChildrenFinished", DependencyOrderedChildrenFinishedTest)
+		t.Run("DependencyData", DependencyDataTest)
+		t.Run("DependencyValueCached", DependencyValueCachedTest)
+		t.Run("DependencyGraph", DependencyGraphTest)
+		t.Run("DependencyShutdownOrder", DependencyShutdownOrderTest)
+
+		// Name
+		t.Run("NameWaitReady", NameWaitReadyTest)
+		t.Run("NameNotFound", NameNotFoundTest)
+		t.Run("NameShutdownBranch", NameShutdownBranchTest)
+
+		// Request context
+		t.Run("RequestContext", RequestContextTest)
+		t.Run("RequestContextMissing", RequestContextMissingTest)
 	})
 }
 
@@ -148,6 +278,91 @@ func closeChanAndPropagate(cc ...chan struct{}) {
 	time.Sleep(failTimeout)
 }
 
+// awaitPhases returns channels that are closed when st reaches closing
+// and closed shutdown phases.
+func awaitPhases(t *testing.T, st State) (closing, closed <-chan struct{}) {
+	var (
+		closingC = make(chan struct{})
+		closedC  = make(chan struct{})
+	)
+
+	setCloseHook(st, func(phase ShutdownPhase) {
+		switch phase {
+		case ShutdownClosing:
+			close(closingC)
+		case ShutdownClosed:
+			close(closedC)
+		}
+	})
+
+	t.Cleanup(func() { setCloseHook(st, nil) })
+
+	return closingC, closedC
+}
+
+// stepper serializes shutdown phases reached by states: a state reaching
+// a phase is blocked until the test takes the step with next, so the order
+// of phases is verified deterministically, without sleeping.
+type stepper struct {
+	t     *testing.T
+	steps chan step
+	names map[State]string
+}
+
+type step struct {
+	st    State
+	phase ShutdownPhase
+}
+
+// newStepper returns stepper driving shutdown of named states.
+func newStepper(t *testing.T, states map[string]State) *stepper {
+	s := &stepper{
+		t:     t,
+		steps: make(chan step),
+		names: make(map[State]string),
+	}
+
+	for name, st := range states {
+		st := st
+		s.names[st] = name
+
+		setCloseHook(st, func(phase ShutdownPhase) {
+			s.steps <- step{st: st, phase: phase}
+		})
+
+		t.Cleanup(func() { setCloseHook(st, nil) })
+	}
+
+	return s
+}
+
+// next takes the next step and fails the test if it is not st reaching
+// phase.
+func (s *stepper) next(st State, phase ShutdownPhase) {
+	s.t.Helper()
+
+	select {
+	case got := <-s.steps:
+		if got.st != st || got.phase != phase {
+			s.t.Errorf("wrong shutdown step, want %s reaching phase %d, have %s reaching phase %d",
+				s.names[st], phase, s.names[got.st], got.phase)
+		}
+	case <-time.After(failTimeout):
+		s.t.Fatalf("%s: %s didn't reach phase %d", errTimeout, s.names[st], phase)
+	}
+}
+
+// awaits c is closed or fails the test after failTimeout
+func await(t *testing.T, c <-chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-c:
+	case <-time.After(failTimeout):
+		t.Fatal(errTimeout)
+	}
+}
+
 // Group
 
 func GroupCloseTest(t *testing.T) {
@@ -163,8 +378,12 @@ func GroupCloseTest(t *testing.T) {
 		st3 = merge(st1, st2)
 	)
 
+	_, closed3 := awaitPhases(t, st3)
+
 	go st3.close()
-	closeChanAndPropagate(okDone1, okDone2)
+	close(okDone1)
+	close(okDone2)
+	await(t, closed3)
 
 	switch {
 	case hasNotClosed(st1.end, st2.end, st3.done):
@@ -236,6 +455,385 @@ func GroupNilChildTest(t *testing.T) {
 	}
 }
 
+func GroupFlatTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st3 = withAnnotation("a", withShutdown())
+		st4 = MergeFlat(MergeFlat(Merge(st1, st2), st3), nil)
+	)
+
+	g, ok := st4.(*group)
+	if !ok {
+		t.Fatalf("flat merge didn't return group")
+	}
+
+	if len(g.states) != 3 {
+		t.Fatalf("wrong number of group children: want 3, have %d", len(g.states))
+	}
+
+	if g.states[0] != st1 || g.states[1] != st2 || g.states[2] != st3 {
+		t.Errorf("wrong children of flat group")
+	}
+}
+
+func GroupBuilderTest(t *testing.T) {
+	t.Parallel()
+
+	const key1 key = "key1"
+
+	var (
+		err1 = errors.New("error")
+
+		st1 = withShutdown()
+		st2 = withShutdown()
+
+		okDone1 = runShutdownable(st1)
+		okDone2 = runShutdownable(st2)
+	)
+
+	st := new(Builder).
+		Add(WithError(err1)).
+		Annotate("storage").
+		Add(st1).
+		DependOn(st2).
+		Value(key1, "value1").
+		Build()
+
+	if v := st.Value(key1); v != "value1" {
+		t.Errorf("wrong value, want '%v', have '%v'", "value1", v)
+	}
+
+	if err := st.Err(); err == nil || err.Error() != "storage: error" {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	closing2, _ := awaitPhases(t, st2)
+
+	go func() { _ = st.Shutdown(context.Background()) }()
+
+	await(t, closing2)
+
+	if hasClosed(st1.end) {
+		t.Error(errClosed)
+	}
+
+	close(okDone1)
+	close(okDone2)
+
+	if _, ok := new(Builder).Build().(emptyState); !ok {
+		t.Errorf("empty builder didn't build empty state")
+	}
+}
+
+func GroupCensusTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1, _ = WithShutdown()
+		st2, _ = WithShutdown()
+		st3, _ = WithWait()
+	)
+
+	st := WithAnnotation("app",
+		st1.DependsOn(st2, st3),
+		WithValue(key("key1"), "value1", st2),
+		WithError(errors.New("error")),
+	)
+
+	want := map[string]int{
+		"annotation": 1,
+		"dependency": 1,
+		"shutdown":   2,
+		"wait":       1,
+		"value":      1,
+		"error":      1,
+	}
+
+	have := Census(st)
+
+	if len(have) != len(want) {
+		t.Errorf("wrong census, want %v, have %v", want, have)
+	}
+
+	for kind, n := range want {
+		if have[kind] != n {
+			t.Errorf("wrong number of %s states, want %d, have %d", kind, n, have[kind])
+		}
+	}
+}
+
+func GroupLazyTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1  = errors.New("error")
+		calls int
+
+		st1 = withShutdown()
+
+		okDone1 = runShutdownable(st1)
+	)
+
+	st := MergeLazy(func() []State {
+		calls++
+		return []State{st1, WithError(err1)}
+	})
+
+	if calls != 0 {
+		t.Errorf("provider is called before the state is used")
+	}
+
+	if err := st.Err(); !errors.Is(err, err1) {
+		t.Errorf("wrong error, want '%v', have '%v'", err1, err)
+	}
+
+	close(okDone1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := st.Shutdown(ctx); err != nil {
+		t.Errorf("shutdown error: %v", err)
+	}
+
+	if hasNotClosed(st1.done) {
+		t.Error(errNotFinished)
+	}
+
+	if calls != 1 {
+		t.Errorf("provider is called %d times", calls)
+	}
+}
+
+func GroupWalkTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withWait()
+		st3 = withShutdown()
+		st4 = withAnnotation("skipped", st3)
+		dep = withDependency(st1, st2)
+		st  = merge(dep, st4)
+	)
+
+	type visit struct {
+		node  State
+		depth int
+	}
+
+	var have []visit
+
+	Walk(embeddedState{st}, func(node State, depth int) bool {
+		have = append(have, visit{node, depth})
+		return node != st4
+	})
+
+	want := []visit{{st, 0}, {dep, 1}, {st1, 2}, {st2, 2}, {st4, 1}}
+
+	if len(have) != len(want) {
+		t.Fatalf("wrong number of visited states, want %d, have %d", len(want), len(have))
+	}
+
+	for i := range want {
+		if have[i] != want[i] {
+			t.Errorf("wrong visit %d, want %v, have %v", i, want[i], have[i])
+		}
+	}
+}
+
+func GroupPathTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st3 = withShutdown()
+		st  = WithAnnotation("app", merge(WithAnnotation("db", st1), st2))
+	)
+
+	path, ok := Path(st, st1)
+	if !ok || strings.Join(path, ": ") != "app: db" {
+		t.Errorf("wrong path, want 'app: db', have '%v'", path)
+	}
+
+	if path, ok := Path(st, st2); !ok || len(path) != 1 || path[0] != "app" {
+		t.Errorf("wrong path, want 'app', have '%v'", path)
+	}
+
+	if !Contains(st, embeddedState{st1}) || !Contains(st, st) {
+		t.Errorf("reachable state is not found")
+	}
+
+	if Contains(st, st3) {
+		t.Errorf("unreachable state is found")
+	}
+
+	if path, ok := Path(st, st3); ok || path != nil {
+		t.Errorf("unexpected path to unreachable state: %v", path)
+	}
+}
+
+func GroupSnapshotTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error 1")
+
+		st1, tail1 = WithReadiness()
+		st2        = withShutdown()
+		st         = WithAnnotation("service", st1, WithError(err1), st2)
+
+		okDone = runShutdownable(st2)
+	)
+
+	tail1.Ok()
+	close(okDone)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := st2.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+
+	n := Snapshot(st)
+
+	if n.Kind != "annotation" || n.Annotation != "service" || n.Finished {
+		t.Errorf("unexpected root node: %+v", n)
+	}
+
+	if !errors.Is(n.Err, err1) || len(n.Children) != 3 {
+		t.Fatalf("unexpected root node: %+v", n)
+	}
+
+	if c := n.Children[0]; c.Kind != "readiness" || !c.Ready || c.Err != nil {
+		t.Errorf("unexpected readiness node: %+v", c)
+	}
+
+	if c := n.Children[2]; c.Kind != "shutdown" || !c.Finished {
+		t.Errorf("unexpected shutdown node: %+v", c)
+	}
+
+	// expvar names are global, so the name is unique per run
+	name := fmt.Sprintf("state_test_snapshot_%p", st)
+	PublishExpvar(name, st)
+
+	v := expvar.Get(name)
+	if v == nil {
+		t.Fatal("snapshot is not published")
+	}
+
+	if s := v.String(); !strings.Contains(s, `"annotation":"service"`) || !strings.Contains(s, `"err":"service: error 1"`) {
+		t.Errorf("unexpected published snapshot: %s", s)
+	}
+}
+
+func GroupEqualTest(t *testing.T) {
+	t.Parallel()
+
+	wire := func(annotation string) State {
+		st1, _ := WithShutdown()
+		st2, tail2 := WithReadiness()
+		tail2.Ok()
+
+		return WithAnnotation(annotation, st1, WithName("db", st2)).DependsOn(WithError(nil))
+	}
+
+	if d := Diff(wire("service"), wire("service")); d != "" {
+		t.Errorf("unexpected difference of equal trees:\n%s", d)
+	}
+
+	if !Equal(Empty(), Empty()) {
+		t.Error("empty states are not equal")
+	}
+
+	if Equal(wire("service"), wire("other")) {
+		t.Error("trees with different annotations are equal")
+	}
+
+	if d := Diff(wire("service"), wire("other")); d != "/0: label \"service\" != \"other\"\n" {
+		t.Errorf("unexpected difference: %q", d)
+	}
+
+	a := Merge(WithError(nil), withShutdown())
+	b := Merge(withShutdown(), withShutdown(), WithValue(key("key1"), 1))
+
+	want := "/0: kind error != shutdown\n/2: value missing in a\n"
+	if d := Diff(a, b); d != want {
+		t.Errorf("expected difference %q, got %q", want, d)
+	}
+}
+
+func GroupChildrenTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st3 = withShutdown()
+		st  = st1.DependsOn(st2, st3)
+
+		okDone1 = runShutdownable(st1)
+		okDone2 = runShutdownable(st2)
+		okDone3 = runShutdownable(st3)
+
+		stop = make(chan struct{})
+		wg   sync.WaitGroup
+	)
+
+	children := Children(st)
+	if len(children) != 3 || children[0] != st1 || children[1] != st2 || children[2] != st3 {
+		t.Fatalf("unexpected children: %v", children)
+	}
+
+	// the copy is not shared with the state
+	children[0] = Empty()
+
+	if Children(st)[0] != st1 {
+		t.Error("children of the state are modified by the caller")
+	}
+
+	// children are read concurrently with shutdown
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for !isClosed(stop) {
+				if len(Children(st)) != 3 {
+					t.Error("unexpected number of children")
+					return
+				}
+
+				runtime.Gosched()
+			}
+		}()
+	}
+
+	close(okDone1)
+	close(okDone2)
+	close(okDone3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*failTimeout)
+	defer cancel()
+
+	if err := st.Shutdown(ctx); err != nil {
+		t.Errorf("unexpected shutdown error: %v", err)
+	}
+
+	close(stop)
+	wg.Wait()
+
+	if len(Children(withShutdown())) != 0 {
+		t.Error("unexpected children of empty state")
+	}
+}
+
 // Shutdown
 
 func ShutdownWrapTest(t *testing.T) {
@@ -258,8 +856,12 @@ func ShutdownWrapTest(t *testing.T) {
 		t.Error(errInitClosed)
 	}
 
+	closing1, _ := awaitPhases(t, st1)
+	closing2, _ := awaitPhases(t, st2)
+	closing3, closed3 := awaitPhases(t, st3)
+
 	go st3.close()
-	time.Sleep(failTimeout)
+	await(t, closing1)
 
 	switch {
 	case hasNotClosed(st1.end):
@@ -270,7 +872,8 @@ func ShutdownWrapTest(t *testing.T) {
 		t.Error(errFinished)
 	}
 
-	closeChanAndPropagate(okDone1)
+	close(okDone1)
+	await(t, closing2)
 
 	switch {
 	case hasNotClosed(st2.end):
@@ -283,7 +886,8 @@ func ShutdownWrapTest(t *testing.T) {
 		t.Error(errFinished)
 	}
 
-	closeChanAndPropagate(okDone2)
+	close(okDone2)
+	await(t, closing3)
 
 	switch {
 	case hasNotClosed(st2.done):
@@ -294,7 +898,8 @@ func ShutdownWrapTest(t *testing.T) {
 		t.Error(errFinished)
 	}
 
-	closeChanAndPropagate(okDone3)
+	close(okDone3)
+	await(t, closed3)
 
 	// st3 must be done
 	if hasNotClosed(st3.done) {
@@ -355,371 +960,4459 @@ func ShutdownSuccessiveCallTest(t *testing.T) {
 	}
 }
 
-func ShutdownTimeoutTest(t *testing.T) {
+func ShutdownReentrantTest(t *testing.T) {
 	t.Parallel()
 
-	st := withShutdown()
+	// sub is owned by the job and is shut down by the job itself from
+	// within its tail goroutine, while the parent is still closing.
+	sub, subTail := WithShutdown()
+	okDoneSub := runShutdownable(subTail)
 
-	// blocked finish
-	_ = runShutdownable(st)
+	child, childTail := WithShutdown()
+	okDoneChild := runShutdownable(childTail)
 
-	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
-	defer cancel()
+	jobSt, jobTail := WithShutdown(child)
+	st := Merge(jobSt, sub)
 
-	err := st.Shutdown(ctx)
-	if !errors.Is(err, ErrTimeout) {
-		t.Errorf("blocked shutdown didn't timeout")
-	}
-}
+	go func() {
+		<-jobTail.End()
 
-func ShutdownUnclosedTest(t *testing.T) {
-	t.Parallel()
+		ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+		defer cancel()
 
-	var (
+		// already closed child and concurrently closing sibling
+		if err := child.Shutdown(ctx); err != nil {
+			t.Errorf("child shutdown error: %v", err)
+		}
+
+		if err := sub.Shutdown(ctx); err != nil {
+			t.Errorf("sub shutdown error: %v", err)
+		}
+
+		jobTail.Done()
+	}()
+
+	close(okDoneSub)
+	close(okDoneChild)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := st.Shutdown(ctx); err != nil {
+		t.Errorf("re-entrant shutdown error: %v", err)
+	}
+}
+
+func ShutdownDetachedTest(t *testing.T) {
+	t.Parallel()
+
+	var (
 		st1 = withShutdown()
 		st2 = withShutdown(st1)
+
+		okDone1 = runShutdownable(st1)
 	)
 
-	okDone2 := runShutdownable(st2)
-	okDone1 := runShutdownable(st1)
+	// never started
+	st2.Detached()
 
-	closeChanAndPropagate(okDone1, okDone2)
+	closing1, _ := awaitPhases(t, st1)
+
+	go st2.close()
+	await(t, closing1)
+
+	// detached state is not finished before its children
+	if hasClosed(st1.done, st2.done) {
+		t.Error(errFinished)
+	}
+
+	close(okDone1)
 
 	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
 	defer cancel()
 
-	err := st2.Shutdown(ctx)
-	if err != nil {
-		t.Errorf(errTimeout)
+	if err := st2.Shutdown(ctx); err != nil {
+		t.Errorf("detached shutdown error: %v", err)
 	}
 
-	if err := st1.cause(); err != nil {
-		t.Errorf(errNotFinished)
-	}
+	// detached after the state started closing
+	st3 := withShutdown()
 
-	if err := st2.cause(); err != nil {
-		t.Errorf(errNotFinished)
+	go st3.close()
+	<-st3.end
+	st3.Detached()
+
+	if hasNotClosed(st3.done) {
+		t.Error(errNotFinished)
 	}
 }
 
-// Wait
-
-func WaitTest(t *testing.T) {
+func ShutdownHandleTest(t *testing.T) {
 	t.Parallel()
 
 	var (
-		st1 = withWait()
-		st2 = withWait(st1)
-		st3 = withWait(st2)
+		st1, tail1, handle = WithShutdownHandle()
+		st2                = withShutdown()
+		st                 = Merge(st1, st2)
 
-		okDone1 = runWaitable(st1)
-		okDone2 = runWaitable(st2)
-		okDone3 = runWaitable(st3)
+		okDone = runShutdownable(st2)
 	)
 
-	done := make(chan struct{})
+	close(okDone)
 
 	go func() {
-		st3.Wait()
-		close(done)
+		<-tail1.End()
+		tail1.Done()
 	}()
 
-	time.Sleep(failTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
 
-	if hasClosed(done) {
-		t.Error(errNotWaited)
+	if err := handle.Stop(ctx); err != nil {
+		t.Fatalf("unexpected stop error: %v", err)
 	}
 
-	closeChanAndPropagate(okDone1, okDone2)
-
-	if hasClosed(done) {
-		t.Error(errNotWaited)
+	if hasClosed(st2.end) {
+		t.Error("state is shut down by handle of another state")
 	}
 
-	closeChanAndPropagate(okDone3)
+	if err := st.Shutdown(ctx); err != nil {
+		t.Errorf("unexpected shutdown error: %v", err)
+	}
 
-	time.Sleep(failTimeout)
+	if hasNotClosed(st2.end) {
+		t.Error(errNotClosed)
+	}
 
-	if hasNotClosed(done) {
-		t.Error(errFinishWaiting)
+	if err := handle.Stop(ctx); err != nil {
+		t.Errorf("unexpected error of repeated stop: %v", err)
 	}
 }
 
-// Readiness
-
-func ReadinessWrapTest(t *testing.T) {
+func ShutdownTickerTest(t *testing.T) {
 	t.Parallel()
 
 	var (
-		st1 = withReadiness()
-		st2 = withReadiness(st1)
-		st3 = withReadiness(st2)
+		ticks   = make(chan struct{})
+		stopped = make(chan struct{})
+		once    sync.Once
 	)
 
-	if hasClosed(
-		st1.ready, st2.ready, st3.ready,
-	) {
-		t.Error(errInitReady)
-	}
+	st := WithTicker(time.Millisecond, func(ctx context.Context) {
+		once.Do(func() {
+			close(ticks)
 
-	st1ReadyC := st1.Ready()
-	st2ReadyC := st2.Ready()
-	st3ReadyC := st3.Ready()
+			// blocks until shutdown starts
+			<-ctx.Done()
+			close(stopped)
+		})
+	})
 
-	st3.Ok()
+	await(t, ticks)
 
-	time.Sleep(failTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
 
-	switch {
-	case hasNotClosed(st3.ready):
-		t.Error(errNotReady)
-	case hasClosed(st1.ready, st2.ready, st1ReadyC, st2ReadyC, st3ReadyC):
-		t.Error(errReady)
+	if err := st.Shutdown(ctx); err != nil {
+		t.Errorf("ticker shutdown error: %v", err)
 	}
 
-	st2.Ok()
-	time.Sleep(failTimeout)
-
-	switch {
-	case hasNotClosed(st2.ready, st3.ready):
-		t.Error(errNotReady)
-	case hasClosed(st1.ready, st1ReadyC, st2ReadyC, st3ReadyC):
-		t.Error(errReady)
+	if hasNotClosed(stopped) {
+		t.Errorf("running tick was not canceled")
 	}
+}
 
-	st1.Ok()
-	time.Sleep(failTimeout)
+func ShutdownTickerPanicTest(t *testing.T) {
+	t.Parallel()
 
-	if hasNotClosed(st1.ready, st2.ready, st3.ready, st1ReadyC, st2ReadyC, st3ReadyC) {
-		t.Error(errNotReady)
+	for _, tc := range []struct {
+		name     string
+		interval time.Duration
+		fn       func(ctx context.Context)
+	}{
+		{"non-positive interval", 0, func(context.Context) {}},
+		{"nil func", time.Millisecond, nil},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("%s didn't cause panic", tc.name)
+				}
+			}()
+
+			_ = WithTicker(tc.interval, tc.fn)
+		}()
 	}
 }
 
-func ReadinessSuccessiveOkTest(t *testing.T) {
+func ShutdownAllTest(t *testing.T) {
 	t.Parallel()
 
-	defer func() {
-		if r := recover(); r != nil {
-			t.Errorf("successive Ok call caused panic")
-		}
-	}()
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st3 = withShutdown()
 
-	st1 := withReadiness()
+		okDone1 = runShutdownable(st1)
+		_       = runShutdownable(st2) // blocked finish
+		okDone3 = runShutdownable(st3)
+	)
 
-	st1.Ok()
-	st1.Ok()
-	st1.Ok()
-	readyC := st1.Ready()
+	close(okDone1)
+	close(okDone3)
 
-	time.Sleep(failTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
 
-	if hasNotClosed(readyC) {
-		t.Error(errNotReady)
+	err := ShutdownAll(ctx, st1, WithAnnotation("second", st2), st3)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("blocked shutdown didn't timeout")
+	}
+
+	if want := "second: " + ErrTimeout.Error(); err.Error() != want {
+		t.Errorf("wrong error, want '%s', have '%s'", want, err.Error())
+	}
+
+	if hasNotClosed(st1.done, st3.done) {
+		t.Error(errNotFinished)
+	}
+
+	if err := ShutdownAll(ctx); err != nil {
+		t.Errorf("empty shutdown error: %v", err)
 	}
 }
 
-func ReadinessSuccessiveReadyTest(t *testing.T) {
+func ShutdownAsyncTest(t *testing.T) {
 	t.Parallel()
 
-	defer func() {
-		if r := recover(); r != nil {
-			t.Errorf("successive Ready call caused panic")
-		}
-	}()
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
 
-	st1 := withReadiness()
-	st1.Ok()
-	readyC := st1.Ready()
+		okDone1 = runShutdownable(st1)
+		_       = runShutdownable(st2) // blocked finish
+	)
 
-	time.Sleep(failTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
 
-	if hasNotClosed(readyC) {
-		t.Error(errNotReady)
+	res1 := ShutdownAsync(ctx, st1)
+	res2 := ShutdownAsync(ctx, st2)
+
+	close(okDone1)
+
+	if err := <-res1; err != nil {
+		t.Errorf("async shutdown error: %v", err)
 	}
 
-	readyC = st1.Ready()
+	if err := <-res2; !errors.Is(err, ErrTimeout) {
+		t.Errorf("blocked shutdown didn't timeout")
+	}
 
-	if hasNotClosed(readyC) {
-		t.Error(errNotReady)
+	if _, ok := <-res1; ok {
+		t.Errorf("result channel is not closed")
 	}
 }
 
-// Value
+// ShutdownRegisteredTest is not parallel as the registry is global.
+func ShutdownRegisteredTest(t *testing.T) {
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st3 = withShutdown()
 
-type key string
+		okDone1 = runShutdownable(st1)
+		okDone2 = runShutdownable(st2)
+		_       = runShutdownable(st3)
+	)
 
-func ValueWrapTest(t *testing.T) {
-	t.Parallel()
+	close(okDone1)
 
-	var (
-		testKey   = key("test_key")
-		testValue = "test_value"
-		st1       = withValue(testKey, testValue)
-		st2       = withWait(st1)
-		st3       = withWait(st2)
-	)
+	Register(st1)
+	deregister2 := Register(WithAnnotation("blocked", st2))
+	deregister3 := Register(st3)
 
-	value := st3.Value(testKey)
-	if value == nil {
-		t.Error("test value for test key not found")
+	deregister3()
+	deregister3()
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	err := ShutdownRegistered(ctx)
+	if !errors.Is(err, ErrTimeout) || !strings.HasPrefix(err.Error(), "blocked: ") {
+		t.Errorf("wrong error, want blocked shutdown timeout, have '%v'", err)
 	}
 
-	valueTyped, ok := value.(string)
-	if !ok {
-		t.Error("test value for test key has wrong type")
+	if hasNotClosed(st1.done) {
+		t.Error(errNotFinished)
 	}
 
-	if valueTyped != testValue {
-		t.Errorf("wrong test value: want %s have %s", testValue, valueTyped)
+	if hasClosed(st3.end) {
+		t.Error(errClosed)
+	}
+
+	close(okDone2)
+
+	ctx, cancel = context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	// the failed state stays registered
+	if err := ShutdownRegistered(ctx); err != nil {
+		t.Errorf("shutdown error: %v", err)
+	}
+
+	if hasNotClosed(st2.done) {
+		t.Error(errNotFinished)
+	}
+
+	deregister2()
+
+	if err := ShutdownRegistered(ctx); err != nil {
+		t.Errorf("empty registry shutdown error: %v", err)
 	}
 }
 
-func ValueChildrenTest(t *testing.T) {
+func ShutdownMergedTest(t *testing.T) {
 	t.Parallel()
 
 	var (
-		testKey1   = key("test_key1")
-		testValue1 = "test_value2"
-
-		testKey2   = key("test_key2")
-		testValue2 = "test_value2"
+		st1 = withShutdown()
+		st2 = withShutdown()
 
-		testKeyNotFound = key("test_key3")
-		st1             = withValue(testKey1, testValue1)
-		st2             = withValue(testKey2, testValue2, st1)
+		okDone1 = runShutdownable(st1)
+		_       = runShutdownable(st2) // blocked finish
 	)
 
-	value := st2.Value(testKey1)
-	if value == nil {
-		t.Error("test value 1 for test key not found")
-	}
-
-	valueTyped, ok := value.(string)
-	if !ok {
-		t.Error("test value 1 for test key has wrong type")
-	}
+	close(okDone1)
 
-	if valueTyped != testValue1 {
-		t.Errorf("wrong test value 1: want %s have %s", testValue1, valueTyped)
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
 
-	value2 := st2.Value(testKey2)
-	if value2 == nil {
-		t.Error("test value 2 for test key not found")
+	err := ShutdownMerged(ctx, st1, WithAnnotation("second", st2))
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("blocked shutdown didn't timeout")
 	}
 
-	value2Typed, ok := value2.(string)
-	if !ok {
-		t.Error("test value 2 for test key has wrong type")
+	if want := "second: " + ErrTimeout.Error(); err.Error() != want {
+		t.Errorf("wrong error, want '%s', have '%s'", want, err.Error())
 	}
 
-	if value2Typed != testValue2 {
-		t.Errorf("wrong test value 2: want %s have %s", testValue1, valueTyped)
+	if hasNotClosed(st1.done) {
+		t.Error(errNotFinished)
 	}
 
-	value3 := st2.Value(testKeyNotFound)
-	if value3 != nil {
-		t.Error("unused key returned non-nil value")
+	if err := ShutdownMerged(ctx); err != nil {
+		t.Errorf("empty shutdown error: %v", err)
 	}
 }
 
-func ValueNilPanicTest(t *testing.T) {
+func ShutdownPooledTest(t *testing.T) {
 	t.Parallel()
 
-	defer func() {
-		if r := recover(); r == nil {
-			t.Errorf("nil key did not panic")
-		}
-	}()
+	const workers = 2
 
-	_ = withValue(nil, "")
-}
+	var (
+		mu             sync.Mutex
+		active, peak   int
+		children       = make([]State, 6)
+		parentFinished = make(chan struct{})
+		childrenDone   int
+	)
+
+	for i := range children {
+		st, tail := WithShutdown()
+		children[i] = st
+
+		go func() {
+			<-tail.End()
+
+			mu.Lock()
+			if active++; active > peak {
+				peak = active
+			}
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			active--
+			childrenDone++
+			mu.Unlock()
+
+			tail.Done()
+		}()
+	}
+
+	parent, parentTail := WithShutdown()
+
+	go func() {
+		<-parentTail.End()
+
+		mu.Lock()
+		if childrenDone != len(children) {
+			t.Errorf("parent closed before %d children", len(children)-childrenDone)
+		}
+		mu.Unlock()
+
+		close(parentFinished)
+		parentTail.Done()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	st := WithAnnotation("app", parent.DependsOn(children...))
+
+	if err := ShutdownPooled(ctx, st, workers); err != nil {
+		t.Errorf("pooled shutdown error: %v", err)
+	}
+
+	if hasNotClosed(parentFinished, st.finishSig()) {
+		t.Error(errNotFinished)
+	}
+
+	if peak > workers {
+		t.Errorf("too many concurrent shutdowns, want at most %d, have %d", workers, peak)
+	}
+
+	// blocked child
+	blocked := withShutdown()
+	_ = runShutdownable(blocked)
+
+	ctx, cancel = context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := ShutdownPooled(ctx, WithAnnotation("blocked", blocked), workers); !errors.Is(err, ErrTimeout) {
+		t.Errorf("blocked shutdown didn't timeout")
+	}
+}
+
+func ShutdownReasonTest(t *testing.T) {
+	t.Parallel()
+
+	const reason = "SIGTERM"
+
+	var (
+		st1     = withShutdown()
+		st2     = withShutdown()
+		st      = WithAnnotation("app", st1.DependsOn(st2))
+		reason1 = make(chan string, 1)
+	)
+
+	go func() {
+		<-st1.End()
+		reason1 <- ShutdownReason(st1)
+		st1.Done()
+	}()
+
+	_ = runShutdownable(st2)
+
+	if r := ShutdownReason(st); r != "" {
+		t.Errorf("unexpected reason before shutdown: '%s'", r)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	// st2 is blocked
+	if err := ShutdownWithReason(ctx, st, reason); !errors.Is(err, ErrTimeout) {
+		t.Errorf("blocked shutdown didn't timeout")
+	}
+
+	if r := ShutdownReason(st2); r != reason {
+		t.Errorf("wrong reason, want '%s', have '%s'", reason, r)
+	}
+
+	st2.Done()
+
+	_ = ShutdownWithReason(context.Background(), st, "another reason")
+
+	if r := <-reason1; r != reason {
+		t.Errorf("wrong reason in job, want '%s', have '%s'", reason, r)
+	}
+
+	if r := ShutdownReason(st); r != reason {
+		t.Errorf("reason was overridden, want '%s', have '%s'", reason, r)
+	}
+}
+
+func ShutdownEndReasonTest(t *testing.T) {
+	t.Parallel()
+
+	const reason = "deploy"
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st  = WithAnnotation("app", st1.DependsOn(st2))
+	)
+
+	signals := make(chan ShutdownSignal, 2)
+
+	for _, tail := range []ShutdownTail{st1, st2} {
+		go func(tail ShutdownTail) {
+			signals <- <-tail.EndReason()
+			tail.Done()
+		}(tail)
+	}
+
+	if st1.EndReason() != st1.EndReason() {
+		t.Errorf("successive EndReason calls returned different channels")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	deadline, _ := ctx.Deadline()
+
+	if err := ShutdownWithReason(ctx, st, reason); err != nil {
+		t.Fatalf("shutdown error: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		s := <-signals
+
+		if s.Reason != reason {
+			t.Errorf("wrong reason, want '%s', have '%s'", reason, s.Reason)
+		}
+
+		if !s.Deadline.Equal(deadline) {
+			t.Errorf("wrong deadline, want '%v', have '%v'", deadline, s.Deadline)
+		}
+	}
+
+	// no deadline and reason
+	st3 := withShutdown()
+	c := st3.EndReason()
+
+	go func() { _ = st3.Shutdown(context.Background()) }()
+
+	if s := <-c; s.Reason != "" || !s.Deadline.IsZero() {
+		t.Errorf("unexpected signal: %v", s)
+	}
+
+	st3.Done()
+}
+
+func ShutdownPauseTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+
+		okDone1 = runShutdownable(st1)
+		okDone2 = runShutdownable(st2)
+	)
+
+	pauseSt, pause := WithPause(st1)
+	st := Merge(pauseSt, st2)
+
+	close(okDone1)
+	close(okDone2)
+	pause(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	err := st.Shutdown(ctx)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("paused shutdown didn't timeout")
+	}
+
+	switch {
+	case hasClosed(st1.end):
+		t.Error(errClosed)
+	case hasNotClosed(st2.done):
+		t.Error(errNotFinished)
+	}
+
+	pause(false)
+
+	ctx, cancel = context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := st.Shutdown(ctx); err != nil {
+		t.Errorf("unpaused shutdown error: %v", err)
+	}
+
+	// pausing has no effect after shutdown
+	pause(true)
+
+	if err := pauseSt.Shutdown(ctx); err != nil {
+		t.Errorf("shutdown error after pause: %v", err)
+	}
+}
+
+func ShutdownPauseNotUnpausedTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+
+		okDone1 = runShutdownable(st1)
+	)
+
+	close(okDone1)
+
+	st := withPause(st1)
+	st.pause(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := st.Shutdown(ctx); !errors.Is(err, ErrTimeout) {
+		t.Errorf("paused shutdown didn't timeout")
+	}
+
+	// the closing gives up past the deadline instead of blocking forever
+	closed := make(chan struct{})
+
+	go func() {
+		st.close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(failTimeout):
+		t.Errorf("closing blocked on the pause past the deadline")
+	}
+
+	if hasClosed(st1.end, st.finished) {
+		t.Error(errClosed)
+	}
+
+	// unpausing resumes the abandoned closing
+	st.pause(false)
+
+	select {
+	case <-st.finished:
+	case <-time.After(failTimeout):
+		t.Errorf("unpausing didn't resume the closing")
+	}
+}
+
+func ShutdownByWeightTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st3 = withShutdown()
+
+		okDone1 = runShutdownable(st1)
+		okDone2 = runShutdownable(st2)
+		okDone3 = runShutdownable(st3)
+
+		st = WithAnnotation("app", DependsOnWeighted(st1, 0.5, WithAnnotation("slow", st2), st3))
+	)
+
+	close(okDone3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 4*failTimeout)
+	defer cancel()
+
+	// st2 is blocked - children's share of the budget expires first
+	err := ShutdownByWeight(ctx, st)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("blocked shutdown didn't timeout")
+	}
+
+	if ctx.Err() != nil {
+		t.Errorf("children's budget is not limited")
+	}
+
+	if want := "app: slow: " + ErrTimeout.Error(); err.Error() != want {
+		t.Errorf("wrong error, want '%s', have '%s'", want, err.Error())
+	}
+
+	switch {
+	case hasClosed(st1.end):
+		t.Error(errClosed)
+	case hasNotClosed(st3.done):
+		t.Error(errNotFinished)
+	}
+
+	close(okDone1)
+	close(okDone2)
+
+	if err := ShutdownByWeight(ctx, st); err != nil {
+		t.Errorf("weighted shutdown error: %v", err)
+	}
+}
+
+func ShutdownEventsTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error")
+
+		st1 = withShutdown()
+		st2 = withReadiness()
+		st3 = withErrorGroup()
+
+		okDone1 = runShutdownable(st1)
+
+		st = Merge(WithAnnotation("job", st1), st2, WithAnnotation("errors", st3))
+	)
+
+	events := Events(st)
+
+	st2.Ok()
+	st3.Error(err1)
+
+	close(okDone1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := st.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown error: %v", err)
+	}
+
+	var got []Event
+
+	for e := range events {
+		got = append(got, e)
+	}
+
+	want := map[Event]bool{
+		{Path: "job", Kind: EventCreated}:               true,
+		{Path: "", Kind: EventCreated}:                  true,
+		{Path: "errors", Kind: EventCreated}:            true,
+		{Path: "", Kind: EventReady}:                    true,
+		{Path: "errors", Kind: EventErrored, Err: err1}: true,
+		{Path: "job", Kind: EventClosing}:               true,
+		{Path: "job", Kind: EventClosed}:                true,
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("wrong number of events, want %d, have %d: %v", len(want), len(got), got)
+	}
+
+	for i, e := range got {
+		if !want[e] {
+			t.Errorf("unexpected event: %v", e)
+		}
+
+		if i < 3 && e.Kind != EventCreated {
+			t.Errorf("created events are not sent first: %v", got)
+		}
+	}
+}
+
+func ShutdownFailFastTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("cleanup failed")
+
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st  = Merge(WithAnnotation("db", st1), st2)
+	)
+
+	// blocked finish
+	_ = runShutdownable(st2)
+
+	go func() {
+		<-st1.End()
+		st1.DoneErr(err1)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*failTimeout)
+	defer cancel()
+
+	err := ShutdownFailFast(ctx, st)
+	if !errors.Is(err, err1) {
+		t.Errorf("wrong error, want '%v', have '%v'", err1, err)
+	}
+
+	if want := "db: " + err1.Error(); err.Error() != want {
+		t.Errorf("wrong error, want '%s', have '%s'", want, err.Error())
+	}
+
+	if ctx.Err() != nil {
+		t.Errorf("shutdown didn't fail fast")
+	}
+}
+
+func ShutdownFailFastParentTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("cleanup failed")
+
+		st1    = withShutdown()
+		parent = withShutdown()
+		st     = parent.DependsOn(WithAnnotation("db", st1))
+	)
+
+	go func() {
+		<-st1.End()
+		st1.DoneErr(err1)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*failTimeout)
+	defer cancel()
+
+	if err := ShutdownFailFast(ctx, st); !errors.Is(err, err1) {
+		t.Errorf("wrong error, want '%v', have '%v'", err1, err)
+	}
+
+	select {
+	case <-parent.End():
+		t.Errorf("parent received End after a failed cleanup")
+	case <-time.After(failTimeout):
+	}
+}
+
+func ShutdownGracefulTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+
+		okDone1 = runShutdownable(st1)
+		_       = runShutdownable(st2) // blocked finish
+	)
+
+	go func() {
+		<-st1.End()
+		time.Sleep(failTimeout)
+		close(okDone1)
+	}()
+
+	// finished in force phase
+	if err := ShutdownGraceful(context.Background(), st1, failTimeout/2, 2*failTimeout); err != nil {
+		t.Errorf("forced shutdown error: %v", err)
+	}
+
+	var ge *GracefulError
+
+	err := ShutdownGraceful(context.Background(), st2, failTimeout/2, failTimeout/2)
+	if !errors.As(err, &ge) || !ge.Forced {
+		t.Errorf("force phase is not reported: %v", err)
+	}
+
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("blocked shutdown didn't timeout")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout/2)
+	defer cancel()
+
+	err = ShutdownGraceful(ctx, st2, failTimeout, failTimeout)
+	if !errors.As(err, &ge) || ge.Forced {
+		t.Errorf("grace phase is not reported: %v", err)
+	}
+}
+
+func ShutdownRetryTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st  = Merge(st1, st2)
+
+		okDone1 = runShutdownable(st1)
+		okDone2 = runShutdownable(st2)
+	)
+
+	close(okDone1)
+
+	err := ShutdownRetry(context.Background(), st, 2, failTimeout/10)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected %v, got %v", ErrTimeout, err)
+	}
+
+	if hasNotClosed(st1.done) {
+		t.Error("state is not shut down by failed attempts")
+	}
+
+	close(okDone2)
+
+	if err := ShutdownRetry(context.Background(), st, 2, failTimeout); err != nil {
+		t.Errorf("unexpected shutdown error: %v", err)
+	}
+
+	// parent context expires
+	var (
+		st3 = withShutdown()
+		_   = runShutdownable(st3)
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := ShutdownRetry(ctx, st3, 100, time.Hour); !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected %v, got %v", ErrTimeout, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("non-positive attempts didn't panic")
+		}
+	}()
+
+	_ = ShutdownRetry(context.Background(), Empty(), 0, time.Second)
+}
+
+func ShutdownStandaloneTailsTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error 1")
+
+		shutdownTail, end = NewShutdownTail()
+		waitTail, wait    = NewWaitTail()
+		errTail, err      = NewErrTail()
+		readyTail, ready  = NewReadinessTail()
+	)
+
+	waitTail.Add(1)
+
+	go func() {
+		defer waitTail.Done()
+
+		readyTail.Ok()
+		<-shutdownTail.End()
+		errTail.Error(err1)
+		shutdownTail.Done()
+	}()
+
+	await(t, ready())
+
+	if hasClosed(shutdownTail.End()) {
+		t.Error(errClosed)
+	}
+
+	await(t, end())
+
+	waited := make(chan struct{})
+
+	go func() {
+		wait()
+		close(waited)
+	}()
+
+	await(t, waited)
+
+	if !errors.Is(err(), err1) {
+		t.Errorf("expected %v, got %v", err1, err())
+	}
+}
+
+func ShutdownStaggeredTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st3 = withShutdown()
+		st  = MergeStaggered(failTimeout/4, failTimeout/10, st1, st2, st3)
+
+		okDone1 = runShutdownable(st1)
+		okDone2 = runShutdownable(st2)
+		okDone3 = runShutdownable(st3)
+	)
+
+	close(okDone1)
+	close(okDone2)
+	close(okDone3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*failTimeout)
+	defer cancel()
+
+	errC := ShutdownAsync(ctx, st)
+
+	await(t, st1.end)
+
+	if hasClosed(st2.end) || hasClosed(st3.end) {
+		t.Error("children are shut down at once")
+	}
+
+	await(t, st2.end)
+	await(t, st3.end)
+
+	if err := <-errC; err != nil {
+		t.Errorf("unexpected shutdown error: %v", err)
+	}
+
+	// staggering does not exceed the deadline
+	var (
+		st4 = withShutdown()
+		st5 = withShutdown()
+
+		okDone4 = runShutdownable(st4)
+		okDone5 = runShutdownable(st5)
+	)
+
+	close(okDone4)
+	close(okDone5)
+
+	ctx, cancel = context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := MergeStaggered(time.Hour, 0, st4, st5).Shutdown(ctx); err != nil {
+		t.Errorf("unexpected shutdown error: %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("negative delay didn't panic")
+		}
+	}()
+
+	MergeStaggered(-time.Second, 0)
+}
+
+func ShutdownReportTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st3 = withShutdown()
+		st  = Merge(WithAnnotation("db", st1), WithAnnotation("cache", st2.DependsOn(st3)))
+
+		okDone1 = runShutdownable(st1)
+		_       = runShutdownable(st2)
+		okDone3 = runShutdownable(st3)
+	)
+
+	close(okDone1)
+	close(okDone3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	unfinished, err := ShutdownReport(ctx, st)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected %v, got %v", ErrTimeout, err)
+	}
+
+	if len(unfinished) != 1 || unfinished[0] != "cache" {
+		t.Errorf("unexpected unfinished states: %q", unfinished)
+	}
+
+	unfinished, err = ShutdownReport(ctx, WithAnnotation("db", st1))
+	if err != nil || unfinished != nil {
+		t.Errorf("unexpected unfinished states %q and error %v", unfinished, err)
+	}
+}
+
+func ShutdownCloseTimeoutTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1, tail1 = WithCloseTimeout(failTimeout / 10)
+		st2, tail2 = WithCloseTimeout(failTimeout)
+		st3        = withShutdown(st1, st2)
+
+		_       = runShutdownable(tail1)
+		okDone2 = runShutdownable(tail2)
+		okDone3 = runShutdownable(st3)
+	)
+
+	close(okDone2)
+	close(okDone3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*failTimeout)
+	defer cancel()
+
+	if err := st3.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+
+	if err := st1.Err(); !errors.Is(err, ErrCloseTimeout) {
+		t.Errorf("expected %v, got %v", ErrCloseTimeout, err)
+	}
+
+	if err := st2.Err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := st3.Err(); !errors.Is(err, ErrCloseTimeout) {
+		t.Errorf("close timeout is not propagated: %v", err)
+	}
+}
+
+func ShutdownAndErrTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error 1")
+
+		st1 = withShutdown()
+		st2 = withShutdown(WithError(err1))
+		st  = Merge(st1, st2)
+
+		okDone1 = runShutdownable(st1)
+		okDone2 = runShutdownable(st2)
+	)
+
+	close(okDone2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	err := ShutdownAndErr(ctx, st)
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, err1) {
+		t.Errorf("expected %v and %v, got %v", ErrTimeout, err1, err)
+	}
+
+	close(okDone1)
+
+	ctx, cancel = context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := ShutdownAndErr(ctx, st1); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func ShutdownWhereTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st3 = withShutdown()
+
+		okDone1 = runShutdownable(st1)
+		okDone2 = runShutdownable(st2)
+		_       = runShutdownable(st3)
+
+		st = WithAnnotation("app", st1.DependsOn(WithAnnotation("child", st2)), st3)
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	// st1 depends on live unselected st2
+	err := ShutdownWhere(ctx, st, func(s State) bool { return s == st1 })
+	if !errors.Is(err, ErrDependencyViolation) {
+		t.Errorf("dependency violation is not reported: %v", err)
+	}
+
+	if hasClosed(st1.end, st2.end, st3.end) {
+		t.Error(errClosed)
+	}
+
+	closing2, _ := awaitPhases(t, st2)
+
+	go func() {
+		err := ShutdownWhere(ctx, st, func(s State) bool { return s == st1 || s == st2 })
+		if err != nil {
+			t.Errorf("shutdown error: %v", err)
+		}
+	}()
+
+	await(t, closing2)
+
+	if hasClosed(st1.end) {
+		t.Error(errClosed)
+	}
+
+	_, closed1 := awaitPhases(t, st1)
+
+	close(okDone2)
+	close(okDone1)
+	await(t, closed1)
+
+	if hasClosed(st3.end) {
+		t.Error(errClosed)
+	}
+
+	// st2 is shut down
+	if err := ShutdownWhere(ctx, st, func(s State) bool { return s == st1 }); err != nil {
+		t.Errorf("shutdown error: %v", err)
+	}
+}
+
+func ShutdownWatchdogTest(t *testing.T) {
+	t.Parallel()
+
+	const annotation = "watchdog test"
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st  = WithAnnotation(annotation, st1, st2)
+
+		okDone1 = runShutdownable(st1) // forgotten Done
+		okDone2 = runShutdownable(st2)
+
+		stalled = make(chan []string, 1)
+	)
+
+	SetShutdownWatchdog(failTimeout/2, func(paths []string) {
+		// other tests' shutdowns may stall concurrently
+		for _, p := range paths {
+			if p == annotation {
+				stalled <- paths
+				return
+			}
+		}
+	})
+	defer SetShutdownWatchdog(0, nil)
+
+	close(okDone2)
+
+	shutdownErr := make(chan error, 1)
+
+	go func() { shutdownErr <- st.Shutdown(context.Background()) }()
+
+	select {
+	case paths := <-stalled:
+		if len(paths) != 1 {
+			t.Errorf("wrong stalled paths: %v", paths)
+		}
+	case <-time.After(2 * failTimeout):
+		t.Errorf("watchdog didn't report stall")
+	}
+
+	close(okDone1)
+
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("shutdown error: %v", err)
+	}
+}
+
+// lineWriter sends written lines to a channel.
+type lineWriter chan string
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+// ShutdownProgressTest is not parallel as the logger is global.
+func ShutdownProgressTest(t *testing.T) {
+	var (
+		lines = make(lineWriter, 100)
+
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st  = WithShutdownProgress(time.Millisecond, st1, st2)
+
+		okDone1 = runShutdownable(st1)
+		okDone2 = runShutdownable(st2)
+	)
+
+	SetLogger(slog.New(slog.NewTextHandler(lines, nil)))
+	defer SetLogger(nil)
+
+	close(okDone1)
+
+	if n := Pending(st); n != 2 {
+		t.Errorf("wrong number of pending states, want 2, have %d", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	res := ShutdownAsync(ctx, st)
+
+	select {
+	case line := <-lines:
+		if want := `msg="shutting down" pending=1 remaining=1m0s`; !strings.Contains(line, want) {
+			t.Errorf("wrong progress line, want it to contain '%s', have '%s'", want, line)
+		}
+	case <-time.After(failTimeout):
+		t.Fatal(errTimeout)
+	}
+
+	close(okDone2)
+
+	if err := <-res; err != nil {
+		t.Errorf("shutdown error: %v", err)
+	}
+
+	if n := Pending(st); n != 0 {
+		t.Errorf("wrong number of pending states, want 0, have %d", n)
+	}
+}
+
+func ShutdownDurationsTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st3 = withShutdown()
+		st  = Merge(WithAnnotation("slow", st1), st2, st3)
+
+		okDone2 = runShutdownable(st2)
+		_       = runShutdownable(st3) // blocked finish
+	)
+
+	go func() {
+		<-st1.End()
+		time.Sleep(failTimeout / 2)
+		st1.Done()
+	}()
+
+	close(okDone2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	_ = st.Shutdown(ctx)
+
+	durations := ShutdownDurations(st)
+
+	if len(durations) != 2 || len(durations["slow"]) != 1 || len(durations[""]) != 1 {
+		t.Fatalf("wrong durations: %v", durations)
+	}
+
+	if d := durations["slow"][0]; d < failTimeout/2 {
+		t.Errorf("wrong duration of slow state: %v", d)
+	}
+}
+
+func ShutdownTimeoutTest(t *testing.T) {
+	t.Parallel()
+
+	st := withShutdown()
+
+	// blocked finish
+	_ = runShutdownable(st)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	err := st.Shutdown(ctx)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("blocked shutdown didn't timeout")
+	}
+}
+
+func ShutdownUnclosedTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown(st1)
+	)
+
+	okDone2 := runShutdownable(st2)
+	okDone1 := runShutdownable(st1)
+
+	closeChanAndPropagate(okDone1, okDone2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	err := st2.Shutdown(ctx)
+	if err != nil {
+		t.Errorf(errTimeout)
+	}
+
+	if err := st1.cause(); err != nil {
+		t.Errorf(errNotFinished)
+	}
+
+	if err := st2.cause(); err != nil {
+		t.Errorf(errNotFinished)
+	}
+}
+
+func ShutdownBarrierTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		barrier = make(chan struct{})
+
+		st1 = withShutdown()
+		st2 = withShutdownBarrier(barrier, st1)
+
+		okDone1 = runShutdownable(st1)
+	)
+
+	close(okDone1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := st2.Shutdown(ctx); !errors.Is(err, ErrTimeout) {
+		t.Errorf("shutdown didn't wait for barrier")
+	}
+
+	if hasClosed(st1.end) {
+		t.Error(errClosed)
+	}
+
+	close(barrier)
+
+	ctx, cancel = context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := st2.Shutdown(ctx); err != nil {
+		t.Errorf(errTimeout)
+	}
+
+	if hasNotClosed(st1.end, st1.done, st2.finished) {
+		t.Error(errNotFinished)
+	}
+}
+
+func ShutdownBarrierNotReleasedTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdownBarrier(make(chan struct{}), st1)
+
+		okDone1 = runShutdownable(st1)
+	)
+
+	close(okDone1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := st2.Shutdown(ctx); !errors.Is(err, ErrTimeout) {
+		t.Errorf("shutdown didn't wait for barrier")
+	}
+
+	// the closing gives up past the deadline instead of blocking forever
+	closed := make(chan struct{})
+
+	go func() {
+		st2.close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(failTimeout):
+		t.Errorf("closing blocked on the barrier past the deadline")
+	}
+
+	if hasClosed(st1.end, st2.finished) {
+		t.Error(errClosed)
+	}
+}
+
+func ShutdownGuardTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		errBusy  = errors.New("active transaction")
+		deadline bool
+
+		st1 = withShutdown()
+		st2 = withShutdown()
+
+		refused = WithAnnotation("db", WithShutdownGuard(func(ctx context.Context) error {
+			_, deadline = ctx.Deadline()
+			return errBusy
+		}, st1))
+
+		passed = WithShutdownGuard(func(ctx context.Context) error {
+			return nil
+		}, st2)
+
+		okDone1 = runShutdownable(st1)
+		okDone2 = runShutdownable(st2)
+	)
+
+	close(okDone1)
+	close(okDone2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	err := refused.Shutdown(ctx)
+	if !errors.Is(err, errBusy) {
+		t.Errorf("wrong error, want '%v', have '%v'", errBusy, err)
+	}
+
+	if want := "db: shutdown refused by guard: " + errBusy.Error(); err.Error() != want {
+		t.Errorf("wrong error, want '%s', have '%s'", want, err.Error())
+	}
+
+	if !deadline {
+		t.Error("guard check context has no deadline")
+	}
+
+	// children are not closed while the guard refuses
+	if hasClosed(st1.end) {
+		t.Error(errClosed)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := passed.Shutdown(ctx); err != nil {
+		t.Errorf("guarded shutdown error: %v", err)
+	}
+
+	if hasNotClosed(st2.done) {
+		t.Error(errNotFinished)
+	}
+}
+
+func ShutdownAfterFuncTest(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var (
+		st1 = withShutdown()
+		st2 = withAfterFunc(ctx, st1)
+
+		okDone1 = runShutdownable(st1)
+	)
+
+	close(okDone1)
+	time.Sleep(failTimeout)
+
+	if hasClosed(st1.end, st2.finished) {
+		t.Error(errClosed)
+	}
+
+	cancel()
+	time.Sleep(failTimeout)
+
+	if hasNotClosed(st1.end, st1.done, st2.finished) {
+		t.Error(errNotFinished)
+	}
+}
+
+func ShutdownAfterFuncStopTest(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	st := withAfterFunc(ctx)
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), failTimeout)
+	defer shutdownCancel()
+
+	if err := st.Shutdown(shutdownCtx); err != nil {
+		t.Errorf(errTimeout)
+	}
+
+	if st.stop() {
+		t.Errorf("after func registration was not stopped by shutdown")
+	}
+}
+
+func ShutdownAfterFuncDoneTest(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withAfterFunc(ctx, st1)
+
+		okDone1 = runShutdownable(st1)
+	)
+
+	close(okDone1)
+
+	await(t, st2.finished)
+
+	if hasNotClosed(st1.end, st1.done) {
+		t.Error(errNotFinished)
+	}
+}
+
+func ShutdownFileWatchTest(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config")
+
+	if err := os.WriteFile(path, []byte("a"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+
+		_   = withFileWatch(path, time.Millisecond, st1)
+		st4 = withFileWatch(path, time.Millisecond, st2)
+
+		_ = runShutdownable(st1)
+		_ = runShutdownable(st2)
+	)
+
+	// shut down normally
+	go st4.close()
+	await(t, st2.end)
+
+	if hasNotClosed(st4.stop) {
+		t.Error("file is still watched after shutdown")
+	}
+
+	if hasClosed(st1.end) {
+		t.Error(errClosed)
+	}
+
+	if err := os.WriteFile(path, []byte("ab"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	await(t, st1.end)
+}
+
+func ShutdownCallbackTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st3 = withShutdown()
+		st4 = withDependency(withAnnotation("parent", st3), withAnnotation("child", st1))
+		st5 = merge(st4, withAnnotation("stuck", st2))
+
+		okDone1 = runShutdownable(st1)
+		okDone3 = runShutdownable(st3)
+		_       = runShutdownable(st2)
+	)
+
+	close(okDone1)
+
+	go func() {
+		// parent finishes strictly after the child reported
+		time.Sleep(failTimeout / 2)
+		close(okDone3)
+	}()
+
+	var (
+		paths []string
+		errs  []error
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	err := ShutdownWithCallback(ctx, st5, func(path string, err error) {
+		paths = append(paths, path)
+		errs = append(errs, err)
+	})
+
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("blocked shutdown didn't timeout")
+	}
+
+	want := []string{"child", "parent", "stuck"}
+
+	if fmt.Sprint(paths) != fmt.Sprint(want) {
+		t.Fatalf("wrong callback paths: want %v, have %v", want, paths)
+	}
+
+	if errs[0] != nil || errs[1] != nil {
+		t.Errorf("finished state reported error: %v", errs)
+	}
+
+	if !errors.Is(errs[2], ErrTimeout) {
+		t.Errorf("unfinished state didn't report timeout: %v", errs[2])
+	}
+}
+
+func ShutdownCloserTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+
+		okDone1 = runShutdownable(st1)
+		_       = runShutdownable(st2)
+	)
+
+	close(okDone1)
+
+	if err := AsCloser(st1, failTimeout).Close(); err != nil {
+		t.Errorf(errTimeout)
+	}
+
+	if err := AsCloser(st2, failTimeout).Close(); !errors.Is(err, ErrTimeout) {
+		t.Errorf("blocked close didn't timeout")
+	}
+}
+
+func ShutdownStreamTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st3 = withDependency(withAnnotation("parent", st2), withAnnotation("child", st1))
+
+		okDone1 = runShutdownable(st1)
+		okDone2 = runShutdownable(st2)
+	)
+
+	close(okDone1)
+	close(okDone2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	events, errc := ShutdownStream(ctx, st3)
+
+	var have []string
+
+	for e := range events {
+		have = append(have, e.Path+" "+e.Phase.String())
+	}
+
+	want := []string{"child closing", "child closed", "parent closing", "parent closed"}
+
+	if fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("wrong events: want %v, have %v", want, have)
+	}
+
+	if err := <-errc; err != nil {
+		t.Errorf(errTimeout)
+	}
+}
+
+func ShutdownHTTPServerTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st  = HTTPServer(&http.Server{Addr: "127.0.0.1:0"}, st1)
+
+		okDone1 = runShutdownable(st1)
+	)
+
+	close(okDone1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := st.Shutdown(ctx); err != nil {
+		t.Errorf("http server shutdown error: %v", err)
+	}
+
+	if hasNotClosed(st1.done) {
+		t.Error(errNotFinished)
+	}
+
+	if err := st.Err(); err != nil {
+		t.Errorf("http server error: %v", err)
+	}
+
+	// fatal error
+	st2 := HTTPServer(&http.Server{Addr: "invalid address"})
+
+	select {
+	case <-st2.(*group).states[1].(errSignaler).errSig():
+	case <-time.After(failTimeout):
+		t.Fatal(errTimeout)
+	}
+
+	if err := st2.Err(); err == nil || !strings.HasPrefix(err.Error(), "http server: ") {
+		t.Errorf("wrong http server error: %v", err)
+	}
+}
+
+func ShutdownHTTPServerInFlightTest(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := l.Addr().String()
+	l.Close()
+
+	var (
+		release  = make(chan struct{})
+		received = make(chan struct{})
+
+		st = HTTPServer(&http.Server{
+			Addr: addr,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(received)
+				<-release
+			}),
+		})
+	)
+
+	go func() {
+		for {
+			resp, err := http.Get("http://" + addr)
+			if err == nil {
+				resp.Body.Close()
+				return
+			}
+
+			if isClosed(received) {
+				return
+			}
+
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	await(t, received)
+
+	if n := InFlight(st); n != 1 {
+		t.Errorf("wrong number of requests in flight, want 1, have %d", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	var drainErr *DrainTimeoutError
+
+	err = st.Shutdown(ctx)
+	if !errors.As(err, &drainErr) || drainErr.InFlight != 1 {
+		t.Errorf("wrong error, want drain timeout with 1 request in flight, have '%v'", err)
+	}
+
+	close(release)
+
+	ctx, cancel = context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := st.Shutdown(ctx); err != nil {
+		t.Errorf("http server shutdown error: %v", err)
+	}
+
+	if n := InFlight(st); n != 0 {
+		t.Errorf("wrong number of requests in flight, want 0, have %d", n)
+	}
+}
+
+// Wait
+
+func WaitTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withWait()
+		st2 = withWait(st1)
+		st3 = withWait(st2)
+
+		okDone1 = runWaitable(st1)
+		okDone2 = runWaitable(st2)
+		okDone3 = runWaitable(st3)
+	)
+
+	done := make(chan struct{})
+
+	go func() {
+		st3.Wait()
+		close(done)
+	}()
+
+	time.Sleep(failTimeout)
+
+	if hasClosed(done) {
+		t.Error(errNotWaited)
+	}
+
+	closeChanAndPropagate(okDone1, okDone2)
+
+	if hasClosed(done) {
+		t.Error(errNotWaited)
+	}
+
+	closeChanAndPropagate(okDone3)
+
+	time.Sleep(failTimeout)
+
+	if hasNotClosed(done) {
+		t.Error(errFinishWaiting)
+	}
+}
+
+func WaitProgressTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withWait()
+		st2 = withWait(st1)
+		st3 = merge(st2, st1)
+	)
+
+	st1.Add(2)
+	st2.Add(1)
+
+	if n := Progress(st3); n != 3 {
+		t.Errorf("wrong progress: want 3, have %d", n)
+	}
+
+	st1.Done()
+
+	if n := Progress(st3); n != 2 {
+		t.Errorf("wrong progress: want 2, have %d", n)
+	}
+}
+
+func WaitWithTimeoutTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withWait()
+		st2 = merge(st1)
+
+		okWait1 = runWaitable(st1)
+	)
+
+	if !WaitWithTimeout(st2, failTimeout) {
+		t.Error(errNotWaited)
+	}
+
+	close(okWait1)
+
+	if WaitWithTimeout(st2, failTimeout) {
+		t.Error(errFinishWaiting)
+	}
+}
+
+func WaitCtxTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withWait()
+		st2 = withWait()
+		st  = st1.DependsOn(st2)
+
+		_      = runWaitable(st1) // stuck task
+		okWait = runWaitable(st2)
+	)
+
+	close(okWait)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := WaitCtx(ctx, st); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("stuck wait didn't return ctx error: %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := WaitCtx(ctx, st2); err != nil {
+		t.Errorf("wait error: %v", err)
+	}
+}
+
+func WaitFuncTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ok   = make(chan struct{})
+		seen = make(chan int, 3)
+		fns  []func()
+	)
+
+	for i := 0; i < 3; i++ {
+		fns = append(fns, func(i int) func() {
+			return func() {
+				<-ok
+				seen <- i
+			}
+		}(i))
+	}
+
+	st := WithWaitFunc(fns)
+
+	if n := Progress(st); n != 3 {
+		t.Errorf("expected 3 tasks in flight, got %d", n)
+	}
+
+	if !WaitWithTimeout(st, failTimeout/10) {
+		t.Error("wait didn't stall on running tasks")
+	}
+
+	close(ok)
+
+	if WaitWithTimeout(st, failTimeout) {
+		t.Fatal("wait stalled after tasks returned")
+	}
+
+	close(seen)
+
+	var sum int
+	for i := range seen {
+		sum += i
+	}
+
+	if sum != 3 {
+		t.Errorf("tasks are not run with their own values: %d", sum)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("nil wait func didn't panic")
+		}
+	}()
+
+	WithWaitFunc([]func(){nil})
+}
+
+func WaitAnyTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withWait()
+		st2 = withWait()
+		st  = merge(st1, st2)
+
+		won = make(chan State, 1)
+	)
+
+	st1.Add(1)
+	st2.Add(1)
+
+	go func() { won <- WaitAny(st) }()
+
+	st2.Done()
+
+	select {
+	case w := <-won:
+		if w != st2 {
+			t.Errorf("wrong child won")
+		}
+	case <-time.After(failTimeout):
+		t.Fatal(errTimeout)
+	}
+
+	st1.Done()
+
+	// without children
+	st3 := withWait()
+
+	if w := WaitAny(st3); w != st3 {
+		t.Errorf("wrong state returned")
+	}
+}
+
+func WaitEachCtxTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withWait()
+		st2 = withWait()
+		st3 = withWait()
+		st  = WithAnnotation("app", withAnnotation("first", st1), withAnnotation("second", st2), st3)
+	)
+
+	st1.Add(1)
+	st3.Add(1)
+
+	err := WaitEachCtx(context.Background(), st, time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("stuck wait didn't timeout")
+	}
+
+	want := "app: first: " + errWaitBudget.Error() + "\napp: " + errWaitBudget.Error()
+	if err.Error() != want {
+		t.Errorf("wrong error, want '%s', have '%s'", want, err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := WaitEachCtx(ctx, st, failTimeout); !errors.Is(err, context.Canceled) {
+		t.Errorf("wrong error, want '%v', have '%v'", context.Canceled, err)
+	}
+
+	st1.Done()
+	st3.Done()
+
+	if err := WaitEachCtx(context.Background(), st, failTimeout); err != nil {
+		t.Errorf("wait error: %v", err)
+	}
+}
+
+func WaitEachCtxOwnTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withWait()
+		st  = withWait(st1)
+	)
+
+	// the state's own counter is waited on along with its children
+	st.Add(1)
+
+	err := WaitEachCtx(context.Background(), WithAnnotation("app", st), time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("stuck wait didn't timeout")
+	}
+
+	err = WaitEachCtx(context.Background(), st, time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("stuck own counter didn't timeout")
+	}
+
+	if want := errWaitBudget.Error(); err.Error() != want {
+		t.Errorf("wrong error, want '%s', have '%s'", want, err.Error())
+	}
+
+	st.Done()
+
+	if err := WaitEachCtx(context.Background(), st, failTimeout); err != nil {
+		t.Errorf("wait error: %v", err)
+	}
+}
+
+// errGroup mimics errgroup.Group.
+type errGroup struct {
+	wg  sync.WaitGroup
+	err error
+}
+
+func (g *errGroup) Go(fn func() error) {
+	g.wg.Add(1)
+
+	go func() {
+		defer g.wg.Done()
+
+		if err := fn(); err != nil && g.err == nil {
+			g.err = err
+		}
+	}()
+}
+
+func (g *errGroup) Wait() error {
+	g.wg.Wait()
+	return g.err
+}
+
+func WaitErrGroupTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error1")
+		g    = &errGroup{}
+		ok   = make(chan struct{})
+	)
+
+	g.Go(func() error {
+		<-ok
+		return err1
+	})
+
+	st := merge(FromErrGroup(g))
+
+	if err := st.Err(); err != nil {
+		t.Errorf("error group returned error before Wait")
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		st.Wait()
+		close(done)
+	}()
+
+	time.Sleep(failTimeout)
+
+	if hasClosed(done) {
+		t.Error(errNotWaited)
+	}
+
+	closeChanAndPropagate(ok)
+
+	if hasNotClosed(done) {
+		t.Error(errFinishWaiting)
+	}
+
+	if err := st.Err(); !errors.Is(err, err1) {
+		t.Errorf("wrong error, want '%v', have '%v'", err1, err)
+	}
+}
+
+func WaitWorkerTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("task failed")
+		ok   = make(chan struct{})
+	)
+
+	st1, worker1 := WithWorker()
+	st2, worker2 := WithWorker()
+	st := Merge(WithAnnotation("first", st1), WithAnnotation("second", st2))
+
+	worker1.Go(func() error {
+		<-ok
+		return nil
+	})
+
+	worker2.Go(func() error {
+		<-ok
+		return err1
+	})
+
+	worker2.Go(func() error {
+		<-ok
+		return errors.New("another error")
+	})
+
+	if err := st.Err(); err != nil {
+		t.Errorf("unexpected error before tasks finished: %v", err)
+	}
+
+	close(ok)
+
+	err := WaitErr(st)
+	if err == nil {
+		t.Fatal("error is not returned")
+	}
+
+	if !strings.HasPrefix(err.Error(), "second: ") {
+		t.Errorf("error is not annotated: %v", err)
+	}
+
+	if err := WaitErr(st1); err != nil {
+		t.Errorf("unexpected error of successful worker: %v", err)
+	}
+}
+
+func WaitWorkerPanicTest(t *testing.T) {
+	t.Parallel()
+
+	type panicValue struct{ code int }
+
+	var (
+		err1 = errors.New("panic error")
+
+		st1, worker1 = WithWorker()
+		st2, worker2 = WithWorker()
+	)
+
+	worker1.Go(func() error {
+		panic(panicValue{code: 1})
+	})
+
+	worker2.Go(func() error {
+		panic(err1)
+	})
+
+	var pe *PanicError
+
+	err := WaitErr(st1)
+	if !errors.As(err, &pe) {
+		t.Fatalf("panic is not captured: %v", err)
+	}
+
+	if v, ok := pe.Value.(panicValue); !ok || v.code != 1 {
+		t.Errorf("wrong panic value: %v", pe.Value)
+	}
+
+	if len(pe.Stack) == 0 {
+		t.Errorf("panic stack is not captured")
+	}
+
+	if err := WaitErr(st2); !errors.Is(err, err1) {
+		t.Errorf("panic error is not unwrapped, want '%v', have '%v'", err1, err)
+	}
+}
+
+func WaitDrainTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withDrain(st1)
+
+		okDone1 = runShutdownable(st1)
+		okWait2 = runWaitable(st2)
+	)
+
+	go st2.close()
+	closeChanAndPropagate(okDone1)
+
+	switch {
+	case hasNotClosed(st1.done):
+		t.Error(errNotFinished)
+	case hasClosed(st2.drained):
+		t.Error(errFinished)
+	}
+
+	closeChanAndPropagate(okWait2)
+
+	if hasNotClosed(st2.drained) {
+		t.Error(errNotFinished)
+	}
+}
+
+func WaitDrainTimeoutTest(t *testing.T) {
+	t.Parallel()
+
+	st := withDrain()
+
+	for i := 0; i < 3; i++ {
+		_ = runWaitable(st)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	err := WithAnnotation("worker", st).Shutdown(ctx)
+
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("blocked drain didn't timeout")
+	}
+
+	var drainErr *DrainTimeoutError
+
+	if !errors.As(err, &drainErr) || drainErr.InFlight != 3 {
+		t.Fatalf("wrong drain error: %v", err)
+	}
+
+	if want := "worker: drain timeout: 3 tasks still in flight"; err.Error() != want {
+		t.Errorf("wrong drain error, want '%s', have '%s'", want, err.Error())
+	}
+}
+
+// Readiness
+
+func ReadinessWrapTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withReadiness()
+		st2 = withReadiness(st1)
+		st3 = withReadiness(st2)
+	)
+
+	if hasClosed(
+		st1.ready, st2.ready, st3.ready,
+	) {
+		t.Error(errInitReady)
+	}
+
+	st1ReadyC := st1.Ready()
+	st2ReadyC := st2.Ready()
+	st3ReadyC := st3.Ready()
+
+	st3.Ok()
+
+	time.Sleep(failTimeout)
+
+	switch {
+	case hasNotClosed(st3.ready):
+		t.Error(errNotReady)
+	case hasClosed(st1.ready, st2.ready, st1ReadyC, st2ReadyC, st3ReadyC):
+		t.Error(errReady)
+	}
+
+	st2.Ok()
+	time.Sleep(failTimeout)
+
+	switch {
+	case hasNotClosed(st2.ready, st3.ready):
+		t.Error(errNotReady)
+	case hasClosed(st1.ready, st1ReadyC, st2ReadyC, st3ReadyC):
+		t.Error(errReady)
+	}
+
+	st1.Ok()
+	time.Sleep(failTimeout)
+
+	if hasNotClosed(st1.ready, st2.ready, st3.ready, st1ReadyC, st2ReadyC, st3ReadyC) {
+		t.Error(errNotReady)
+	}
+}
+
+func ReadinessSuccessiveOkTest(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("successive Ok call caused panic")
+		}
+	}()
+
+	st1 := withReadiness()
+
+	st1.Ok()
+	st1.Ok()
+	st1.Ok()
+	readyC := st1.Ready()
+
+	time.Sleep(failTimeout)
+
+	if hasNotClosed(readyC) {
+		t.Error(errNotReady)
+	}
+}
+
+func ReadinessSuccessiveReadyTest(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("successive Ready call caused panic")
+		}
+	}()
+
+	st1 := withReadiness()
+	st1.Ok()
+	readyC := st1.Ready()
+
+	time.Sleep(failTimeout)
+
+	if hasNotClosed(readyC) {
+		t.Error(errNotReady)
+	}
+
+	readyC = st1.Ready()
+
+	if hasNotClosed(readyC) {
+		t.Error(errNotReady)
+	}
+}
+
+func ReadinessQuorumTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withReadiness()
+		st2 = withReadiness()
+		st3 = withReadiness()
+		st4 = mergeQuorumReady(2, st1, st2, st3)
+	)
+
+	readyC := st4.Ready()
+
+	st1.Ok()
+	time.Sleep(failTimeout)
+
+	if hasClosed(readyC) {
+		t.Error(errReady)
+	}
+
+	st3.Ok()
+	time.Sleep(failTimeout)
+
+	if hasNotClosed(readyC) {
+		t.Error(errNotReady)
+	}
+
+	if st4.Ready() != readyC {
+		t.Errorf("successive Ready call returned new channel")
+	}
+}
+
+func ReadinessQuorumPanicTest(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("quorum greater than number of states didn't cause panic")
+		}
+	}()
+
+	_ = mergeQuorumReady(2, withReadiness(), nil)
+}
+
+func ReadinessFailFastTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error 1")
+
+		st1, errTail1 = WithErrorGroup()
+		st2, tail2    = WithReadiness(st1)
+		st3, tail3    = WithReadiness()
+		st            = MergeFailFastReady(st2, st3)
+	)
+
+	if hasClosed(st.Ready()) {
+		t.Error("state is ready before children are resolved")
+	}
+
+	tail3.Ok()
+
+	if hasClosed(st.Ready()) {
+		t.Error("state is ready while child is neither ready nor failed")
+	}
+
+	errTail1.Error(err1)
+	await(t, st.Ready())
+
+	if err := st.Err(); !errors.Is(err, err1) {
+		t.Errorf("expected %v, got %v", err1, err)
+	}
+
+	if hasClosed(st2.Ready()) {
+		t.Error("failed child is ready")
+	}
+
+	tail2.Ok()
+
+	// child failed before Ready is called
+	st4, _ := WithReadiness(WithError(err1))
+	st = MergeFailFastReady(st4)
+
+	await(t, st.Ready())
+}
+
+func ReadinessAbortTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withReadiness()
+		st2 = withReadiness()
+		st3 = merge(withAnnotation("a", st1), st2)
+	)
+
+	st2.Ok()
+
+	readyC := st3.Ready()
+
+	if err := ReadyErr(st3); err != nil {
+		t.Errorf("unexpected readiness error: %v", err)
+	}
+
+	AbortReadiness(st3)
+	time.Sleep(failTimeout)
+
+	if hasNotClosed(readyC) {
+		t.Errorf("aborted readiness didn't unblock Ready")
+	}
+
+	err := ReadyErr(st3)
+	if !errors.Is(err, ErrReadinessAborted) {
+		t.Errorf("wrong readiness error, want '%v', have '%v'", ErrReadinessAborted, err)
+	}
+
+	if want := "a: readiness aborted"; err.Error() != want {
+		t.Errorf("readiness error is not annotated, want '%s', have '%s'", want, err.Error())
+	}
+
+	if err := ReadyErr(st2); err != nil {
+		t.Errorf("ready state was aborted: %v", err)
+	}
+}
+
+func ReadinessAbortLiveTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1, tail1 = WithLiveReadiness()
+		st2        = WithReadinessStabilize(failTimeout/10, st1)
+		st         = WithAnnotation("db", st2)
+	)
+
+	ready := st.Ready()
+
+	AbortReadiness(st)
+
+	await(t, ready)
+	await(t, st1.Ready())
+
+	if err := ReadyErr(st); !errors.Is(err, ErrReadinessAborted) || err.Error() != "db: readiness aborted" {
+		t.Errorf("unexpected readiness error: %v", err)
+	}
+
+	tail1.NotReady()
+
+	if hasNotClosed(st1.Ready()) {
+		t.Error("aborted readiness is lost")
+	}
+}
+
+func ReadinessChanTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withReadiness()
+		st2 = withReadiness()
+		st3 = st1.DependsOn(st2)
+	)
+
+	readyC := ReadyChan(st3)
+
+	st1.Ok()
+	time.Sleep(failTimeout)
+
+	select {
+	case <-readyC:
+		t.Error(errReady)
+	default:
+	}
+
+	AbortReadiness(st2)
+
+	select {
+	case err := <-readyC:
+		if !errors.Is(err, ErrReadinessAborted) {
+			t.Errorf("wrong readiness error, want '%v', have '%v'", ErrReadinessAborted, err)
+		}
+	case <-time.After(failTimeout):
+		t.Fatal(errNotReady)
+	}
+
+	if _, ok := <-readyC; ok {
+		t.Errorf("readiness channel is not closed")
+	}
+
+	st4 := withReadiness()
+	st4.Ok()
+
+	if err := <-ReadyChan(st4); err != nil {
+		t.Errorf("ready state returned error: %v", err)
+	}
+}
+
+func ReadinessErrorsTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withReadiness()
+		st2 = withReadiness()
+		st3 = withReadiness()
+		st  = merge(withAnnotation("a", st1), st2, withAnnotation("c", st3), withAnnotation("shared", st3))
+	)
+
+	if errs := ReadinessErrors(st); errs != nil {
+		t.Errorf("unexpected readiness errors: %v", errs)
+	}
+
+	st2.Ok()
+	AbortReadiness(st)
+
+	errs := ReadinessErrors(st)
+
+	if len(errs) != 2 {
+		t.Fatalf("wrong number of errors: want 2, have %d: %v", len(errs), errs)
+	}
+
+	for i, want := range []string{"a: ", "c: "} {
+		if want += ErrReadinessAborted.Error(); errs[i].Error() != want {
+			t.Errorf("wrong error, want '%s', have '%v'", want, errs[i])
+		}
+	}
+}
+
+func ReadinessContextTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx1, cancel1 = context.WithCancel(context.Background())
+		ctx2, cancel2 = context.WithCancel(context.Background())
+
+		st1 = withReadinessContext(ctx1)
+		st2 = withReadinessContext(ctx2)
+	)
+
+	select {
+	case <-st1.Ready():
+		t.Error(errReady)
+	default:
+	}
+
+	cancel1()
+
+	select {
+	case <-st1.Ready():
+	case <-time.After(failTimeout):
+		t.Fatal(errNotReady)
+	}
+
+	// shut down before readiness
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := st2.Shutdown(ctx); err != nil {
+		t.Errorf("shutdown error: %v", err)
+	}
+
+	if st2.stop() {
+		t.Error("readiness registration was not stopped by shutdown")
+	}
+
+	cancel2()
+}
+
+func ReadinessLiveTest(t *testing.T) {
+	t.Parallel()
+
+	st, tail := WithLiveReadiness()
+
+	ready1 := st.Ready()
+	if hasClosed(ready1) {
+		t.Error(errReady)
+	}
+
+	tail.Ready()
+	await(t, ready1)
+
+	tail.NotReady()
+
+	ready2 := st.Ready()
+	if hasClosed(ready2) {
+		t.Error(errReady)
+	}
+
+	// successive calls return the same channel
+	if st.Ready() != ready2 {
+		t.Errorf("successive Ready calls returned different channels")
+	}
+
+	tail.Ready()
+	tail.Ready()
+	await(t, ready2)
+	await(t, st.Ready())
+}
+
+func ReadinessStabilizeTest(t *testing.T) {
+	t.Parallel()
+
+	const d = 20 * time.Millisecond
+
+	var (
+		st1, tail1 = WithLiveReadiness()
+		st2        = withReadiness()
+		st         = WithReadinessStabilize(d, WithAnnotation("live", st1), st2)
+	)
+
+	ready := st.Ready()
+
+	tail1.Ready()
+	st2.Ok()
+
+	// readiness is lost within the window
+	tail1.NotReady()
+
+	select {
+	case <-ready:
+		t.Error(errReady)
+	case <-time.After(2 * d):
+	}
+
+	regained := time.Now()
+	tail1.Ready()
+
+	select {
+	case <-ready:
+		if elapsed := time.Since(regained); elapsed < d {
+			t.Errorf("readiness didn't hold for %v, only for %v", d, elapsed)
+		}
+	case <-time.After(failTimeout):
+		t.Fatal(errNotReady)
+	}
+}
+
+func ReadinessStatusTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error")
+
+		st1 = withReadiness()
+		st2 = withShutdown()
+		st  = WithError(err1).DependsOn(Merge(st1, st2))
+
+		okDone2 = runShutdownable(st2)
+	)
+
+	status := StatusOf(st)
+
+	switch {
+	case status.Ready:
+		t.Error(errReady)
+	case status.Finished:
+		t.Error(errFinished)
+	case !errors.Is(status.Err, err1):
+		t.Errorf("wrong error, want '%v', have '%v'", err1, status.Err)
+	}
+
+	st1.Ok()
+
+	// readiness is resolved synchronously
+	if !StatusOf(st).Ready {
+		t.Error(errNotReady)
+	}
+
+	close(okDone2)
+
+	if err := st.Shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown error: %v", err)
+	}
+
+	if !StatusOf(st).Finished {
+		t.Error(errNotFinished)
+	}
+}
+
+func ReadinessStatusQuorumTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1, tail1 = WithReadiness()
+		st2, _     = WithReadiness()
+		st3, _     = WithReadiness(WithError(errors.New("error")))
+	)
+
+	tail1.Ok()
+
+	// readiness is resolved on the first call, without waiting for
+	// the background goroutine
+	if !StatusOf(MergeQuorumReady(1, st1, st2)).Ready {
+		t.Error("quorum of ready children is not ready")
+	}
+
+	if !StatusOf(MergeFailFastReady(st1, st3)).Ready {
+		t.Error("ready and failed children are not ready")
+	}
+
+	if StatusOf(MergeQuorumReady(2, st1, st2)).Ready {
+		t.Error("quorum is ready without enough ready children")
+	}
+}
+
+// Value
+
+type key string
+
+func ValueWrapTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		testKey   = key("test_key")
+		testValue = "test_value"
+		st1       = withValue(testKey, testValue)
+		st2       = withWait(st1)
+		st3       = withWait(st2)
+	)
+
+	value := st3.Value(testKey)
+	if value == nil {
+		t.Error("test value for test key not found")
+	}
+
+	valueTyped, ok := value.(string)
+	if !ok {
+		t.Error("test value for test key has wrong type")
+	}
+
+	if valueTyped != testValue {
+		t.Errorf("wrong test value: want %s have %s", testValue, valueTyped)
+	}
+}
+
+func ValueChildrenTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		testKey1   = key("test_key1")
+		testValue1 = "test_value2"
+
+		testKey2   = key("test_key2")
+		testValue2 = "test_value2"
+
+		testKeyNotFound = key("test_key3")
+		st1             = withValue(testKey1, testValue1)
+		st2             = withValue(testKey2, testValue2, st1)
+	)
+
+	value := st2.Value(testKey1)
+	if value == nil {
+		t.Error("test value 1 for test key not found")
+	}
+
+	valueTyped, ok := value.(string)
+	if !ok {
+		t.Error("test value 1 for test key has wrong type")
+	}
+
+	if valueTyped != testValue1 {
+		t.Errorf("wrong test value 1: want %s have %s", testValue1, valueTyped)
+	}
+
+	value2 := st2.Value(testKey2)
+	if value2 == nil {
+		t.Error("test value 2 for test key not found")
+	}
+
+	value2Typed, ok := value2.(string)
+	if !ok {
+		t.Error("test value 2 for test key has wrong type")
+	}
+
+	if value2Typed != testValue2 {
+		t.Errorf("wrong test value 2: want %s have %s", testValue1, valueTyped)
+	}
+
+	value3 := st2.Value(testKeyNotFound)
+	if value3 != nil {
+		t.Error("unused key returned non-nil value")
+	}
+}
+
+func ValueNilPanicTest(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("nil key did not panic")
+		}
+	}()
+
+	_ = withValue(nil, "")
+}
 
 func ValueComparablePanicTest(t *testing.T) {
 	t.Parallel()
 
 	defer func() {
-		if r := recover(); r == nil {
-			t.Errorf("incomparable key did not panic")
+		if r := recover(); r == nil {
+			t.Errorf("incomparable key did not panic")
+		}
+	}()
+
+	_ = withValue(func() {}, "")
+}
+
+func ValueMutableTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		testKey  = key("test_key")
+		st1, set = WithMutableValue(testKey, "initial")
+		st2      = withWait(st1)
+	)
+
+	if value := st2.Value(testKey); value != "initial" {
+		t.Errorf("wrong test value: want initial have %v", value)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		for i := 0; i < 100; i++ {
+			set(i)
+		}
+
+		close(done)
+	}()
+
+	for i := 0; i < 100; i++ {
+		_ = st2.Value(testKey)
+	}
+
+	<-done
+
+	if value := st2.Value(testKey); value != 99 {
+		t.Errorf("wrong test value: want 99 have %v", value)
+	}
+}
+
+func ValueIndexTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		key1 = key("key1")
+		key2 = key("key2")
+		key3 = key("key3")
+
+		st1, set = WithMutableValue(key2, "mutable")
+		st2      = withValue(key1, "top", withValue(key1, "shadowed"))
+		st3      = withValue(key3, "dependency")
+		st4      = withValueIndex(merge(st2, st1).DependsOn(st3))
+	)
+
+	if value := st4.Value(key1); value != "top" {
+		t.Errorf("wrong indexed value: want top have %v", value)
+	}
+
+	if value := st4.Value(key3); value != "dependency" {
+		t.Errorf("wrong indexed value: want dependency have %v", value)
+	}
+
+	set("changed")
+
+	if value := st4.Value(key2); value != "changed" {
+		t.Errorf("wrong mutable value: want changed have %v", value)
+	}
+
+	if value := st4.Value(key("missing")); value != nil {
+		t.Errorf("index returned value for missing key: %v", value)
+	}
+}
+
+func ValueIndexNilShadowTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		key1 = key("key1")
+		key2 = key("key2")
+
+		// nil shadows values below it, but not values of its siblings
+		st = Merge(
+			WithValue(key1, nil, WithValue(key1, "shadowed"), WithValue(key2, "value2")),
+			WithValue(key1, "value1"),
+			WithValue(key2, nil, WithValue(key2, "shadowed")),
+		)
+		st1 = WithValue(key2, nil, WithValue(key2, "shadowed"))
+		idx = WithValueIndex(st)
+	)
+
+	for _, k := range []key{key1, key2} {
+		if v, want := idx.Value(k), st.Value(k); v != want {
+			t.Errorf("indexed value of %v %v differs from %v", k, v, want)
+		}
+	}
+
+	if v := idx.Value(key1); v != "value1" {
+		t.Errorf("unexpected value: %v", v)
+	}
+
+	if v := WithValueIndex(st1).Value(key2); v != nil {
+		t.Errorf("nil value doesn't shadow value below it: %v", v)
+	}
+}
+
+func ValueOrTest(t *testing.T) {
+	t.Parallel()
+
+	const (
+		key1 key = "key1"
+		key2 key = "key2"
+	)
+
+	st := WithValue(key1, "value1")
+
+	if v := ValueOr(st, key1, "def"); v != "value1" {
+		t.Errorf("wrong value, want '%v', have '%v'", "value1", v)
+	}
+
+	if v := ValueOr(st, key2, "def"); v != "def" {
+		t.Errorf("wrong default value, want '%v', have '%v'", "def", v)
+	}
+
+	if v := GetValueOr(st, key1, "def"); v != "value1" {
+		t.Errorf("wrong typed value, want '%v', have '%v'", "value1", v)
+	}
+
+	if v := GetValueOr(st, key2, "def"); v != "def" {
+		t.Errorf("wrong typed default value, want '%v', have '%v'", "def", v)
+	}
+
+	// value of another type
+	if v := GetValueOr(st, key1, 1); v != 1 {
+		t.Errorf("wrong typed default value for mismatched type, want '%v', have '%v'", 1, v)
+	}
+}
+
+func ValueTypedTest(t *testing.T) {
+	t.Parallel()
+
+	const (
+		key1 key = "key1"
+		key2 key = "key2"
+	)
+
+	st := WithValue(key1, 42)
+
+	if v, err := ValueTyped[int](st, key1); err != nil || v != 42 {
+		t.Errorf("wrong value, want 42, have '%v', error: %v", v, err)
+	}
+
+	v, err := ValueTyped[string](st, key1)
+	if !errors.Is(err, ErrValueType) || v != "" {
+		t.Errorf("wrong error, want '%v', have '%v'", ErrValueType, err)
+	}
+
+	if want := "state value of unexpected type: key key1: want string, have int"; err.Error() != want {
+		t.Errorf("wrong error, want '%s', have '%s'", want, err.Error())
+	}
+
+	if _, err := ValueTyped[int](st, key2); !errors.Is(err, ErrValueNotFound) {
+		t.Errorf("wrong error, want '%v', have '%v'", ErrValueNotFound, err)
+	}
+
+	// interface types
+	if _, err := ValueTyped[fmt.Stringer](st, key1); !errors.Is(err, ErrValueType) {
+		t.Errorf("wrong error, want '%v', have '%v'", ErrValueType, err)
+	}
+}
+
+func ValueKeysTest(t *testing.T) {
+	t.Parallel()
+
+	const (
+		key1 key = "key1"
+		key2 key = "key2"
+		key3 key = "key3"
+	)
+
+	st3, _ := WithMutableValue(key3, "value3")
+
+	st := WithValue(key1, "value1",
+		WithValue(key2, "value2", WithValue(key1, "shadowed")),
+		st3,
+	)
+
+	keys := ValueKeys(st)
+
+	if len(keys) != 3 || keys[0] != key1 || keys[1] != key2 || keys[2] != key3 {
+		t.Errorf("wrong keys, want %v, have %v", []key{key1, key2, key3}, keys)
+	}
+
+	if keys := ValueKeys(Empty()); keys != nil {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+}
+
+func ValueLocalTest(t *testing.T) {
+	t.Parallel()
+
+	const (
+		key1 key = "key1"
+		key2 key = "key2"
+	)
+
+	st2, _ := WithMutableValue(key2, "value2")
+	st := WithValue(key1, "value1", st2)
+
+	if v := LocalValue(st, key1); v != "value1" {
+		t.Errorf("wrong local value, want 'value1', have '%v'", v)
+	}
+
+	// inherited value
+	if v := LocalValue(st, key2); v != nil {
+		t.Errorf("unexpected local value: %v", v)
+	}
+
+	if v := LocalValue(st2, key2); v != "value2" {
+		t.Errorf("wrong local value, want 'value2', have '%v'", v)
+	}
+
+	if v := LocalValue(embeddedState{st}, key1); v != "value1" {
+		t.Errorf("wrong local value of embedded state, want 'value1', have '%v'", v)
+	}
+
+	if v := LocalValue(merge(st), key1); v != nil {
+		t.Errorf("unexpected local value of group: %v", v)
+	}
+}
+
+func ValueWaitTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		key1 = key("key1")
+		key2 = key("key2")
+
+		st1, set1 = WithMutableValue(key1, nil)
+		st        = Merge(WithValue(key2, "value2"), st1.DependsOn())
+		result    = make(chan interface{}, 1)
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if v, err := ValueWait(ctx, st, key2); err != nil || v != "value2" {
+		t.Errorf("unexpected value %v and error %v", v, err)
+	}
+
+	go func() {
+		v, err := ValueWait(ctx, st, key1)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		result <- v
+	}()
+
+	set1(nil)
+	set1("value1")
+
+	select {
+	case v := <-result:
+		if v != "value1" {
+			t.Errorf("unexpected value: %v", v)
+		}
+	case <-time.After(failTimeout):
+		t.Fatal("value wait didn't return after value is set")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), failTimeout/10)
+	defer cancel()
+
+	if _, err := ValueWait(ctx, st, key("key3")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+// ValueKeyRegistryTest is not parallel as the strict keys mode is global.
+func ValueKeyRegistryTest(t *testing.T) {
+	var (
+		key1 = new(int)
+		key2 = new(int)
+	)
+
+	if err := RegisterKey(key1); err != nil {
+		t.Errorf("register error: %v", err)
+	}
+
+	if err := RegisterKey(key1); !errors.Is(err, ErrKeyRegistered) {
+		t.Errorf("duplicate key is not reported: %v", err)
+	}
+
+	SetStrictKeys(true)
+	defer SetStrictKeys(false)
+
+	_ = WithValue(key1, "value1")
+	_ = WithRequestContext(context.Background())
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("unregistered key didn't cause panic in strict mode")
+		}
+	}()
+
+	_ = WithValue(key2, "value2")
+}
+
+// Annotate
+
+func AnnotationErrorTest(t *testing.T) {
+	t.Parallel()
+
+	const annotation = "test"
+
+	var (
+		err1 = errors.New("error1")
+		err2 = errors.New("error2")
+
+		st1 = withError(err1)
+		st2 = withError(err2, st1)
+		st3 = withAnnotation(annotation, st2)
+	)
+
+	err := st3.Err()
+
+	// err2 is higher in the tree so it must be found first
+	if !errors.Is(err, err2) {
+		t.Errorf("wrong error, want '%v', have '%v'", err2, err)
+	}
+
+	wantErrStr := fmt.Sprintf("%s: %s", annotation, err2.Error())
+
+	if err.Error() != wantErrStr {
+		t.Errorf("timeout error is not annotated, want error '%s', have '%s'", wantErrStr, err.Error())
+	}
+
+	err = st1.Err()
+	if !errors.Is(err, err1) {
+		t.Errorf("wrong error, want '%v', have '%v'", err1, err)
+	}
+}
+
+func AnnotationShutdownTimeoutTest(t *testing.T) {
+	t.Parallel()
+
+	const annotation = "test"
+
+	st1 := withShutdown()
+	st2 := withAnnotation(annotation, st1)
+
+	// blocked finish
+	_ = runShutdownable(st1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	err := st2.Shutdown(ctx)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("blocked shutdown didn't timeout")
+	}
+
+	wantErrStr := fmt.Sprintf("%s: %s", annotation, ErrTimeout.Error())
+
+	if err.Error() != wantErrStr {
+		t.Errorf("timeout error is not annotated, want error '%s', have '%s'", wantErrStr, err.Error())
+	}
+}
+
+func AnnotationChildShutdownTimeoutTest(t *testing.T) {
+	t.Parallel()
+
+	const annotation = "test"
+
+	st1 := withShutdown()
+	st2 := withAnnotation(annotation, st1)
+	st3 := withShutdown(st2)
+
+	// blocked finish
+	_ = runShutdownable(st1)
+	_ = runShutdownable(st3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	err := st3.Shutdown(ctx)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("blocked shutdown didn't timeout")
+	}
+
+	wantErrStr := fmt.Sprintf("%s: %s", annotation, ErrTimeout.Error())
+
+	if err.Error() != wantErrStr {
+		t.Errorf("timeout error is not annotated, want error '%s', have '%s'", wantErrStr, err.Error())
+	}
+}
+
+func AnnotationTimeoutErrorPathTest(t *testing.T) {
+	t.Parallel()
+
+	st1, drain := WithDrain()
+	st2 := withAnnotation("worker", st1)
+	st3 := withAnnotation("server", Empty(), st2)
+
+	drain.Add(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	err := st3.Shutdown(ctx)
+
+	var te *TimeoutError
+	if !errors.As(err, &te) {
+		t.Fatalf("shutdown error is not TimeoutError: %v", err)
+	}
+
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("TimeoutError doesn't unwrap to ErrTimeout")
+	}
+
+	var drainErr *DrainTimeoutError
+	if !errors.As(err, &drainErr) {
+		t.Errorf("TimeoutError doesn't unwrap to DrainTimeoutError")
+	}
+
+	if want := "server: worker"; te.Path() != want {
+		t.Errorf("wrong path, want '%s', have '%s'", want, te.Path())
+	}
+
+	if want := "server: worker: " + drainErr.Error(); err.Error() != want {
+		t.Errorf("wrong error, want '%s', have '%s'", want, err.Error())
+	}
+
+	drain.Done()
+}
+
+func AnnotationNilErrorTest(t *testing.T) {
+	t.Parallel()
+
+	const message = "test"
+
+	st1 := withError(nil)
+	st2 := withAnnotation(message, st1)
+
+	if err := st2.Err(); err != nil {
+		t.Errorf("annotated state returned error instead of nil")
+	}
+}
+
+func AnnotationNilShutdownErrorTest(t *testing.T) {
+	t.Parallel()
+
+	st1 := withShutdown()
+	st2 := withAnnotation("", st1)
+
+	// blocked finish
+	okDone1 := runShutdownable(st1)
+
+	closeChanAndPropagate(okDone1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := st2.Shutdown(ctx); err != nil {
+		t.Errorf("annotation state returned error instead of nil")
+	}
+}
+
+func AnnotationUnclosedTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withAnnotation("", st1)
+	)
+
+	okDone1 := runShutdownable(st1)
+
+	closeChanAndPropagate(okDone1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	err := st2.Shutdown(ctx)
+	if err != nil {
+		t.Errorf(errTimeout)
+	}
+
+	if err := st1.cause(); err != nil {
+		t.Errorf(errNotFinished)
+	}
+
+	if err := st2.cause(); err != nil {
+		t.Errorf(errNotFinished)
+	}
+}
+
+func AnnotationDynamicTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error 1")
+		err2 = errors.New("error 2")
+
+		st1, errTail1 = WithErrorGroup()
+		st2, errTail2 = WithErrorGroupPriority(1)
+		st            = WithAnnotation("first", st1, st2)
+	)
+
+	if err := st.Err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	errTail1.Error(err1)
+
+	if err := st.Err(); err == nil || err.Error() != "first: error 1" {
+		t.Errorf("annotation doesn't reflect child's error: %v", err)
+	}
+
+	errTail2.Error(err2)
+
+	if err := st.Err(); err == nil || err.Error() != "first: error 2" {
+		t.Errorf("annotation doesn't reflect child's latest error: %v", err)
+	}
+
+	if !SetAnnotation(st, "second") {
+		t.Errorf("annotation state is not recognized")
+	}
+
+	if err := st.Err(); err == nil || err.Error() != "second: error 2" {
+		t.Errorf("annotation is not replaced: %v", err)
+	}
+
+	if SetAnnotation(Merge(), "third") {
+		t.Errorf("annotation is set on group")
+	}
+}
+
+func AnnotationFormatTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error 1")
+
+		st1 = withShutdown()
+		st  = WithAnnotationFormat("db: %w (100%% primary)", WithError(err1), st1)
+		_   = runShutdownable(st1)
+	)
+
+	if err := st.Err(); err == nil || err.Error() != "db: error 1 (100% primary)" || !errors.Is(err, err1) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	err := st.Shutdown(ctx)
+	if err == nil || err.Error() != "db: "+ErrTimeout.Error()+" (100% primary)" || !errors.Is(err, ErrTimeout) {
+		t.Errorf("unexpected shutdown error: %v", err)
+	}
+
+	if err := WithAnnotationFormat("%w").Err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, format := range []string{"db", "%w %w", "%s: %w", "%w %", "%%w"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("invalid format %q didn't panic", format)
+				}
+			}()
+
+			WithAnnotationFormat(format)
+		}()
+	}
+}
+
+// Error
+
+func ErrorTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error1")
+		err2 = errors.New("error2")
+
+		st1 = withError(err1)
+		st2 = withError(err2, st1)
+	)
+
+	err := st2.Err()
+
+	// err2 is higher in the tree so it must be found first
+	if !errors.Is(err, err2) {
+		t.Errorf("wrong error, want '%v', have '%v'", err2, err)
+	}
+
+	err = st1.Err()
+	if !errors.Is(err, err1) {
+		t.Errorf("wrong error, want '%v', have '%v'", err1, err)
+	}
+}
+
+func ErrorDeepestTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error1")
+		err2 = errors.New("error2")
+
+		st1 = withError(err1)
+		st2 = withAnnotation("a", withAnnotation("b", withError(err2)))
+		st3 = withDependency(st1, st2)
+	)
+
+	if err := st3.Err(); !errors.Is(err, err1) {
+		t.Errorf("wrong error, want '%v', have '%v'", err1, err)
+	}
+
+	err := ErrDeepest(st3)
+	if !errors.Is(err, err2) {
+		t.Errorf("wrong deepest error, want '%v', have '%v'", err2, err)
+	}
+
+	if want := "a: b: error2"; err.Error() != want {
+		t.Errorf("deepest error is not annotated, want '%s', have '%s'", want, err.Error())
+	}
+}
+
+func ErrorDeepestNilTest(t *testing.T) {
+	t.Parallel()
+
+	st := merge(withAnnotation("a", emptyState{}), withWait())
+
+	if err := ErrDeepest(st); err != nil {
+		t.Errorf("state without errors returned deepest error '%v'", err)
+	}
+}
+
+type embeddedState struct {
+	State
+}
+
+func ErrorDeepestEmbeddedTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error1")
+		st1  = embeddedState{State: withAnnotation("a", withError(err1))}
+		st2  = merge(st1)
+	)
+
+	err := ErrDeepest(st2)
+	if err == nil || err.Error() != "a: error1" {
+		t.Errorf("wrong deepest error, want 'a: error1', have '%v'", err)
+	}
+}
+
+func ErrorSourceTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error1")
+		err2 = errors.New("error2")
+
+		st1 = withError(err1)
+		st2 = WithErrorPriority(err2, 1)
+		st3 = withError(nil, withError(errors.New("hidden")))
+		st  = merge(st3, withAnnotation("a", withAnnotation("b", st1)), st2)
+	)
+
+	source, err := ErrSource(st)
+	if err == nil || err.Error() != st.Err().Error() {
+		t.Errorf("wrong error, want '%v', have '%v'", st.Err(), err)
+	}
+
+	if source != st2 || !errors.Is(err, err2) {
+		t.Errorf("wrong source of the prioritized error")
+	}
+
+	source, err = ErrSource(merge(st3, withAnnotation("a", withAnnotation("b", st1))))
+	if err == nil || err.Error() != "a: b: error1" {
+		t.Errorf("wrong error, want 'a: b: error1', have '%v'", err)
+	}
+
+	if source != st1 {
+		t.Errorf("wrong source, want the innermost error state")
+	}
+
+	if source, err := ErrSource(withWait()); source != nil || err != nil {
+		t.Errorf("state without errors returned source '%v' and error '%v'", source, err)
+	}
+
+	st4 := withError(errors.New("error4"))
+
+	source, err = ErrSource(withCloseTimeout(time.Second, st4))
+	if source != st4 || err == nil || err.Error() != "error4" {
+		t.Errorf("wrong source of the close timeout child's error: '%v'", err)
+	}
+}
+
+func ErrorAllTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		sentinel = errors.New("sentinel")
+
+		st1 = withError(sentinel)
+		st2 = withError(errors.New("error"))
+		st3 = withError(errors.New("error"))
+		st4 = merge(withAnnotation("a", st1), withAnnotation("b", st1), st2, st3, withError(sentinel))
+	)
+
+	errs := AllErrors(st4)
+
+	if len(errs) != 3 {
+		t.Fatalf("wrong number of errors: want 3, have %d: %v", len(errs), errs)
+	}
+
+	if !errors.Is(errs[0], sentinel) || errs[0].Error() != "a: sentinel" {
+		t.Errorf("wrong error, want 'a: sentinel', have '%v'", errs[0])
+	}
+
+	if errors.Is(errs[1], errs[2]) {
+		t.Errorf("distinct errors with equal messages were deduplicated")
+	}
+
+	if errs := AllErrors(withWait()); errs != nil {
+		t.Errorf("state without errors returned errors: %v", errs)
+	}
+}
+
+func ErrorAllAnnotatedTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		sentinel = errors.New("sentinel")
+
+		st1 = withError(sentinel)
+		st2 = merge(
+			withError(sentinel),
+			withAnnotation("a", st1),
+			withAnnotation("b", withAnnotation("c", st1)),
+			withError(fmt.Errorf("wrapped: %w", sentinel)),
+		)
+	)
+
+	errs := AllErrors(st2)
+
+	if len(errs) != 1 {
+		t.Fatalf("wrong number of errors: want 1, have %d: %v", len(errs), errs)
+	}
+
+	if want := "b: c: sentinel"; errs[0].Error() != want {
+		t.Errorf("wrong error, want '%s', have '%v'", want, errs[0])
+	}
+}
+
+func ErrorPriorityTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("incidental")
+		err2 = errors.New("critical")
+		err3 = errors.New("other")
+	)
+
+	errSt, errTail := WithErrorGroupPriority(10)
+	st := Merge(
+		WithAnnotation("first", WithError(err1)),
+		WithAnnotation("second", WithErrorPriority(err3, 5), errSt),
+	)
+
+	if err := st.Err(); !errors.Is(err, err3) {
+		t.Errorf("wrong error, want '%v', have '%v'", err3, err)
+	}
+
+	errTail.Error(err2)
+
+	err := st.Err()
+	if !errors.Is(err, err2) {
+		t.Errorf("wrong error, want '%v', have '%v'", err2, err)
+	}
+
+	if want := "second: critical"; err.Error() != want {
+		t.Errorf("wrong error message, want '%s', have '%s'", want, err.Error())
+	}
+
+	// equal priorities - first wins
+	st = Merge(WithErrorPriority(err1, 1), WithErrorPriority(err2, 1))
+	if err := st.DependsOn(WithErrorPriority(err3, 1)).Err(); !errors.Is(err, err1) {
+		t.Errorf("wrong error with equal priorities, want '%v', have '%v'", err1, err)
+	}
+}
+
+func ErrStableTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error 1")
+		err2 = errors.New("error 2")
+
+		st1, errTail1 = WithErrorGroup()
+		st2, errTail2 = WithErrorGroupPriority(1)
+		st            = Merge(st1, st2)
+		dep           = st1.DependsOn(st2)
+	)
+
+	if err := ErrStable(st); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	errTail1.Error(err1)
+
+	if err := ErrStable(st); err != err1 {
+		t.Errorf("expected %v, got %v", err1, err)
+	}
+
+	if err := ErrStable(dep); err != err1 {
+		t.Errorf("expected %v, got %v", err1, err)
+	}
+
+	errTail2.Error(err2)
+
+	if err := st.Err(); !errors.Is(err, err2) {
+		t.Errorf("expected %v, got %v", err2, err)
+	}
+
+	for _, s := range []State{st, dep} {
+		if err := ErrStable(s); err != err1 {
+			t.Errorf("stable error changed: %v", err)
+		}
+	}
+
+	if err := ErrStable(Empty()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// the state is embedded into another struct
+	var (
+		st3, errTail3 = WithErrorGroup()
+		st4, errTail4 = WithErrorGroupPriority(1)
+		embedded      = struct{ State }{Merge(st3, st4)}
+	)
+
+	errTail3.Error(err1)
+
+	if err := ErrStable(embedded); err != err1 {
+		t.Errorf("expected %v, got %v", err1, err)
+	}
+
+	errTail4.Error(err2)
+
+	if err := ErrStable(embedded); err != err1 {
+		t.Errorf("stable error of embedded state changed: %v", err)
+	}
+}
+
+func ErrorCollectTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error 1")
+		err2 = errors.New("error 2")
+	)
+
+	st := MergeCollectErrors(
+		WithError(err1),
+		Empty(),
+		WithAnnotation("second", WithError(err2)),
+	)
+
+	err := st.Err()
+	if !errors.Is(err, err1) || !errors.Is(err, err2) {
+		t.Errorf("not all errors collected: %v", err)
+	}
+
+	if want := "error 1\nsecond: error 2"; err.Error() != want {
+		t.Errorf("wrong error, want '%s', have '%s'", want, err.Error())
+	}
+
+	if err := MergeCollectErrors(Empty(), WithAnnotation("test")).Err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// Error group
+
+func ErrorGroupTest(t *testing.T) {
+	t.Parallel()
+
+	const annotation = "test"
+
+	var (
+		err1 = errors.New("error1")
+		err2 = errors.New("error2")
+		st1  = withErrorGroup()
+		st2  = withAnnotation(annotation, st1)
+	)
+
+	if err := st2.Err(); err != nil {
+		t.Errorf("new error group state returned error")
+	}
+
+	go func() {
+		st1.Error(err1)
+	}()
+
+	time.Sleep(failTimeout)
+
+	err := st2.Err()
+
+	if !errors.Is(err, err1) {
+		t.Errorf("wrong error, want '%v', have '%v'", err1, err)
+	}
+
+	wantErrStr := fmt.Sprintf("%s: %s", annotation, err1.Error())
+
+	if err.Error() != wantErrStr {
+		t.Errorf("error group error is not annotated, want '%s', have '%s'", wantErrStr, err.Error())
+	}
+
+	go func() {
+		st1.Error(err2)
+	}()
+
+	time.Sleep(failTimeout)
+
+	err = st2.Err()
+
+	if errors.Is(err, err2) {
+		t.Errorf("wrong error, want '%v', have '%v'", err1, err2)
+	}
+}
+
+func ErrorGroupErrorfTest(t *testing.T) {
+	t.Parallel()
+
+	const annotation = "test: %w"
+
+	var (
+		err1 = errors.New("error1")
+		err2 = errors.New("error2")
+		st1  = withErrorGroup()
+		st2  = merge(st1)
+	)
+
+	if err := st2.Err(); err != nil {
+		t.Errorf("new error group state returned error")
+	}
+
+	go func() {
+		st1.Errorf(annotation, err1)
+	}()
+
+	time.Sleep(failTimeout)
+
+	err := st2.Err()
+
+	if !errors.Is(err, err1) {
+		t.Errorf("wrong error, want '%v', have '%v'", err1, err)
+	}
+
+	wantErrStr := fmt.Sprintf("%s: %s", "test", err1.Error())
+
+	if err.Error() != wantErrStr {
+		t.Errorf("error group error is not annotated, want '%s', have '%s'", wantErrStr, err.Error())
+	}
+
+	go func() {
+		st1.Errorf(annotation, err2)
+	}()
+
+	time.Sleep(failTimeout)
+
+	err = st2.Err()
+
+	if errors.Is(err, err2) {
+		t.Errorf("wrong error, want '%v', have '%v'", err1, err2)
+	}
+}
+
+func ErrorGroupAttrsTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error1")
+		st1  = withErrorGroup()
+		st2  = WithAnnotation("a", st1)
+	)
+
+	ErrorWith(st1, nil, slog.Int("id", 1))
+
+	if err := st2.Err(); err != nil {
+		t.Errorf("nil error was assigned: %v", err)
+	}
+
+	ErrorWith(st1, err1, slog.Int("id", 1), slog.String("op", "write"))
+
+	err := st2.Err()
+	if !errors.Is(err, err1) || err.Error() != "a: error1" {
+		t.Errorf("wrong error, want 'a: error1', have '%v'", err)
+	}
+
+	attrs := ErrorAttrs(err)
+	if len(attrs) != 2 || !attrs[0].Equal(slog.Int("id", 1)) || !attrs[1].Equal(slog.String("op", "write")) {
+		t.Errorf("wrong attributes: %v", attrs)
+	}
+
+	var ae *AttrError
+	if !errors.As(err, &ae) {
+		t.Fatalf("error is not AttrError")
+	}
+
+	var buf strings.Builder
+
+	slog.New(slog.NewTextHandler(&buf, nil)).Error("failed", slog.Any("err", ae))
+
+	if want := "err.msg=error1 err.id=1 err.op=write"; !strings.Contains(buf.String(), want) {
+		t.Errorf("wrong log record, want it to contain '%s', have '%s'", want, buf.String())
+	}
+
+	if attrs := ErrorAttrs(err1); attrs != nil {
+		t.Errorf("unexpected attributes: %v", attrs)
+	}
+}
+
+func ErrorGroupSharedTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error1")
+
+		st1, tail1 = MergeWithErrorGroup(withShutdown(), withWait())
+		st2, tail2 = MergeWithErrorGroup(WithAnnotation("subsystem", st1), withWait())
+	)
+
+	if tail1 != tail2 {
+		t.Errorf("shared error group was not reused")
+	}
+
+	tail, ok := SharedErrTail(st1)
+	if !ok || tail != tail1 {
+		t.Fatalf("shared tail not found")
+	}
+
+	if err := st2.Err(); err != nil {
+		t.Errorf("new shared error group returned error: %v", err)
+	}
+
+	tail.Error(err1)
+
+	if err := st2.Err(); err == nil || err.Error() != "subsystem: error1" {
+		t.Errorf("wrong error, want 'subsystem: error1', have '%v'", err)
+	}
+
+	if _, ok := SharedErrTail(withWait()); ok {
+		t.Errorf("unexpected shared tail")
+	}
+}
+
+func ErrorGroupMultiTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error1")
+		err2 = errors.New("error2")
+
+		st1, tail1 = WithErrorGroupMulti(2)
+		st         = WithAnnotation("a", st1)
+	)
+
+	if err := st.Err(); err != nil {
+		t.Errorf("new error group returned error: %v", err)
+	}
+
+	tail1.Error(err1)
+	tail1.Error(nil)
+	tail1.Error(err2)
+
+	for i := 0; i < 3; i++ {
+		tail1.Errorf("error%d", i+3)
+	}
+
+	err := st.Err()
+	if !errors.Is(err, err1) || !errors.Is(err, err2) {
+		t.Errorf("kept errors are missing: %v", err)
+	}
+
+	if want := "a: error1\nerror2\n3 more errors dropped"; err.Error() != want {
+		t.Errorf("wrong error, want '%s', have '%s'", want, err.Error())
+	}
+
+	if errs := ErrSnapshot(st); len(errs) != 2 {
+		t.Errorf("wrong number of errors in snapshot: want 2, have %d", len(errs))
+	}
+}
+
+func ErrorGroupOnErrorTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		err1 = errors.New("error 1")
+		err2 = errors.New("error 2")
+		err3 = errors.New("error 3")
+
+		st1, errTail1 = WithErrorGroup()
+		st2, errTail2 = WithErrorGroupMulti(1)
+
+		got1, got2 []error
+	)
+
+	OnError(errTail1, func(err error) {
+		// the state is not locked
+		if st1.Err() != err {
+			t.Errorf("unexpected error of the state: %v", st1.Err())
+		}
+
+		got1 = append(got1, err)
+	})
+
+	errTail1.Error(err1)
+	errTail1.Error(err2)
+
+	if len(got1) != 1 || got1[0] != err1 {
+		t.Errorf("unexpected errors observed: %v", got1)
+	}
+
+	OnError(errTail1, func(err error) { got1 = append(got1, err) })
+
+	if len(got1) != 2 || got1[1] != err1 {
+		t.Errorf("assigned error is not observed: %v", got1)
+	}
+
+	errTail2.Error(err1)
+	OnError(errTail2, func(err error) {
+		_ = st2.Err()
+		got2 = append(got2, err)
+	})
+	errTail2.Error(err2)
+	errTail2.Error(err3)
+
+	if len(got2) != 3 || got2[0] != err1 || got2[1] != err2 || got2[2] != err3 {
+		t.Errorf("unexpected errors observed: %v", got2)
+	}
+
+	if OnError(struct{ ErrTail }{errTail1}, func(error) {}) {
+		t.Error("callback is registered on a tail implemented outside of the package")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("nil callback didn't panic")
 		}
 	}()
 
-	_ = withValue(func() {}, "")
+	OnError(errTail1, nil)
 }
 
-// Annotate
+func ErrorGroupSnapshotTest(t *testing.T) {
+	t.Parallel()
 
-func AnnotationErrorTest(t *testing.T) {
+	var (
+		err1 = errors.New("error1")
+		err2 = errors.New("error2")
+		st1  = withErrorGroup()
+		st2  = withErrorGroup()
+		st3  = merge(withAnnotation("a", st1), st2, withError(errors.New("not a group")))
+	)
+
+	if errs := ErrSnapshot(st3); len(errs) != 0 {
+		t.Errorf("new error groups returned errors: %v", errs)
+	}
+
+	st1.Error(err1)
+	st2.Error(err2)
+
+	errs := ErrSnapshot(st3)
+	if len(errs) != 2 {
+		t.Fatalf("wrong number of errors: want 2, have %d", len(errs))
+	}
+
+	if !errors.Is(errs[0], err1) || errs[0].Error() != "a: error1" {
+		t.Errorf("wrong error, want 'a: error1', have '%v'", errs[0])
+	}
+
+	if !errors.Is(errs[1], err2) {
+		t.Errorf("wrong error, want '%v', have '%v'", err2, errs[1])
+	}
+}
+
+func ErrorGroupSuperviseTest(t *testing.T) {
 	t.Parallel()
 
-	const annotation = "test"
+	type child struct {
+		st   State
+		tail ErrTail
+	}
+
+	starter := func(started chan<- child) func() State {
+		return func() State {
+			st, tail := WithErrorGroup()
+			started <- child{st, tail}
+
+			return st
+		}
+	}
+
+	var (
+		started1 = make(chan child, 10)
+		started2 = make(chan child, 10)
+
+		st = Supervise(RestartStrategy{Mode: OneForOne, MaxRestarts: 1},
+			starter(started1), starter(started2))
+
+		c1 = <-started1
+		c2 = <-started2
+	)
+
+	c1.tail.Error(errors.New("error1"))
+
+	restarted := <-started1
+
+	// failed child is shut down, the other one is untouched
+	if hasNotClosed(c1.st.(*errGroupState).finishSig()) {
+		t.Error(errNotFinished)
+	}
+
+	if len(started2) != 0 {
+		t.Error("child was restarted on failure of its sibling")
+	}
+
+	if err := st.Err(); err != nil {
+		t.Errorf("restarted child error was reported: %v", err)
+	}
+
+	// restarts limit is exceeded
+	restarted.tail.Error(errors.New("error2"))
+
+	select {
+	case <-st.(*supervisorState).errSig():
+	case <-time.After(failTimeout):
+		t.Fatal(errTimeout)
+	}
+
+	if err := st.Err(); err == nil || err.Error() != "error2" {
+		t.Errorf("wrong error, want 'error2', have '%v'", err)
+	}
+
+	if len(started1) != 0 {
+		t.Error("child was restarted after the limit was exceeded")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
+
+	if err := st.Shutdown(ctx); err != nil {
+		t.Errorf("supervisor shutdown error: %v", err)
+	}
+
+	if hasNotClosed(c2.st.(*errGroupState).finishSig()) {
+		t.Error(errNotFinished)
+	}
+
+	// all for one
+	var (
+		started3 = make(chan child, 10)
+		started4 = make(chan child, 10)
+
+		st2 = Supervise(RestartStrategy{Mode: AllForOne, MaxRestarts: 1},
+			starter(started3), starter(started4))
+
+		_  = <-started3
+		c4 = <-started4
+	)
+
+	c4.tail.Error(errors.New("error4"))
+
+	for _, started := range []chan child{started3, started4} {
+		select {
+		case <-started:
+		case <-time.After(failTimeout):
+			t.Fatal(errTimeout)
+		}
+	}
+
+	if err := st2.Shutdown(ctx); err != nil {
+		t.Errorf("supervisor shutdown error: %v", err)
+	}
+}
+
+// Categorized errors
+
+func CategoryTest(t *testing.T) {
+	t.Parallel()
 
 	var (
 		err1 = errors.New("error1")
 		err2 = errors.New("error2")
+		err3 = errors.New("error3")
 
-		st1 = withError(err1)
-		st2 = withError(err2, st1)
-		st3 = withAnnotation(annotation, st2)
+		st1 = withCategorizedErrors()
+		st2 = withCategorizedErrors()
+		st3 = merge(withAnnotation("a", st1), st2)
 	)
 
-	err := st3.Err()
+	if err := st3.Err(); err != nil {
+		t.Errorf("new categorized errors state returned error")
+	}
 
-	// err2 is higher in the tree so it must be found first
-	if !errors.Is(err, err2) {
-		t.Errorf("wrong error, want '%v', have '%v'", err2, err)
+	st1.Error("network", err1)
+	st1.Error("validation", err2)
+	st1.Error("network", nil)
+	st2.Error("network", err3)
+
+	if err := st3.Err(); !errors.Is(err, err1) {
+		t.Errorf("wrong error, want '%v', have '%v'", err1, err)
 	}
 
-	wantErrStr := fmt.Sprintf("%s: %s", annotation, err2.Error())
+	m := ErrorsByCategory(st3)
 
-	if err.Error() != wantErrStr {
-		t.Errorf("timeout error is not annotated, want error '%s', have '%s'", wantErrStr, err.Error())
+	if len(m) != 2 {
+		t.Fatalf("wrong number of categories: want 2, have %d", len(m))
 	}
 
-	err = st1.Err()
-	if !errors.Is(err, err1) {
-		t.Errorf("wrong error, want '%v', have '%v'", err1, err)
+	network := m["network"]
+	if len(network) != 2 || !errors.Is(network[0], err1) || !errors.Is(network[1], err3) {
+		t.Errorf("wrong network errors: %v", network)
+	}
+
+	if network[0].Error() != "a: error1" {
+		t.Errorf("categorized error is not annotated, want 'a: error1', have '%s'", network[0])
+	}
+
+	if validation := m["validation"]; len(validation) != 1 || !errors.Is(validation[0], err2) {
+		t.Errorf("wrong validation errors: %v", validation)
 	}
 }
 
-func AnnotationShutdownTimeoutTest(t *testing.T) {
+// Empty
+
+func EmptyTest(t *testing.T) {
+	t.Parallel()
+
+	st1 := emptyState{}
+
+	if err := st1.Err(); err != nil {
+		t.Errorf("empty state returned error")
+	}
+
+	if err := st1.Shutdown(context.Background()); err != nil {
+		t.Errorf("empty state shutdowned with error")
+	}
+
+	okDone1 := make(chan struct{})
+
+	go func() {
+		st1.Wait()
+		close(okDone1)
+	}()
+
+	time.Sleep(failTimeout)
+
+	if hasNotClosed(okDone1) {
+		t.Errorf("empty state blocked on wait")
+	}
+
+	readyC := st1.Ready()
+	if hasNotClosed(readyC) {
+		t.Errorf("empty state is not ready")
+	}
+
+	if value := st1.Value(""); value != nil {
+		t.Errorf("empty state returned value")
+	}
+
+	okDone2 := make(chan struct{})
+
+	go func() {
+		st1.close()
+		close(okDone2)
+	}()
+
+	time.Sleep(failTimeout)
+
+	if hasNotClosed(okDone2) {
+		t.Errorf("empty state blocked on close")
+	}
+
+	if hasNotClosed(st1.finishSig()) {
+		t.Errorf("empty state is not done")
+	}
+
+	if err := st1.cause(); err != nil {
+		t.Errorf("empty state cause call returned error")
+	}
+}
+
+// Dependency
+
+func DependencyShutdownTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st3 = withShutdown()
+
+		okDone1 = runShutdownable(st1)
+		okDone2 = runShutdownable(st2)
+		okDone3 = runShutdownable(st3)
+	)
+
+	st4 := withDependency(st3, st1, st2)
+
+	if hasClosed(
+		st1.end, st2.end, st3.end,
+		st1.done, st2.done, st3.done,
+	) {
+		t.Error(errInitClosed)
+	}
+
+	go st4.close()
+	time.Sleep(failTimeout)
+
+	switch {
+	case hasNotClosed(st1.end, st2.end):
+		t.Error(errNotClosed)
+	case hasClosed(st3.end):
+		t.Error(errClosed)
+	case hasClosed(st1.done, st2.done, st3.done):
+		t.Error(errFinished)
+	}
+
+	closeChanAndPropagate(okDone1, okDone2)
+
+	switch {
+	case hasNotClosed(st3.end):
+		t.Error(errNotClosed)
+	case hasNotClosed(st1.done, st2.done):
+		t.Error(errNotFinished)
+	case hasClosed(st3.done):
+		t.Error(errFinished)
+	}
+
+	closeChanAndPropagate(okDone3)
+
+	if hasNotClosed(st3.done) {
+		t.Error(errNotFinished)
+	}
+}
+
+func DependencyShutdownChainTest(t *testing.T) {
 	t.Parallel()
 
-	const annotation = "test"
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st3 = withShutdown()
+
+		okDone1 = runShutdownable(st1)
+		okDone2 = runShutdownable(st2)
+		okDone3 = runShutdownable(st3)
+
+		steps = newStepper(t, map[string]State{"st1": st1, "st2": st2, "st3": st3})
+	)
+
+	st4 := withDependency(st3, st1)
+	st4 = withDependency(st4, st2)
+
+	if hasClosed(
+		st1.end, st2.end, st3.end,
+		st1.done, st2.done, st3.done, st4.finished,
+	) {
+		t.Error(errInitClosed)
+	}
+
+	go st4.close()
+
+	// states are shut down one by one, a state reaches the closing phase
+	// only after the previous one is closed
+	steps.next(st2, ShutdownClosing)
+	close(okDone2)
+	steps.next(st2, ShutdownClosed)
+
+	steps.next(st1, ShutdownClosing)
+	close(okDone1)
+	steps.next(st1, ShutdownClosed)
+
+	steps.next(st3, ShutdownClosing)
+
+	if hasClosed(st4.finished) {
+		t.Error(errFinished)
+	}
+
+	close(okDone3)
+	steps.next(st3, ShutdownClosed)
+
+	await(t, st4.finished)
+}
+
+func DependencyShutdownSuccessiveCloseTest(t *testing.T) {
+	t.Parallel()
 
-	st1 := withShutdown()
-	st2 := withAnnotation(annotation, st1)
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("successive close call caused panic")
+		}
+	}()
 
-	// blocked finish
-	_ = runShutdownable(st1)
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+	)
 
-	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
-	defer cancel()
+	close(runShutdownable(st1))
+	close(runShutdownable(st2))
 
-	err := st2.Shutdown(ctx)
-	if !errors.Is(err, ErrTimeout) {
-		t.Errorf("blocked shutdown didn't timeout")
-	}
+	st4 := withDependency(st1, st2)
 
-	wantErrStr := fmt.Sprintf("%s: %s", annotation, ErrTimeout.Error())
+	go st4.close()
+	time.Sleep(failTimeout)
 
-	if err.Error() != wantErrStr {
-		t.Errorf("timeout error is not annotated, want error '%s', have '%s'", wantErrStr, err.Error())
-	}
+	st4.close()
 }
 
-func AnnotationChildShutdownTimeoutTest(t *testing.T) {
+func DependencyShutdownChildrenTimeoutTest(t *testing.T) {
 	t.Parallel()
 
-	const annotation = "test"
-
-	st1 := withShutdown()
-	st2 := withAnnotation(annotation, st1)
-	st3 := withShutdown(st2)
+	var (
+		st1 = withShutdown()
+		st2 = emptyState{}
+		st3 = st1.DependsOn(st2)
+	)
 
 	// blocked finish
 	_ = runShutdownable(st1)
-	_ = runShutdownable(st3)
 
 	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
 	defer cancel()
@@ -728,259 +5421,294 @@ func AnnotationChildShutdownTimeoutTest(t *testing.T) {
 	if !errors.Is(err, ErrTimeout) {
 		t.Errorf("blocked shutdown didn't timeout")
 	}
-
-	wantErrStr := fmt.Sprintf("%s: %s", annotation, ErrTimeout.Error())
-
-	if err.Error() != wantErrStr {
-		t.Errorf("timeout error is not annotated, want error '%s', have '%s'", wantErrStr, err.Error())
-	}
-}
-
-func AnnotationNilErrorTest(t *testing.T) {
-	t.Parallel()
-
-	const message = "test"
-
-	st1 := withError(nil)
-	st2 := withAnnotation(message, st1)
-
-	if err := st2.Err(); err != nil {
-		t.Errorf("annotated state returned error instead of nil")
-	}
 }
 
-func AnnotationNilShutdownErrorTest(t *testing.T) {
+func DependencyShutdownParentTimeoutTest(t *testing.T) {
 	t.Parallel()
 
-	st1 := withShutdown()
-	st2 := withAnnotation("", st1)
+	var (
+		st1 = withShutdown()
+		st2 = withShutdown()
+		st3 = st1.DependsOn(st2)
+	)
 
-	// blocked finish
 	okDone1 := runShutdownable(st1)
+	_ = runShutdownable(st2)
 
 	closeChanAndPropagate(okDone1)
 
 	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
 	defer cancel()
 
-	if err := st2.Shutdown(ctx); err != nil {
-		t.Errorf("annotation state returned error instead of nil")
+	err := st3.Shutdown(ctx)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("blocked shutdown didn't timeout")
 	}
 }
 
-func AnnotationUnclosedTest(t *testing.T) {
+func DependencyShutdownUnclosedTest(t *testing.T) {
 	t.Parallel()
 
 	var (
 		st1 = withShutdown()
-		st2 = withAnnotation("", st1)
+		st2 = withShutdown()
+		st3 = withDependency(st1, st2)
 	)
 
+	// blocked finish
+	okDone2 := runShutdownable(st2)
 	okDone1 := runShutdownable(st1)
 
-	closeChanAndPropagate(okDone1)
+	closeChanAndPropagate(okDone1, okDone2)
 
 	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
 	defer cancel()
 
-	err := st2.Shutdown(ctx)
+	err := st3.Shutdown(ctx)
 	if err != nil {
 		t.Errorf(errTimeout)
 	}
 
-	if err := st1.cause(); err != nil {
-		t.Errorf(errNotFinished)
-	}
-
-	if err := st2.cause(); err != nil {
+	if err := st3.cause(); err != nil {
 		t.Errorf(errNotFinished)
 	}
 }
 
-// Error
-
-func ErrorTest(t *testing.T) {
+func DependencyWaitTest(t *testing.T) {
 	t.Parallel()
 
 	var (
-		err1 = errors.New("error1")
-		err2 = errors.New("error2")
+		st1 = withWait()
+		st2 = withWait()
+		st3 = withWait()
 
-		st1 = withError(err1)
-		st2 = withError(err2, st1)
+		okDone1 = runWaitable(st1)
+		okDone2 = runWaitable(st2)
+		okDone3 = runWaitable(st3)
+
+		st4 = st3.DependsOn(st1, st2)
 	)
 
-	err := st2.Err()
+	done := make(chan struct{})
 
-	// err2 is higher in the tree so it must be found first
-	if !errors.Is(err, err2) {
-		t.Errorf("wrong error, want '%v', have '%v'", err2, err)
+	go func() {
+		st4.Wait()
+		close(done)
+	}()
+
+	time.Sleep(failTimeout)
+
+	if hasClosed(done) {
+		t.Error(errNotWaited)
 	}
 
-	err = st1.Err()
-	if !errors.Is(err, err1) {
-		t.Errorf("wrong error, want '%v', have '%v'", err1, err)
+	closeChanAndPropagate(okDone1, okDone2)
+
+	if hasClosed(done) {
+		t.Error(errNotWaited)
 	}
-}
 
-// Error group
+	closeChanAndPropagate(okDone3)
 
-func ErrorGroupTest(t *testing.T) {
-	t.Parallel()
+	if hasNotClosed(done) {
+		t.Error(errNotWaited)
+	}
+}
 
-	const annotation = "test"
+func DependencyReadinessTest(t *testing.T) {
+	t.Parallel()
 
 	var (
-		err1 = errors.New("error1")
-		err2 = errors.New("error2")
-		st1  = withErrorGroup()
-		st2  = withAnnotation(annotation, st1)
+		st1 = withReadiness()
+		st2 = withReadiness()
+		st3 = withReadiness()
 	)
 
-	if err := st2.Err(); err != nil {
-		t.Errorf("new error group state returned error")
+	st4 := withDependency(st3, st1, st2)
+
+	if hasClosed(
+		st1.ready, st2.ready, st3.ready,
+	) {
+		t.Error(errInitReady)
 	}
 
-	go func() {
-		st1.Error(err1)
-	}()
+	st1ReadyC := st1.Ready()
+	st2ReadyC := st2.Ready()
+	st3ReadyC := st3.Ready()
+	st4ReadyC := st4.Ready()
 
-	time.Sleep(failTimeout)
+	st1.Ok()
 
-	err := st2.Err()
+	time.Sleep(failTimeout)
 
-	if !errors.Is(err, err1) {
-		t.Errorf("wrong error, want '%v', have '%v'", err1, err)
+	switch {
+	case hasNotClosed(st1.ready, st1ReadyC):
+		t.Error(errNotReady)
+	case hasClosed(st2.ready, st3.ready, st2ReadyC, st3ReadyC, st4ReadyC):
+		t.Error(errReady)
 	}
 
-	wantErrStr := fmt.Sprintf("%s: %s", annotation, err1.Error())
+	st2.Ok()
+	time.Sleep(failTimeout)
 
-	if err.Error() != wantErrStr {
-		t.Errorf("error group error is not annotated, want '%s', have '%s'", wantErrStr, err.Error())
+	switch {
+	case hasNotClosed(st1.ready, st1ReadyC, st2.ready, st2ReadyC):
+		t.Error(errNotReady)
+	case hasClosed(st3.ready, st3ReadyC, st4ReadyC):
+		t.Error(errReady)
 	}
 
-	go func() {
-		st1.Error(err2)
-	}()
-
+	st3.Ok()
 	time.Sleep(failTimeout)
 
-	err = st2.Err()
-
-	if errors.Is(err, err2) {
-		t.Errorf("wrong error, want '%v', have '%v'", err1, err2)
+	if hasNotClosed(st1.ready, st1ReadyC, st2.ready, st2ReadyC, st3.ready, st3ReadyC, st4ReadyC) {
+		t.Error(errNotReady)
 	}
 }
 
-func ErrorGroupErrorfTest(t *testing.T) {
+func DependencyErrorParentTest(t *testing.T) {
 	t.Parallel()
 
-	const annotation = "test: %w"
-
 	var (
 		err1 = errors.New("error1")
 		err2 = errors.New("error2")
-		st1  = withErrorGroup()
-		st2  = merge(st1)
+
+		st1 = withError(err1)
+		st2 = withError(err2)
+		st3 = withDependency(st1, st2)
 	)
 
-	if err := st2.Err(); err != nil {
-		t.Errorf("new error group state returned error")
+	err := st3.Err()
+
+	// err1 is higher in the tree so it must be found first
+	if !errors.Is(err, err1) {
+		t.Errorf("wrong error, want '%v', have '%v'", err1, err)
 	}
 
-	go func() {
-		st1.Errorf(annotation, err1)
-	}()
+	err = st2.Err()
+	if !errors.Is(err, err2) {
+		t.Errorf("wrong error, want '%v', have '%v'", err2, err)
+	}
+}
 
-	time.Sleep(failTimeout)
+func DependencyErrorChildrenTest(t *testing.T) {
+	t.Parallel()
 
-	err := st2.Err()
+	var (
+		err1 = errors.New("error1")
 
-	if !errors.Is(err, err1) {
-		t.Errorf("wrong error, want '%v', have '%v'", err1, err)
-	}
+		st1 = withError(err1)
+		st2 = emptyState{}
+		st3 = st2.DependsOn(st1)
+	)
 
-	wantErrStr := fmt.Sprintf("%s: %s", "test", err1.Error())
+	err := st3.Err()
 
-	if err.Error() != wantErrStr {
-		t.Errorf("error group error is not annotated, want '%s', have '%s'", wantErrStr, err.Error())
+	if !errors.Is(err, err1) {
+		t.Errorf("wrong error, want '%v', have '%v'", err1, err)
 	}
+}
 
-	go func() {
-		st1.Errorf(annotation, err2)
-	}()
-
-	time.Sleep(failTimeout)
+func DependencyErrorNilTest(t *testing.T) {
+	t.Parallel()
 
-	err = st2.Err()
+	var (
+		st1 = withError(nil)
+		st2 = emptyState{}
+		st3 = st2.DependsOn(st1)
+	)
 
-	if errors.Is(err, err2) {
-		t.Errorf("wrong error, want '%v', have '%v'", err1, err2)
+	if err := st3.Err(); err != nil {
+		t.Errorf("error must be nil, have '%v'", err)
 	}
 }
 
-// Empty
-
-func EmptyTest(t *testing.T) {
+func DependencyValueParentTest(t *testing.T) {
 	t.Parallel()
 
-	st1 := emptyState{}
+	var (
+		testKey   = key("test_key")
+		testValue = "test_value"
+		st1       = emptyState{}
+		st2       = emptyState{}
+		st3       = st2.DependsOn(st1)
+		st4       = withValue(testKey, testValue)
+		st5       = withDependency(st4, st3)
+	)
 
-	if err := st1.Err(); err != nil {
-		t.Errorf("empty state returned error")
+	value := st5.Value(testKey)
+	if value == nil {
+		t.Error("test value for test key not found")
 	}
 
-	if err := st1.Shutdown(context.Background()); err != nil {
-		t.Errorf("empty state shutdowned with error")
+	valueTyped, ok := value.(string)
+	if !ok {
+		t.Error("test value for test key has wrong type")
 	}
 
-	okDone1 := make(chan struct{})
+	if valueTyped != testValue {
+		t.Errorf("wrong test value: want %s have %s", testValue, valueTyped)
+	}
+}
 
-	go func() {
-		st1.Wait()
-		close(okDone1)
-	}()
+func DependencyValueChildrenTest(t *testing.T) {
+	t.Parallel()
 
-	time.Sleep(failTimeout)
+	var (
+		testKey   = key("test_key")
+		testValue = "test_value"
+		st1       = withValue(testKey, testValue)
+		st2       = emptyState{}
+		st3       = st2.DependsOn(st1)
+		st4       = emptyState{}
+		st5       = st4.DependsOn(st3)
+	)
 
-	if hasNotClosed(okDone1) {
-		t.Errorf("empty state blocked on wait")
+	value := st5.Value(testKey)
+	if value == nil {
+		t.Error("test value for test key not found")
 	}
 
-	readyC := st1.Ready()
-	if hasNotClosed(readyC) {
-		t.Errorf("empty state is not ready")
+	valueTyped, ok := value.(string)
+	if !ok {
+		t.Error("test value for test key has wrong type")
 	}
 
-	if value := st1.Value(""); value != nil {
-		t.Errorf("empty state returned value")
+	if valueTyped != testValue {
+		t.Errorf("wrong test value: want %s have %s", testValue, valueTyped)
 	}
+}
 
-	okDone2 := make(chan struct{})
+func DependencyAnnotationTest(t *testing.T) {
+	t.Parallel()
 
-	go func() {
-		st1.close()
-		close(okDone2)
-	}()
+	var (
+		st1 = emptyState{}
+		st2 = emptyState{}
+		st3 = withAnnotation("", st1, st2)
+		st4 = emptyState{}
+		st5 = withDependency(st3, st4)
+	)
 
-	time.Sleep(failTimeout)
+	if st5.parent == nil {
+		t.Errorf("parent of dependency state is nil, must be empty state")
+	}
 
-	if hasNotClosed(okDone2) {
-		t.Errorf("empty state blocked on close")
+	if st5.parent != st3 {
+		t.Errorf("wrong parent of dependency state")
 	}
 
-	if hasNotClosed(st1.finishSig()) {
-		t.Errorf("empty state is not done")
+	if len(st5.children.states) != 1 {
+		t.Errorf("wrong number of dependency state children, want 1, have %d", len(st5.children.states))
+		return
 	}
 
-	if err := st1.cause(); err != nil {
-		t.Errorf("empty state cause call returned error")
+	if st5.children.states[0] != st4 {
+		t.Errorf("wrong children of dependency state")
 	}
 }
 
-// Dependency
-
-func DependencyShutdownTest(t *testing.T) {
+func DependencyChainTest(t *testing.T) {
 	t.Parallel()
 
 	var (
@@ -991,48 +5719,53 @@ func DependencyShutdownTest(t *testing.T) {
 		okDone1 = runShutdownable(st1)
 		okDone2 = runShutdownable(st2)
 		okDone3 = runShutdownable(st3)
-	)
-
-	st4 := withDependency(st3, st1, st2)
 
-	if hasClosed(
-		st1.end, st2.end, st3.end,
-		st1.done, st2.done, st3.done,
-	) {
-		t.Error(errInitClosed)
-	}
+		st4 = ChainDepends(st1, nil, st2, st3)
+	)
 
 	go st4.close()
 	time.Sleep(failTimeout)
 
 	switch {
-	case hasNotClosed(st1.end, st2.end):
+	case hasNotClosed(st3.end):
 		t.Error(errNotClosed)
-	case hasClosed(st3.end):
+	case hasClosed(st1.end, st2.end):
 		t.Error(errClosed)
-	case hasClosed(st1.done, st2.done, st3.done):
-		t.Error(errFinished)
 	}
 
-	closeChanAndPropagate(okDone1, okDone2)
+	closeChanAndPropagate(okDone3)
 
 	switch {
-	case hasNotClosed(st3.end):
+	case hasNotClosed(st2.end):
 		t.Error(errNotClosed)
-	case hasNotClosed(st1.done, st2.done):
-		t.Error(errNotFinished)
-	case hasClosed(st3.done):
-		t.Error(errFinished)
+	case hasClosed(st1.end):
+		t.Error(errClosed)
 	}
 
-	closeChanAndPropagate(okDone3)
+	closeChanAndPropagate(okDone2, okDone1)
 
-	if hasNotClosed(st3.done) {
+	if hasNotClosed(st1.done, st4.finishSig()) {
 		t.Error(errNotFinished)
 	}
 }
 
-func DependencyShutdownChainTest(t *testing.T) {
+func DependencyReduceEmptyTest(t *testing.T) {
+	t.Parallel()
+
+	if st := ChainDepends(nil); st != Empty() {
+		t.Errorf("reduce of no states didn't return empty state")
+	}
+
+	st := withShutdown()
+
+	merge := func(acc, next State) State { return Merge(acc, next) }
+
+	if have := Reduce([]State{st}, merge); have != st {
+		t.Errorf("reduce of single state didn't return the state")
+	}
+}
+
+func DependencyOrderedTest(t *testing.T) {
 	t.Parallel()
 
 	var (
@@ -1043,388 +5776,557 @@ func DependencyShutdownChainTest(t *testing.T) {
 		okDone1 = runShutdownable(st1)
 		okDone2 = runShutdownable(st2)
 		okDone3 = runShutdownable(st3)
-	)
-
-	st4 := withDependency(st3, st1)
-	st4 = withDependency(st4, st2)
 
-	if hasClosed(
-		st1.end, st2.end, st3.end,
-		st1.done, st2.done, st3.done, st4.finished,
-	) {
-		t.Error(errInitClosed)
-	}
+		st4 = DependsOnOrdered(st3, st1, st2)
+	)
 
 	go st4.close()
 	time.Sleep(failTimeout)
 
 	switch {
-	case hasNotClosed(st2.end):
+	case hasNotClosed(st1.end):
 		t.Error(errNotClosed)
-	case hasClosed(st1.end, st3.end):
+	case hasClosed(st2.end, st3.end):
 		t.Error(errClosed)
-	case hasClosed(st1.done, st2.done, st3.done, st4.finished):
-		t.Error(errFinished)
 	}
 
-	closeChanAndPropagate(okDone2)
+	closeChanAndPropagate(okDone1)
 
 	switch {
-	case hasNotClosed(st1.end):
+	case hasNotClosed(st2.end):
 		t.Error(errNotClosed)
-	case hasNotClosed(st2.done):
-		t.Error(errNotFinished)
 	case hasClosed(st3.end):
 		t.Error(errClosed)
-	case hasClosed(st1.done, st3.done, st4.finished):
-		t.Error(errFinished)
 	}
 
-	closeChanAndPropagate(okDone1)
+	closeChanAndPropagate(okDone2)
 
-	switch {
-	case hasNotClosed(st3.end):
+	if hasNotClosed(st3.end) {
 		t.Error(errNotClosed)
-	case hasNotClosed(st1.done):
-		t.Error(errNotFinished)
-	case hasClosed(st3.done, st4.finished):
-		t.Error(errFinished)
 	}
 
 	closeChanAndPropagate(okDone3)
 
-	if hasNotClosed(st3.done, st4.finished) {
+	if hasNotClosed(st4.finishSig()) {
 		t.Error(errNotFinished)
 	}
 }
 
-func DependencyShutdownSuccessiveCloseTest(t *testing.T) {
+func DependencyOrderedChildrenFinishedTest(t *testing.T) {
 	t.Parallel()
 
-	defer func() {
-		if r := recover(); r != nil {
-			t.Errorf("successive close call caused panic")
-		}
-	}()
-
 	var (
 		st1 = withShutdown()
 		st2 = withShutdown()
+		st3 = DependsOnOrdered(Empty(), st1, st2).(*dependState)
+
+		okDone1 = runShutdownable(st1)
+		okDone2 = runShutdownable(st2)
 	)
 
-	close(runShutdownable(st1))
-	close(runShutdownable(st2))
+	close(okDone1)
+	close(okDone2)
 
-	st4 := withDependency(st1, st2)
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
 
-	go st4.close()
-	time.Sleep(failTimeout)
+	if err := st3.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
 
-	st4.close()
+	// the group of children is finished along with the children
+	if hasNotClosed(st3.children.finishSig()) {
+		t.Error(errNotFinished)
+	}
 }
 
-func DependencyShutdownChildrenTimeoutTest(t *testing.T) {
+func DependencyDataTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		reader   = withShutdown()
+		provider = withShutdown()
+
+		okDoneReader   = runShutdownable(reader)
+		okDoneProvider = runShutdownable(provider)
+
+		st = WithDataDependency(reader, provider)
+	)
+
+	closingReader, _ := awaitPhases(t, reader)
+
+	go func() { _ = st.Shutdown(context.Background()) }()
+
+	await(t, closingReader)
+
+	if hasClosed(provider.end) {
+		t.Error(errClosed)
+	}
+
+	_, closedProvider := awaitPhases(t, provider)
+
+	close(okDoneReader)
+	close(okDoneProvider)
+	await(t, closedProvider)
+
+	if hasNotClosed(reader.done) {
+		t.Error(errNotFinished)
+	}
+}
+
+func DependencyValueCachedTest(t *testing.T) {
+	t.Parallel()
+
+	const (
+		key1 key = "key1"
+		key2 key = "key2"
+		key3 key = "key3"
+	)
+
+	var (
+		st1, tail1 = WithMutableValue(key1, "value1")
+		st2        = withValue(key2, "value2")
+		st         = DependsOnCached(withWait(), st1, st2)
+	)
+
+	for i := 0; i < 2; i++ {
+		if v := st.Value(key2); v != "value2" {
+			t.Errorf("wrong value, want 'value2', have '%v'", v)
+		}
+
+		if v := st.Value(key3); v != nil {
+			t.Errorf("unexpected value: %v", v)
+		}
+	}
+
+	if v := st.Value(key1); v != "value1" {
+		t.Errorf("wrong value, want 'value1', have '%v'", v)
+	}
+
+	// mutable values are not cached
+	tail1("updated")
+
+	if v := st.Value(key1); v != "updated" {
+		t.Errorf("wrong value, want 'updated', have '%v'", v)
+	}
+
+	// misses are not cached, so values of restarted children are found
+	var (
+		starts  int
+		errTail ErrTail
+	)
+
+	sup := Supervise(RestartStrategy{Mode: OneForOne, MaxRestarts: 1}, func() State {
+		starts++
+		if starts > 1 {
+			return withValue(key3, "value3")
+		}
+
+		var st State
+		st, errTail = WithErrorGroup()
+
+		return st
+	})
+
+	st = DependsOnCached(withWait(), sup)
+
+	if v := st.Value(key3); v != nil {
+		t.Errorf("unexpected value: %v", v)
+	}
+
+	errTail.Error(errors.New("error"))
+
+	deadline := time.Now().Add(failTimeout)
+
+	for st.Value(key3) != "value3" {
+		if time.Now().After(deadline) {
+			t.Fatal("value of restarted child is not found")
+		}
+
+		runtime.Gosched()
+	}
+}
+
+func DependencyGraphTest(t *testing.T) {
 	t.Parallel()
 
 	var (
 		st1 = withShutdown()
-		st2 = emptyState{}
-		st3 = st1.DependsOn(st2)
+		st2 = withShutdown()
+		st3 = withShutdown()
+
+		okDone1 = runShutdownable(st1)
+		okDone2 = runShutdownable(st2)
+		okDone3 = runShutdownable(st3)
+
+		nodes = map[string]State{"server": st1, "db": st2, "cache": st3}
+		steps = newStepper(t, nodes)
 	)
 
-	// blocked finish
-	_ = runShutdownable(st1)
+	close(okDone1)
+	close(okDone2)
+	close(okDone3)
+
+	st, err := BuildGraph(nodes, map[string][]string{
+		"server": {"cache", "db"},
+		"cache":  {"db"},
+	})
+	if err != nil {
+		t.Fatalf("build graph error: %v", err)
+	}
+
+	go st.Shutdown(context.Background())
 
-	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
-	defer cancel()
+	steps.next(st2, ShutdownClosing)
+	steps.next(st2, ShutdownClosed)
+	steps.next(st3, ShutdownClosing)
+	steps.next(st3, ShutdownClosed)
+	steps.next(st1, ShutdownClosing)
+	steps.next(st1, ShutdownClosed)
 
-	err := st3.Shutdown(ctx)
-	if !errors.Is(err, ErrTimeout) {
-		t.Errorf("blocked shutdown didn't timeout")
+	await(t, st.finishSig())
+
+	// cycle
+	_, err = BuildGraph(nodes, map[string][]string{
+		"server": {"cache"},
+		"cache":  {"db"},
+		"db":     {"server"},
+	})
+	if !errors.Is(err, ErrDependencyCycle) {
+		t.Errorf("wrong error, want '%v', have '%v'", ErrDependencyCycle, err)
+	}
+
+	if want := "dependency cycle: cache -> db -> server -> cache"; err.Error() != want {
+		t.Errorf("wrong error, want '%s', have '%s'", want, err.Error())
+	}
+
+	// unknown reference
+	_, err = BuildGraph(nodes, map[string][]string{"server": {"queue"}})
+	if !errors.Is(err, ErrUnknownNode) {
+		t.Errorf("wrong error, want '%v', have '%v'", ErrUnknownNode, err)
 	}
 }
 
-func DependencyShutdownParentTimeoutTest(t *testing.T) {
+func DependencyShutdownOrderTest(t *testing.T) {
 	t.Parallel()
 
 	var (
-		st1 = withShutdown()
-		st2 = withShutdown()
-		st3 = st1.DependsOn(st2)
+		handlers = WithAnnotation("handlers",
+			WithAnnotation("h1", withShutdown()),
+			WithAnnotation("h2", withShutdown()),
+		)
+		service = WithAnnotation("service", withShutdown())
+		db      = WithAnnotation("db", withShutdown())
+		cache   = WithAnnotation("cache", withShutdown())
+
+		st = merge(db.DependsOn(service.DependsOn(handlers)), cache)
 	)
 
-	okDone1 := runShutdownable(st1)
-	_ = runShutdownable(st2)
+	want := [][]string{
+		{"handlers: h1", "handlers: h2", "cache"},
+		{"service"},
+		{"db"},
+	}
 
-	closeChanAndPropagate(okDone1)
+	if have := ShutdownOrder(st); fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("wrong shutdown order, want %v, have %v", want, have)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
-	defer cancel()
+	ordered := DependsOnOrdered(
+		WithAnnotation("parent", withShutdown()),
+		WithAnnotation("first", withShutdown()),
+		WithAnnotation("second", withShutdown()),
+	)
 
-	err := st3.Shutdown(ctx)
-	if !errors.Is(err, ErrTimeout) {
-		t.Errorf("blocked shutdown didn't timeout")
+	want = [][]string{{"first"}, {"second"}, {"parent"}}
+
+	if have := ShutdownOrder(ordered); fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("wrong ordered shutdown order, want %v, have %v", want, have)
+	}
+
+	if order := ShutdownOrder(withWait()); order != nil {
+		t.Errorf("unexpected shutdown order: %v", order)
 	}
 }
 
-func DependencyShutdownUnclosedTest(t *testing.T) {
+// Name
+
+func NameWaitReadyTest(t *testing.T) {
 	t.Parallel()
 
 	var (
-		st1 = withShutdown()
-		st2 = withShutdown()
-		st3 = withDependency(st1, st2)
+		st1 = withReadiness()
+		st2 = withReadiness()
+		st3 = merge(withName("db", st1), withName("cache", st2))
 	)
 
-	// blocked finish
-	okDone2 := runShutdownable(st2)
-	okDone1 := runShutdownable(st1)
-
-	closeChanAndPropagate(okDone1, okDone2)
+	st1.Ok()
 
 	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
 	defer cancel()
 
-	err := st3.Shutdown(ctx)
-	if err != nil {
-		t.Errorf(errTimeout)
+	if err := WaitReadyNamed(ctx, st3, "db"); err != nil {
+		t.Errorf("ready named subtree returned error: %v", err)
 	}
 
-	if err := st3.cause(); err != nil {
-		t.Errorf(errNotFinished)
+	if err := WaitReadyNamed(ctx, st3, "cache"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unready named subtree didn't timeout: %v", err)
 	}
 }
 
-func DependencyWaitTest(t *testing.T) {
+func NameNotFoundTest(t *testing.T) {
+	t.Parallel()
+
+	err := WaitReadyNamed(context.Background(), withName("db"), "cache")
+	if !errors.Is(err, ErrNameNotFound) {
+		t.Errorf("wrong error, want '%v', have '%v'", ErrNameNotFound, err)
+	}
+}
+
+func NameShutdownBranchTest(t *testing.T) {
 	t.Parallel()
 
 	var (
-		st1 = withWait()
-		st2 = withWait()
-		st3 = withWait()
+		key1 = key("key1")
 
-		okDone1 = runWaitable(st1)
-		okDone2 = runWaitable(st2)
-		okDone3 = runWaitable(st3)
+		st1 = withShutdown()
+		st2 = withShutdown(WithValue(key1, "value"))
+		st  = MergeNamed(map[string]State{"db": st1, "cache": st2})
 
-		st4 = st3.DependsOn(st1, st2)
+		okDone1 = runShutdownable(st1)
+		okDone2 = runShutdownable(st2)
 	)
 
-	done := make(chan struct{})
+	close(okDone1)
+	close(okDone2)
 
-	go func() {
-		st4.Wait()
-		close(done)
-	}()
+	if v := st.Value(key1); v != "value" {
+		t.Errorf("unexpected value: %v", v)
+	}
 
-	time.Sleep(failTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), failTimeout)
+	defer cancel()
 
-	if hasClosed(done) {
-		t.Error(errNotWaited)
+	if err := ShutdownBranch(ctx, st, "db"); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
 	}
 
-	closeChanAndPropagate(okDone1, okDone2)
+	if hasNotClosed(st1.end) {
+		t.Error(errNotClosed)
+	}
 
-	if hasClosed(done) {
-		t.Error(errNotWaited)
+	if hasClosed(st2.end) {
+		t.Error("state outside of the branch is shut down")
 	}
 
-	closeChanAndPropagate(okDone3)
+	if err := ShutdownBranch(ctx, st, "queue"); !errors.Is(err, ErrNameNotFound) {
+		t.Errorf("expected %v, got %v", ErrNameNotFound, err)
+	}
 
-	if hasNotClosed(done) {
-		t.Error(errNotWaited)
+	if err := st.Shutdown(ctx); err != nil {
+		t.Errorf("unexpected shutdown error: %v", err)
+	}
+
+	if hasNotClosed(st2.end) {
+		t.Error(errNotClosed)
 	}
 }
 
-func DependencyReadinessTest(t *testing.T) {
+// Request context
+
+func RequestContextTest(t *testing.T) {
 	t.Parallel()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	var (
-		st1 = withReadiness()
-		st2 = withReadiness()
-		st3 = withReadiness()
+		st1 = WithRequestContext(ctx)
+		st2 = withWait(st1)
+		st3 = emptyState{}.DependsOn(st2)
 	)
 
-	st4 := withDependency(st3, st1, st2)
-
-	if hasClosed(
-		st1.ready, st2.ready, st3.ready,
-	) {
-		t.Error(errInitReady)
+	have, ok := RequestContext(st3)
+	if !ok {
+		t.Fatal("request context not found")
 	}
 
-	st1ReadyC := st1.Ready()
-	st2ReadyC := st2.Ready()
-	st3ReadyC := st3.Ready()
-	st4ReadyC := st4.Ready()
-
-	st1.Ok()
+	if have != ctx {
+		t.Errorf("wrong request context")
+	}
+}
 
-	time.Sleep(failTimeout)
+func RequestContextMissingTest(t *testing.T) {
+	t.Parallel()
 
-	switch {
-	case hasNotClosed(st1.ready, st1ReadyC):
-		t.Error(errNotReady)
-	case hasClosed(st2.ready, st3.ready, st2ReadyC, st3ReadyC, st4ReadyC):
-		t.Error(errReady)
+	if _, ok := RequestContext(withWait()); ok {
+		t.Errorf("request context found in state without it")
 	}
+}
 
-	st2.Ok()
-	time.Sleep(failTimeout)
+// Benchmarks
 
-	switch {
-	case hasNotClosed(st1.ready, st1ReadyC, st2.ready, st2ReadyC):
-		t.Error(errNotReady)
-	case hasClosed(st3.ready, st3ReadyC, st4ReadyC):
-		t.Error(errReady)
+// valueTree returns a tree of n value states with the key of the last one.
+func valueTree(n int) (st State, last interface{}) {
+	states := make([]State, 0, n)
+
+	for i := 0; i < n; i++ {
+		last = key(fmt.Sprintf("key%d", i))
+		states = append(states, withValue(last, i, withWait()))
 	}
 
-	st3.Ok()
-	time.Sleep(failTimeout)
+	for len(states) > 1 {
+		merged := make([]State, 0, len(states)/2+1)
 
-	if hasNotClosed(st1.ready, st1ReadyC, st2.ready, st2ReadyC, st3.ready, st3ReadyC, st4ReadyC) {
-		t.Error(errNotReady)
+		for i := 0; i < len(states); i += 2 {
+			merged = append(merged, merge(states[i:min(i+2, len(states))]...))
+		}
+
+		states = merged
 	}
+
+	return states[0], last
 }
 
-func DependencyErrorParentTest(t *testing.T) {
-	t.Parallel()
+func BenchmarkValueWalk(b *testing.B) {
+	st, last := valueTree(1000)
 
-	var (
-		err1 = errors.New("error1")
-		err2 = errors.New("error2")
+	b.ResetTimer()
 
-		st1 = withError(err1)
-		st2 = withError(err2)
-		st3 = withDependency(st1, st2)
-	)
+	for i := 0; i < b.N; i++ {
+		_ = st.Value(last)
+	}
+}
 
-	err := st3.Err()
+func BenchmarkValueIndexed(b *testing.B) {
+	st, last := valueTree(1000)
+	st = WithValueIndex(st)
 
-	// err1 is higher in the tree so it must be found first
-	if !errors.Is(err, err1) {
-		t.Errorf("wrong error, want '%v', have '%v'", err1, err)
-	}
+	b.ResetTimer()
 
-	err = st2.Err()
-	if !errors.Is(err, err2) {
-		t.Errorf("wrong error, want '%v', have '%v'", err2, err)
+	for i := 0; i < b.N; i++ {
+		_ = st.Value(last)
 	}
 }
 
-func DependencyErrorChildrenTest(t *testing.T) {
-	t.Parallel()
+func BenchmarkMergeGoroutines(b *testing.B) {
+	const n = 10000
 
-	var (
-		err1 = errors.New("error1")
+	b.ReportAllocs()
 
-		st1 = withError(err1)
-		st2 = emptyState{}
-		st3 = st2.DependsOn(st1)
-	)
+	for i := 0; i < b.N; i++ {
+		states := make([]State, n)
 
-	err := st3.Err()
+		for j := range states {
+			states[j], _ = WithShutdown()
+		}
 
-	if !errors.Is(err, err1) {
-		t.Errorf("wrong error, want '%v', have '%v'", err1, err)
+		before := runtime.NumGoroutine()
+		st := Merge(states...)
+		after := runtime.NumGoroutine()
+
+		b.ReportMetric(float64(after-before), "goroutines/op")
+
+		_ = st
 	}
 }
 
-func DependencyErrorNilTest(t *testing.T) {
-	t.Parallel()
+func benchmarkShutdownPeak(b *testing.B, shutdown func(ctx context.Context, st State) error) {
+	const n = 5000
 
-	var (
-		st1 = withError(nil)
-		st2 = emptyState{}
-		st3 = st2.DependsOn(st1)
-	)
+	for i := 0; i < b.N; i++ {
+		states := make([]State, n)
 
-	if err := st3.Err(); err != nil {
-		t.Errorf("error must be nil, have '%v'", err)
-	}
-}
+		for j := range states {
+			st, tail := WithShutdown()
+			tail.Detached()
+			states[j] = st
+		}
 
-func DependencyValueParentTest(t *testing.T) {
-	t.Parallel()
+		st := Merge(states...)
 
-	var (
-		testKey   = key("test_key")
-		testValue = "test_value"
-		st1       = emptyState{}
-		st2       = emptyState{}
-		st3       = st2.DependsOn(st1)
-		st4       = withValue(testKey, testValue)
-		st5       = withDependency(st4, st3)
-	)
+		var (
+			before  = runtime.NumGoroutine()
+			peak    = before
+			started = make(chan struct{})
+			stop    = make(chan struct{})
+			done    = make(chan struct{})
+		)
 
-	value := st5.Value(testKey)
-	if value == nil {
-		t.Error("test value for test key not found")
-	}
+		go func() {
+			defer close(done)
 
-	valueTyped, ok := value.(string)
-	if !ok {
-		t.Error("test value for test key has wrong type")
-	}
+			close(started)
 
-	if valueTyped != testValue {
-		t.Errorf("wrong test value: want %s have %s", testValue, valueTyped)
+			for !isClosed(stop) {
+				if g := runtime.NumGoroutine(); g > peak {
+					peak = g
+				}
+
+				runtime.Gosched()
+			}
+		}()
+
+		<-started
+
+		if err := shutdown(context.Background(), st); err != nil {
+			b.Fatal(err)
+		}
+
+		close(stop)
+		<-done
+
+		b.ReportMetric(float64(peak-before), "peak-goroutines/op")
+
+		for runtime.NumGoroutine() > before {
+			runtime.Gosched()
+		}
 	}
 }
 
-func DependencyValueChildrenTest(t *testing.T) {
-	t.Parallel()
+func BenchmarkShutdownDefault(b *testing.B) {
+	benchmarkShutdownPeak(b, func(ctx context.Context, st State) error {
+		return st.Shutdown(ctx)
+	})
+}
 
-	var (
-		testKey   = key("test_key")
-		testValue = "test_value"
-		st1       = withValue(testKey, testValue)
-		st2       = emptyState{}
-		st3       = st2.DependsOn(st1)
-		st4       = emptyState{}
-		st5       = st4.DependsOn(st3)
-	)
+func BenchmarkShutdownPooled(b *testing.B) {
+	benchmarkShutdownPeak(b, func(ctx context.Context, st State) error {
+		return ShutdownPooled(ctx, st, runtime.GOMAXPROCS(0))
+	})
+}
 
-	value := st5.Value(testKey)
-	if value == nil {
-		t.Error("test value for test key not found")
-	}
+// dependencyChain returns a chain of dependencies of depth n with
+// a value in its deepest state, built with dependsOn.
+func dependencyChain(n int, dependsOn func(parent State, children ...State) State) (st State, deepest interface{}) {
+	deepest = key("deepest")
+	st = withValue(deepest, n)
 
-	valueTyped, ok := value.(string)
-	if !ok {
-		t.Error("test value for test key has wrong type")
+	for i := 1; i < n; i++ {
+		st = dependsOn(withWait(), st)
 	}
 
-	if valueTyped != testValue {
-		t.Errorf("wrong test value: want %s have %s", testValue, valueTyped)
-	}
+	return st, deepest
 }
 
-func DependencyAnnotationTest(t *testing.T) {
-	t.Parallel()
+func BenchmarkValueDependency(b *testing.B) {
+	st, deepest := dependencyChain(50, func(parent State, children ...State) State {
+		return parent.DependsOn(children...)
+	})
 
-	var (
-		st1 = emptyState{}
-		st2 = emptyState{}
-		st3 = withAnnotation("", st1, st2)
-		st4 = emptyState{}
-		st5 = withDependency(st3, st4)
-	)
+	b.ResetTimer()
 
-	if st5.parent == nil {
-		t.Errorf("parent of dependency state is nil, must be empty state")
+	for i := 0; i < b.N; i++ {
+		_ = st.Value(deepest)
 	}
+}
 
-	if st5.parent != st3 {
-		t.Errorf("wrong parent of dependency state")
-	}
+func BenchmarkValueDependencyCached(b *testing.B) {
+	st, deepest := dependencyChain(50, DependsOnCached)
 
-	if len(st5.children.states) != 1 {
-		t.Errorf("wrong number of dependency state children, want 1, have %d", len(st5.children.states))
-		return
-	}
+	b.ResetTimer()
 
-	if st5.children.states[0] != st4 {
-		t.Errorf("wrong children of dependency state")
+	for i := 0; i < b.N; i++ {
+		_ = st.Value(deepest)
 	}
 }
@@ -0,0 +1,26 @@
+// Package statetest provides utilities for testing code that uses
+// the state package.
+package statetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lefelys/state"
+)
+
+// AssertAllFinished shuts down st with a timeout of within and fails the
+// test with paths of annotations of shutdown states that did not finish,
+// if the shutdown is not complete in time.
+func AssertAllFinished(t testing.TB, st state.State, within time.Duration) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), within)
+	defer cancel()
+
+	unfinished, err := state.ShutdownReport(ctx, st)
+	if err != nil {
+		t.Errorf("state didn't finish within %v: %v; unfinished states: %q", within, err, unfinished)
+	}
+}
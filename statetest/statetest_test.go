@@ -0,0 +1,48 @@
+package statetest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lefelys/state"
+)
+
+// recorder records failures of the test instead of failing it.
+type recorder struct {
+	testing.TB
+
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func TestAssertAllFinished(t *testing.T) {
+	t.Parallel()
+
+	st1, tail1 := state.WithShutdown()
+	st2, _ := state.WithShutdown()
+
+	go func() {
+		<-tail1.End()
+		tail1.Done()
+	}()
+
+	r := &recorder{TB: t}
+	AssertAllFinished(r, st1, time.Second)
+
+	if len(r.failures) != 0 {
+		t.Errorf("unexpected failures: %v", r.failures)
+	}
+
+	AssertAllFinished(r, state.WithAnnotation("db", st2), 10*time.Millisecond)
+
+	if len(r.failures) != 1 || !strings.Contains(r.failures[0], `unfinished states: ["db"]`) {
+		t.Errorf("unexpected failures: %v", r.failures)
+	}
+}
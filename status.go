@@ -0,0 +1,24 @@
+package state
+
+// Status is a snapshot of a state's status.
+type Status struct {
+	// Ready reports whether all readiness states in the tree are ready.
+	Ready bool
+
+	// Err is the state's error as returned by Err.
+	Err error
+
+	// Finished reports whether the state is shut down.
+	Finished bool
+}
+
+// StatusOf returns a snapshot of st's readiness, error and shutdown status
+// in one call, computed without blocking. It is suitable for health
+// endpoints.
+func StatusOf(st State) Status {
+	return Status{
+		Ready:    isClosed(st.Ready()),
+		Err:      st.Err(),
+		Finished: isClosed(st.finishSig()),
+	}
+}
@@ -0,0 +1,294 @@
+package state
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RestartMode defines which children a supervisor restarts when one of
+// them fails.
+type RestartMode int
+
+const (
+	// OneForOne restarts only the failed child.
+	OneForOne RestartMode = iota
+
+	// AllForOne shuts down all children and restarts them.
+	AllForOne
+)
+
+// RestartStrategy defines how a supervisor reacts to failures of its
+// children.
+type RestartStrategy struct {
+	// Mode defines which children are restarted.
+	Mode RestartMode
+
+	// MaxRestarts is the maximum number of restarts allowed within Window.
+	// When it is exceeded, the supervisor gives up and the failure is
+	// propagated up.
+	MaxRestarts int
+
+	// Window is the period restarts are counted in. Zero Window means
+	// restarts are counted over the whole lifetime of the supervisor.
+	Window time.Duration
+}
+
+type supervisorState struct {
+	starters []func() State
+	strategy RestartStrategy
+
+	children []State
+	stops    []chan struct{} // closed to stop watching errors of children
+	g        *group
+	restarts []time.Time
+
+	err     error
+	errC    chan struct{} // closed when the supervisor gives up
+	closing bool
+	stable  stableErr
+
+	finished chan struct{}
+
+	sync.Mutex
+}
+
+// Supervise returns new State, which children are started by starters and
+// restarted on failure according to strategy, Erlang-style.
+//
+// A child fails when an error is assigned to any state in it, for example
+// with ErrTail or by a task of WithWorker. A failed child is shut down
+// and started again by calling its starter, along with all other children
+// if strategy's Mode is AllForOne. When strategy's MaxRestarts is exceeded,
+// the supervisor stops restarting and the failure is propagated up:
+// the error of the failed child is assigned to the supervisor.
+//
+// The state behaves as a merge of currently running children. Errors of
+// restarted children are not reported by Err.
+func Supervise(strategy RestartStrategy, starters ...func() State) State {
+	return supervise(strategy, starters...)
+}
+
+func supervise(strategy RestartStrategy, starters ...func() State) *supervisorState {
+	for _, start := range starters {
+		if start == nil {
+			panic("nil supervised child starter")
+		}
+	}
+
+	s := &supervisorState{
+		starters: starters,
+		strategy: strategy,
+		children: make([]State, len(starters)),
+		stops:    make([]chan struct{}, len(starters)),
+		errC:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	for i := range starters {
+		s.start(i)
+	}
+
+	s.g = merge(s.children...)
+
+	return s
+}
+
+// start starts i-th child and watches its errors. Must be called
+// under lock.
+func (s *supervisorState) start(i int) {
+	child := s.starters[i]()
+	stop := make(chan struct{})
+
+	s.children[i] = child
+	s.stops[i] = stop
+
+	for _, sig := range errSignals(child) {
+		go func(sig <-chan struct{}) {
+			if waitSig(sig, stop) {
+				s.fail(i, child)
+			}
+		}(sig)
+	}
+}
+
+// errSignals returns error signals of states in st. Nested supervisors
+// handle failures of their children themselves, so only their own
+// signals are returned.
+func errSignals(st State) (sigs []<-chan struct{}) {
+	walk(st, func(st State, _ []string) bool {
+		if e, ok := st.(errSignaler); ok {
+			sigs = append(sigs, e.errSig())
+		}
+
+		_, nested := st.(*supervisorState)
+
+		return !nested
+	})
+
+	return sigs
+}
+
+// fail handles the failure of i-th child.
+func (s *supervisorState) fail(i int, child State) {
+	s.Lock()
+
+	if s.closing || s.err != nil || s.children[i] != child || isClosed(s.stops[i]) {
+		// Stale failure
+		s.Unlock()
+		return
+	}
+
+	restart := []int{i}
+	if s.strategy.Mode == AllForOne {
+		restart = restart[:0]
+
+		for j := range s.children {
+			restart = append(restart, j)
+		}
+	}
+
+	if !s.allowRestart(time.Now()) {
+		s.err = child.Err()
+		close(s.errC)
+		s.Unlock()
+
+		return
+	}
+
+	for _, j := range restart {
+		close(s.stops[j])
+	}
+
+	old := make([]State, 0, len(restart))
+	for _, j := range restart {
+		old = append(old, s.children[j])
+	}
+
+	s.Unlock()
+
+	// Shut down failed children in reverse order before starting them
+	// again.
+	for j := len(old) - 1; j >= 0; j-- {
+		go old[j].close()
+		<-old[j].finishSig()
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closing {
+		return
+	}
+
+	for _, j := range restart {
+		s.start(j)
+	}
+
+	s.g = merge(s.children...)
+}
+
+// allowRestart records a restart at now and reports whether it
+// is within the limit of the strategy. Must be called under lock.
+func (s *supervisorState) allowRestart(now time.Time) bool {
+	if w := s.strategy.Window; w > 0 {
+		recent := s.restarts[:0]
+
+		for _, t := range s.restarts {
+			if now.Sub(t) < w {
+				recent = append(recent, t)
+			}
+		}
+
+		s.restarts = recent
+	}
+
+	if len(s.restarts) >= s.strategy.MaxRestarts {
+		return false
+	}
+
+	s.restarts = append(s.restarts, now)
+
+	return true
+}
+
+// group returns merged running children.
+func (s *supervisorState) group() *group {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.g
+}
+
+func (s *supervisorState) Err() error {
+	countVisit()
+
+	return s.ownErr()
+}
+
+func (s *supervisorState) ownErr() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.err
+}
+
+func (s *supervisorState) errSig() <-chan struct{} {
+	return s.errC
+}
+
+func (s *supervisorState) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, s)
+}
+
+func (s *supervisorState) close() {
+	s.Lock()
+
+	if !s.closing {
+		s.closing = true
+
+		for _, stop := range s.stops {
+			if !isClosed(stop) {
+				close(stop)
+			}
+		}
+	}
+
+	g := s.g
+	s.Unlock()
+
+	go g.close()
+	<-g.finishSig()
+
+	s.Lock()
+	defer s.Unlock()
+
+	select {
+	case <-s.finished:
+		// Already closed
+	default:
+		close(s.finished)
+	}
+}
+
+func (s *supervisorState) cause() error {
+	select {
+	case <-s.finished:
+		return nil
+	default:
+		return s.group().cause()
+	}
+}
+
+func (s *supervisorState) Wait()                             { s.group().Wait() }
+func (s *supervisorState) Ready() <-chan struct{}            { return s.group().Ready() }
+func (s *supervisorState) Value(key interface{}) interface{} { return s.group().Value(key) }
+func (s *supervisorState) DependsOn(children ...State) State { return withDependency(s, children...) }
+func (s *supervisorState) finishSig() <-chan struct{}        { return s.finished }
+func (s *supervisorState) self() State                       { return s }
+func (s *supervisorState) childStates() []State              { return s.group().childStates() }
+func (s *supervisorState) kind() string                      { return "supervisor" }
+func (s *supervisorState) stableErr() *stableErr             { return &s.stable }
@@ -0,0 +1,40 @@
+package state
+
+// NewShutdownTail returns a standalone ShutdownTail that is not attached
+// to any tree, along with end, which sends the End signal to the tail the
+// same way a shutdown does and returns a channel that's closed when Done
+// or DoneErr is called.
+//
+// It lets a background job that consumes a tail be tested in isolation,
+// without constructing a full state.
+func NewShutdownTail() (tail ShutdownTail, end func() <-chan struct{}) {
+	s := withShutdown()
+
+	return s, func() <-chan struct{} {
+		go s.close()
+		return s.done
+	}
+}
+
+// NewWaitTail returns a standalone WaitTail that is not attached to any
+// tree, along with wait, which blocks until the tail's WaitGroup counter
+// is zero.
+func NewWaitTail() (tail WaitTail, wait func()) {
+	s := withWait()
+	return s, s.Wait
+}
+
+// NewErrTail returns a standalone ErrTail that is not attached to any
+// tree, along with err, which returns the error assigned to the tail.
+func NewErrTail() (tail ErrTail, err func() error) {
+	s := withErrorGroup()
+	return s, s.Err
+}
+
+// NewReadinessTail returns a standalone ReadinessTail that is not attached
+// to any tree, along with ready, which returns a channel that's closed when
+// Ok is called.
+func NewReadinessTail() (tail ReadinessTail, ready func() <-chan struct{}) {
+	s := withReadiness()
+	return s, s.Ready
+}
@@ -0,0 +1,50 @@
+package state
+
+import (
+	"context"
+	"time"
+)
+
+// WithTicker returns new shutdownable State with merged children, which
+// runs fn on every tick of interval in a background job until the state
+// is shut down.
+//
+// The ctx passed to fn is canceled when the state's shutdown starts, so
+// a long-running fn can stop early. The state is shut down after its
+// children and the running fn, if any, return.
+//
+// WithTicker panics if interval is not positive or fn is nil.
+func WithTicker(interval time.Duration, fn func(ctx context.Context), children ...State) State {
+	switch {
+	case interval <= 0:
+		panic("non-positive ticker interval")
+	case fn == nil:
+		panic("nil ticker func")
+	}
+
+	s := withShutdown(children...)
+	ticker := time.NewTicker(interval)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		<-s.End()
+		cancel()
+	}()
+
+	go func() {
+		defer s.Done()
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.End():
+				return
+			case <-ticker.C:
+				fn(ctx)
+			}
+		}
+	}()
+
+	return s
+}
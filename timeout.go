@@ -0,0 +1,61 @@
+package state
+
+import "strings"
+
+// TimeoutError is the error returned by State's Shutdown when shutdown's
+// timeout expires. It reports the path of annotations leading to the
+// first found unfinished state.
+//
+// TimeoutError unwraps to ErrTimeout or to a more specific error wrapping
+// it, such as DrainTimeoutError, so errors.Is(err, ErrTimeout) holds.
+type TimeoutError struct {
+	path []string
+	err  error
+}
+
+func newTimeoutError(err error) *TimeoutError {
+	return &TimeoutError{err: err}
+}
+
+func (e *TimeoutError) Error() string {
+	if len(e.path) == 0 {
+		return e.err.Error()
+	}
+
+	return e.Path() + ": " + e.err.Error()
+}
+
+// Unwrap returns the underlying timeout error.
+func (e *TimeoutError) Unwrap() error {
+	return e.err
+}
+
+// Path returns annotations of the stalled state's ancestors joined
+// with ": ", outermost first. Returns empty string if the stalled state
+// is not annotated.
+func (e *TimeoutError) Path() string {
+	return strings.Join(e.path, ": ")
+}
+
+// annotated returns a copy of e with annotation prepended to its path.
+func (e *TimeoutError) annotated(annotation string) *TimeoutError {
+	return &TimeoutError{
+		path: append([]string{annotation}, e.path...),
+		err:  e.err,
+	}
+}
+
+// annotateShutdown wraps shutdown error err in annotations from path
+// the same way annotation states' Shutdown does.
+func annotateShutdown(path []string, err error) error {
+	te, ok := err.(*TimeoutError)
+	if !ok {
+		return annotate(path, err)
+	}
+
+	for i := len(path) - 1; i >= 0; i-- {
+		te = te.annotated(path[i])
+	}
+
+	return te
+}
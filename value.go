@@ -1,6 +1,11 @@
 package state
 
-import "reflect"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
 
 type valueState struct {
 	*group
@@ -32,6 +37,74 @@ func WithValue(key, value interface{}, children ...State) State {
 }
 
 func withValue(key, value interface{}, children ...State) *valueState {
+	checkKey(key)
+	checkRegistered(key)
+
+	return &valueState{
+		group: merge(children...),
+		key:   key,
+		value: value,
+	}
+}
+
+// ValueOr returns value associated with key in st, or def if the key
+// is not found or its value is nil.
+func ValueOr(st State, key, def interface{}) interface{} {
+	if value := st.Value(key); value != nil {
+		return value
+	}
+
+	return def
+}
+
+// GetValueOr returns value of type T associated with key in st, or def if
+// the key is not found or its value is not of type T.
+func GetValueOr[T any](st State, key interface{}, def T) T {
+	if value, ok := st.Value(key).(T); ok {
+		return value
+	}
+
+	return def
+}
+
+var (
+	// ErrValueNotFound is returned by ValueTyped when the key is not found.
+	ErrValueNotFound = errors.New("state value not found")
+
+	// ErrValueType is returned by ValueTyped when the value is not
+	// of the requested type.
+	ErrValueType = errors.New("state value of unexpected type")
+)
+
+// ValueTyped returns value of type T associated with key in st. Instead of
+// panicking on a wrong type assertion, it returns an error wrapping
+// ErrValueNotFound if the key is not found or its value is nil, or
+// ErrValueType describing the actual type if the value is not of type T.
+func ValueTyped[T any](st State, key interface{}) (T, error) {
+	var zero T
+
+	value := st.Value(key)
+	if value == nil {
+		return zero, fmt.Errorf("%w: key %v", ErrValueNotFound, key)
+	}
+
+	typed, ok := value.(T)
+	if !ok {
+		return zero, fmt.Errorf("%w: key %v: want %s, have %T",
+			ErrValueType, key, reflect.TypeOf((*T)(nil)).Elem(), value)
+	}
+
+	return typed, nil
+}
+
+// valuer is implemented by states that hold a value assigned to a key.
+type valuer interface {
+	// keyValue returns the state's own key and value.
+	keyValue() (key, value interface{})
+}
+
+// checkKey panics if key can not be used as a state value key.
+func checkKey(key interface{}) {
 	if key == nil {
 		panic("nil state value key")
 	}
@@ -39,17 +112,13 @@ func withValue(key, value interface{}, children ...State) *valueState {
 	if !reflect.TypeOf(key).Comparable() {
 		panic("state value key is not comparable")
 	}
-
-	return &valueState{
-		group: merge(children...),
-		key:   key,
-		value: value,
-	}
 }
 
 // Value returns value assotiated with key from valueState or from its children,
 // or nil if it is not found.
 func (e *valueState) Value(key interface{}) (value interface{}) {
+	countVisit()
+
 	if e.key == key {
 		return e.value
 	}
@@ -60,3 +129,113 @@ func (e *valueState) Value(key interface{}) (value interface{}) {
 func (e *valueState) DependsOn(children ...State) State {
 	return withDependency(e, children...)
 }
+
+func (e *valueState) self() State {
+	return e
+}
+
+func (e *valueState) kind() string {
+	return "value"
+}
+
+func (e *valueState) keyValue() (key, value interface{}) {
+	return e.key, e.value
+}
+
+// LocalValue returns the value associated with key by st itself, without
+// descending into its children, or nil if st is not a value state or holds
+// a value under another key. It lets layered configurations distinguish
+// a locally set value from an inherited one.
+func LocalValue(st State, key interface{}) interface{} {
+	if v, ok := st.self().(valuer); ok {
+		if k, value := v.keyValue(); k == key {
+			return value
+		}
+	}
+
+	return nil
+}
+
+// ValueKeys returns keys of all values in st without the values, ordered
+// from top to bottom and from left to right. Each key is returned once.
+// It lets debugging tools verify which values are defined in the tree
+// without exposing possibly sensitive values.
+func ValueKeys(st State) (keys []interface{}) {
+	seen := make(map[interface{}]struct{})
+
+	walk(st, func(st State, _ []string) bool {
+		if v, ok := st.(valuer); ok {
+			key, _ := v.keyValue()
+
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				keys = append(keys, key)
+			}
+		}
+
+		return true
+	})
+
+	return keys
+}
+
+// ValueWait returns the value associated with key in st, blocking until
+// a non-nil value appears if there is none yet, for example when the
+// consumer starts before the producer sets the value with
+// WithMutableValue's setter. It returns ctx's error if ctx is done first.
+func ValueWait(ctx context.Context, st State, key interface{}) (interface{}, error) {
+	for {
+		// signals are taken before the lookup not to miss a value set
+		// in between
+		sigs := valueSignals(st, key)
+
+		if value := st.Value(key); value != nil {
+			return value, nil
+		}
+
+		if err := waitAnySig(ctx, sigs); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// valueSignals returns signals of the next change of mutable values
+// assigned to key in st.
+func valueSignals(st State, key interface{}) (sigs []<-chan struct{}) {
+	walk(st, func(st State, _ []string) bool {
+		m, ok := st.(*mutableValueState)
+		if ok && m.key == key {
+			sigs = append(sigs, m.valueSig())
+		}
+
+		return true
+	})
+
+	return sigs
+}
+
+// waitAnySig blocks until any of sigs is closed or ctx is done, in which
+// case it returns ctx's error.
+func waitAnySig(ctx context.Context, sigs []<-chan struct{}) error {
+	var (
+		fired = make(chan struct{}, len(sigs))
+		stop  = make(chan struct{})
+	)
+
+	defer close(stop)
+
+	for _, sig := range sigs {
+		go func(sig <-chan struct{}) {
+			if waitSig(sig, stop) {
+				fired <- struct{}{}
+			}
+		}(sig)
+	}
+
+	select {
+	case <-fired:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
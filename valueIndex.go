@@ -0,0 +1,118 @@
+package state
+
+type valueIndexState struct {
+	*group
+
+	index   map[interface{}]interface{}
+	mutable map[interface{}]struct{}
+}
+
+// WithValueIndex returns new State wrapping child, which resolves Value
+// calls in constant time instead of walking the tree.
+//
+// The index is a snapshot of child's values built once at construction,
+// so it must be created after the tree below is fully composed.
+// Values created with WithMutableValue are not indexed - lookups of their
+// keys fall back to walking the tree, so they stay current. Nil values
+// are not indexed, but shadow values assigned to the same key below them,
+// the same way they do when the tree is walked.
+func WithValueIndex(child State) State {
+	return withValueIndex(child)
+}
+
+func withValueIndex(child State) *valueIndexState {
+	s := &valueIndexState{
+		group:   merge(child),
+		index:   make(map[interface{}]interface{}),
+		mutable: make(map[interface{}]struct{}),
+	}
+
+	s.build(s.group, nil)
+
+	return s
+}
+
+// build indexes values in st in the order Value finds them. Keys in
+// shadowed have nil values assigned above st.
+func (s *valueIndexState) build(st State, shadowed map[interface{}]struct{}) {
+	st = st.self()
+
+	if v, ok := st.(valuer); ok {
+		key, value := v.keyValue()
+
+		if !s.resolved(key, shadowed) {
+			_, isMutable := st.(interface{ mutable() })
+
+			switch {
+			case isMutable:
+				s.mutable[key] = struct{}{}
+			case value != nil:
+				s.index[key] = value
+			default:
+				// nil value shadows the key in st's children only
+				shadowed = withKey(shadowed, key)
+			}
+		}
+	}
+
+	for _, child := range st.childStates() {
+		s.build(child, shadowed)
+	}
+}
+
+// resolved reports whether key is already resolved: indexed, mutable,
+// or shadowed by a nil value above.
+func (s *valueIndexState) resolved(key interface{}, shadowed map[interface{}]struct{}) bool {
+	if _, ok := s.index[key]; ok {
+		return true // shadowed by the topmost and the leftmost
+	}
+
+	if _, ok := s.mutable[key]; ok {
+		return true
+	}
+
+	_, ok := shadowed[key]
+
+	return ok
+}
+
+// withKey returns a copy of keys with key added.
+func withKey(keys map[interface{}]struct{}, key interface{}) map[interface{}]struct{} {
+	c := make(map[interface{}]struct{}, len(keys)+1)
+
+	for k := range keys {
+		c[k] = struct{}{}
+	}
+
+	c[key] = struct{}{}
+
+	return c
+}
+
+// Value returns indexed value assotiated with key, or nil if it is
+// not found.
+func (s *valueIndexState) Value(key interface{}) interface{} {
+	countVisit()
+
+	if value, ok := s.index[key]; ok {
+		return value
+	}
+
+	if _, ok := s.mutable[key]; ok {
+		return s.group.Value(key)
+	}
+
+	return nil
+}
+
+func (s *valueIndexState) DependsOn(children ...State) State {
+	return withDependency(s, children...)
+}
+
+func (s *valueIndexState) self() State {
+	return s
+}
+
+func (s *valueIndexState) kind() string {
+	return "valueIndex"
+}
@@ -1,12 +1,19 @@
 package state
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type waitState struct {
 	*group
 	sync.WaitGroup
+
+	count int64
 }
 
 // WaitTail detaches after waitable state initialization.
@@ -37,6 +44,54 @@ func withWait(children ...State) *waitState {
 	}
 }
 
+// WithWaitFunc returns new waitable State with merged children, which runs
+// each of fns in its own goroutine and waits until all of them return.
+//
+// Unlike WithWait, the WaitGroup counter is incremented before goroutines
+// are started and decremented when each of fns returns, so the counter
+// can not get out of sync with the running tasks.
+func WithWaitFunc(fns []func(), children ...State) State {
+	for _, fn := range fns {
+		if fn == nil {
+			panic("nil wait func")
+		}
+	}
+
+	s := withWait(children...)
+	s.Add(len(fns))
+
+	for _, fn := range fns {
+		go func(fn func()) {
+			defer s.Done()
+			fn()
+		}(fn)
+	}
+
+	return s
+}
+
+// Add adds i to the WaitGroup counter.
+func (w *waitState) Add(i int) {
+	atomic.AddInt64(&w.count, int64(i))
+	w.WaitGroup.Add(i)
+}
+
+// Done decrements the WaitGroup counter by one.
+func (w *waitState) Done() {
+	w.Add(-1)
+}
+
+// waitOwn blocks until State's own WaitGroup counter is zero, regardless
+// of children's counters.
+func (w *waitState) waitOwn() {
+	w.WaitGroup.Wait()
+}
+
+// inFlight returns the current WaitGroup counter.
+func (w *waitState) inFlight() int {
+	return int(atomic.LoadInt64(&w.count))
+}
+
 //  Wait blocks until States's and States's children counters are zero.
 func (w *waitState) Wait() {
 	w.WaitGroup.Wait()
@@ -46,3 +101,182 @@ func (w *waitState) Wait() {
 func (w *waitState) DependsOn(children ...State) State {
 	return withDependency(w, children...)
 }
+
+func (w *waitState) self() State {
+	return w
+}
+
+func (w *waitState) kind() string {
+	return "wait"
+}
+
+// Progress returns the sum of WaitGroup counters of all wait states in st,
+// which is the number of background tasks still in flight.
+func Progress(st State) (n int) {
+	seen := make(map[State]struct{})
+
+	walk(st, func(st State, _ []string) bool {
+		w, ok := st.(interface{ inFlight() int })
+		if !ok {
+			return true
+		}
+
+		if _, ok := seen[st]; !ok {
+			seen[st] = struct{}{}
+			n += w.inFlight()
+		}
+
+		return true
+	})
+
+	return n
+}
+
+// WaitWithTimeout blocks until all WaitGroup counters in st are zero or d
+// elapses, and reports whether the wait stalled, that is the counters were
+// still non-zero after d. Combined with Progress, it lets a watchdog detect
+// and log stalled draining.
+//
+// WaitGroup's Wait can not be canceled, so if the wait stalls, the goroutine
+// waiting on st stays blocked until the counters are zero.
+func WaitWithTimeout(st State, d time.Duration) (stalled bool) {
+	done := make(chan struct{})
+
+	go func() {
+		st.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// WaitCtx blocks until all WaitGroup counters in st are zero, as State's
+// Wait does, or ctx is done, in which case it returns ctx's error, so
+// a stuck task does not block draining of the whole tree indefinitely.
+//
+// WaitGroup's Wait can not be canceled, so if ctx is done first, the
+// goroutine waiting on st stays blocked until the counters are zero.
+func WaitCtx(ctx context.Context, st State) error {
+	return waitFuncCtx(ctx, st.Wait)
+}
+
+// waitFuncCtx blocks until wait returns or ctx is done, in which case it
+// returns ctx's error.
+func waitFuncCtx(ctx context.Context, wait func()) error {
+	done := make(chan struct{})
+
+	go func() {
+		wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitAny blocks until WaitGroup counters in any of direct children of st
+// are zero and returns that child. Counters of st itself are not waited
+// on, unless st has no children - then WaitAny waits on st the same way
+// State's Wait does and returns st.
+//
+// It lets alternatives race each other when completing any one of them
+// suffices.
+//
+// WaitGroup's Wait can not be canceled, so goroutines waiting on children
+// that lose the race stay blocked until their counters are zero.
+func WaitAny(st State) State {
+	children := st.self().childStates()
+	if len(children) == 0 {
+		st.Wait()
+		return st
+	}
+
+	done := make(chan State, len(children))
+
+	for _, child := range children {
+		go func(child State) {
+			child.Wait()
+			done <- child
+		}(child)
+	}
+
+	return <-done
+}
+
+// errWaitBudget is returned by WaitEachCtx for children which wait
+// exceeded its budget.
+var errWaitBudget = fmt.Errorf("wait budget exceeded: %w", ErrTimeout)
+
+// WaitEachCtx waits on st's own WaitGroup counter and then on direct
+// children of st one by one, as State's Wait does, bounding each wait
+// to per, so a stuck child does not monopolize the time of the whole drain.
+// If st has no children, st itself is waited on.
+//
+// Counters and children which wait exceeded per are reported with errors
+// annotated with their paths, joined with errors.Join; the errors wrap
+// ErrTimeout. If ctx is done first, WaitEachCtx stops and returns ctx's
+// error.
+//
+// WaitGroup's Wait can not be canceled, so goroutines waiting on children
+// which exceeded their budget stay blocked until their counters are zero.
+func WaitEachCtx(ctx context.Context, st State, per time.Duration) error {
+	var (
+		root     = st.self()
+		path     []string
+		children = root.childStates()
+		errs     []error
+	)
+
+	if a, ok := root.(annotator); ok {
+		path = append(path, a.label())
+	}
+
+	// wait reports whether ctx is done
+	wait := func(path []string, fn func()) bool {
+		waitCtx, cancel := context.WithTimeout(ctx, per)
+		err := waitFuncCtx(waitCtx, fn)
+		cancel()
+
+		switch {
+		case err == nil:
+		case ctx.Err() != nil:
+			return true
+		default:
+			errs = append(errs, annotate(path, errWaitBudget))
+		}
+
+		return false
+	}
+
+	if len(children) == 0 {
+		children = []State{st}
+		path = nil
+	} else if w, ok := root.(interface{ waitOwn() }); ok && wait(path, w.waitOwn) {
+		return ctx.Err()
+	}
+
+	for _, child := range children {
+		childPath := path
+		if a, ok := child.self().(annotator); ok {
+			childPath = append(childPath[:len(childPath):len(childPath)], a.label())
+		}
+
+		if wait(childPath, child.Wait) {
+			return ctx.Err()
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,144 @@
+package state
+
+import "fmt"
+
+// node is used for traversing the tree of states.
+type node interface {
+	// self returns the state itself. It unwraps states embedded into
+	// other structs, so the tree is traversed by the package's own states.
+	self() State
+
+	// childStates returns direct children of the state in traversal order.
+	childStates() []State
+
+	// kind returns the kind of the state, for example "shutdown".
+	kind() string
+}
+
+// annotator is implemented by states that annotate errors of their
+// children.
+type annotator interface {
+	label() string
+}
+
+// errHolder is implemented by states that hold their own error.
+type errHolder interface {
+	ownErr() error
+}
+
+// Walk traverses the tree of states in pre-order: a state first, then its
+// children from left to right, calling fn for each state with its depth,
+// which is 0 for st. If fn returns false, children of the visited state
+// are skipped.
+//
+// Children of a dependency state are its parent followed by states it
+// depends on. States embedded into other structs are visited as the
+// package's own states.
+func Walk(st State, fn func(node State, depth int) bool) {
+	walkDepth(st, 0, fn)
+}
+
+func walkDepth(st State, depth int, fn func(node State, depth int) bool) {
+	st = st.self()
+
+	if !fn(st, depth) {
+		return
+	}
+
+	for _, child := range st.childStates() {
+		walkDepth(child, depth+1, fn)
+	}
+}
+
+// Contains reports whether target is reachable within st's tree,
+// including st itself. States are compared by identity; states embedded
+// into other structs are compared as the package's own states.
+func Contains(st, target State) bool {
+	_, ok := Path(st, target)
+	return ok
+}
+
+// Path returns annotations from st down to target, including target's
+// own one, the same way errors of target are annotated by st's Err,
+// and reports whether target is reachable within st's tree. If target is
+// reachable through several branches, the path to the topmost and
+// the leftmost occurrence is returned.
+func Path(st, target State) (path []string, ok bool) {
+	target = target.self()
+
+	walk(st, func(st State, p []string) bool {
+		if ok {
+			return false
+		}
+
+		if st == target {
+			path, ok = append([]string(nil), p...), true
+		}
+
+		return !ok
+	})
+
+	return path, ok
+}
+
+// Children returns a copy of direct children of st in traversal order,
+// the same ones Walk visits at the next depth: for a dependency state,
+// its parent followed by states it depends on.
+//
+// The tree's structure is fixed at construction, except for states that
+// replace their children as a whole, such as the ones created by
+// Supervise, so Children is safe to call concurrently with any other
+// operation, including shutdown.
+func Children(st State) []State {
+	return append([]State(nil), st.self().childStates()...)
+}
+
+// walk traverses the tree of states from top to bottom and from left
+// to right, calling fn for each state. The path holds annotations from
+// the root down to the visited state, including its own one. If fn returns
+// false, children of the visited state are skipped.
+func walk(st State, fn func(st State, path []string) bool) {
+	walkPath(st, nil, fn)
+}
+
+func walkPath(st State, path []string, fn func(st State, path []string) bool) {
+	st = st.self()
+
+	if a, ok := st.(annotator); ok {
+		path = append(path[:len(path):len(path)], a.label())
+	}
+
+	if !fn(st, path) {
+		return
+	}
+
+	for _, child := range st.childStates() {
+		walkPath(child, path, fn)
+	}
+}
+
+// annotate wraps err in annotations from path the same way
+// annotation states do.
+func annotate(path []string, err error) error {
+	for i := len(path) - 1; i >= 0; i-- {
+		err = fmt.Errorf("%s: %w", path[i], err)
+	}
+
+	return err
+}
+
+// collectErrors returns errors of all states in the tree annotated
+// with their paths.
+func collectErrors(st State) (errs []error) {
+	walk(st, func(st State, path []string) bool {
+		if h, ok := st.(errHolder); ok {
+			if err := h.ownErr(); err != nil {
+				errs = append(errs, annotate(path, err))
+			}
+		}
+
+		return true
+	})
+
+	return errs
+}
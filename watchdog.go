@@ -0,0 +1,61 @@
+package state
+
+import (
+	"sync"
+	"time"
+)
+
+// watchdog is the configuration of the shutdown watchdog.
+var watchdog struct {
+	d       time.Duration
+	onStall func(paths []string)
+
+	sync.RWMutex
+}
+
+// SetShutdownWatchdog sets the package-wide shutdown watchdog: if a State's
+// Shutdown call has not completed after d, onStall is called with paths
+// of shutdown states that received the End signal, but did not call Done
+// yet. The paths hold annotations joined with ": ".
+//
+// It catches stalls caused by a forgotten Done, which otherwise hang
+// a shutdown without a deadline forever without any diagnostic. The
+// watchdog is stopped once the Shutdown call returns.
+//
+// Non-positive d or nil onStall disables the watchdog.
+func SetShutdownWatchdog(d time.Duration, onStall func(paths []string)) {
+	watchdog.Lock()
+	defer watchdog.Unlock()
+
+	watchdog.d, watchdog.onStall = d, onStall
+}
+
+// startWatchdog starts the shutdown watchdog for st and returns
+// a function stopping it.
+func startWatchdog(st State) (stop func()) {
+	watchdog.RLock()
+	d, onStall := watchdog.d, watchdog.onStall
+	watchdog.RUnlock()
+
+	if d <= 0 || onStall == nil {
+		return func() {}
+	}
+
+	timer := time.AfterFunc(d, func() {
+		if isClosed(st.finishSig()) {
+			return
+		}
+
+		var paths []string
+
+		for _, l := range leaves(st) {
+			if isClosed(l.State.(ShutdownTail).End()) && !isClosed(l.finishSig()) {
+				paths = append(paths, l.path)
+			}
+		}
+
+		onStall(paths)
+	})
+
+	return func() { timer.Stop() }
+}
@@ -0,0 +1,125 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DependsOnWeighted creates a new state from parent and children the same
+// way DependsOn does and assigns weight to the dependency, which is the
+// fraction of the shutdown's time budget allotted to shutting down
+// children when the state is shut down with ShutdownByWeight. The rest
+// of the budget is left to the parent.
+//
+// DependsOnWeighted panics if weight is not in range (0, 1].
+func DependsOnWeighted(parent State, weight float64, children ...State) State {
+	if weight <= 0 || weight > 1 {
+		panic("dependency weight out of range")
+	}
+
+	d := withDependency(parent, children...)
+	d.weight = weight
+
+	return d
+}
+
+// ShutdownByWeight gracefully shuts down st the same way State's Shutdown
+// does, but distributes ctx's deadline budget down the tree according to
+// dependencies' weights set by DependsOnWeighted: children of a weighted
+// dependency must be shut down within their share of the remaining budget,
+// otherwise the shutdown is aborted with ErrTimeout without shutting down
+// the parent. Time left unused by children is carried over to the parent.
+//
+// Budgets are distributed through merged, annotated and dependency states
+// only. If ctx has no deadline, ShutdownByWeight is the same as Shutdown.
+func ShutdownByWeight(ctx context.Context, st State) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return st.Shutdown(ctx)
+	}
+
+	return shutdownWeighted(ctx, st)
+}
+
+func shutdownWeighted(ctx context.Context, st State) error {
+	var err error
+
+	switch s := st.self().(type) {
+	case *group:
+		err = shutdownWeightedAll(ctx, s.states)
+	case *annotationState:
+		if err = shutdownWeightedAll(ctx, s.states); err != nil {
+			if te, ok := err.(*TimeoutError); ok {
+				return te.annotated(s.label())
+			}
+
+			return fmt.Errorf("%s: %w", s.label(), err)
+		}
+	case *dependState:
+		err = shutdownWeightedChildren(ctx, s)
+		if err == nil {
+			err = shutdownWeighted(ctx, s.parent)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return st.Shutdown(ctx)
+}
+
+// shutdownWeightedChildren shuts down children of dependency within
+// their share of the budget.
+func shutdownWeightedChildren(ctx context.Context, d *dependState) error {
+	if d.weight > 0 {
+		deadline, _ := ctx.Deadline()
+		budget := float64(time.Until(deadline)) * d.weight
+
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(budget))
+		defer cancel()
+	}
+
+	if !d.ordered {
+		return shutdownWeightedAll(ctx, d.children.states)
+	}
+
+	for _, child := range d.children.states {
+		if err := shutdownWeighted(ctx, child); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// shutdownWeightedAll shuts down states concurrently and returns the
+// first error in states order.
+func shutdownWeightedAll(ctx context.Context, states []State) error {
+	var (
+		errs = make([]error, len(states))
+		wg   sync.WaitGroup
+	)
+
+	for i, st := range states {
+		wg.Add(1)
+
+		go func(i int, st State) {
+			defer wg.Done()
+			errs[i] = shutdownWeighted(ctx, st)
+		}(i, st)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
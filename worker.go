@@ -0,0 +1,154 @@
+package state
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// WorkerTail detaches after worker state initialization.
+// The tail is supposed to be used to spawn background tasks associated
+// with created State.
+type WorkerTail interface {
+	// Go calls fn in a new goroutine and increments State's WaitGroup
+	// counter until fn returns. The first non-nil error returned by
+	// tasks is assigned to the State.
+	// If fn panics, the panic is recovered and assigned to the State
+	// as PanicError.
+	Go(fn func() error)
+}
+
+// PanicError is the error assigned to worker state when its task panics.
+type PanicError struct {
+	// Value is the value recovered from the panic.
+	Value interface{}
+
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("task panicked: %v", e.Value)
+}
+
+// Unwrap returns the recovered value if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+type workerState struct {
+	*waitState
+
+	err  error
+	errC chan struct{} // closed when error is assigned
+
+	sync.RWMutex
+}
+
+// WithWorker returns new waitable State with merged children, which runs
+// background tasks the same way errgroup does.
+//
+// The returned WorkerTail is used to spawn tasks. State's Wait blocks until
+// all tasks return, and State's Err returns the first error returned by
+// them - use WaitErr to do both at once.
+func WithWorker(children ...State) (State, WorkerTail) {
+	w := withWorker(children...)
+	return w, w
+}
+
+func withWorker(children ...State) *workerState {
+	return &workerState{
+		waitState: withWait(children...),
+		errC:      make(chan struct{}),
+	}
+}
+
+// Go calls fn in a new goroutine.
+func (w *workerState) Go(fn func() error) {
+	w.Add(1)
+
+	go func() {
+		defer w.Done()
+
+		defer func() {
+			if r := recover(); r != nil {
+				w.error(&PanicError{Value: r, Stack: debug.Stack()})
+			}
+		}()
+
+		w.error(fn())
+	}()
+}
+
+// error assigns err to the state if it has no error yet.
+func (w *workerState) error(err error) {
+	if err == nil {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	if w.err == nil {
+		w.err = err
+		close(w.errC)
+	}
+}
+
+// errSig returns a channel that's closed when error is assigned
+// to the state.
+func (w *workerState) errSig() <-chan struct{} {
+	return w.errC
+}
+
+// Err returns the first error returned by state's tasks.
+func (w *workerState) Err() error {
+	countVisit()
+
+	return w.ownErr()
+}
+
+func (w *workerState) ownErr() error {
+	w.RLock()
+	defer w.RUnlock()
+
+	return w.err
+}
+
+func (w *workerState) DependsOn(children ...State) State {
+	return withDependency(w, children...)
+}
+
+func (w *workerState) self() State {
+	return w
+}
+
+func (w *workerState) kind() string {
+	return "wait"
+}
+
+// WaitErr blocks until all WaitGroup counters in st are zero, as State's
+// Wait does, and then returns the first error returned by tasks spawned
+// with WorkerTail's Go in st, annotated the same way as Err does.
+// Returns nil if no task failed.
+func WaitErr(st State) (err error) {
+	st.Wait()
+
+	walk(st, func(st State, path []string) bool {
+		if err != nil {
+			return false
+		}
+
+		if w, ok := st.(*workerState); ok {
+			if e := w.ownErr(); e != nil {
+				err = annotate(path, e)
+				return false
+			}
+		}
+
+		return true
+	})
+
+	return err
+}